@@ -9,161 +9,69 @@ import (
 	"fmt"
 	"github.com/chrissnell/GoBalloon/aprs"
 	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/flight"
 	"github.com/chrissnell/GoBalloon/geospatial"
 	"github.com/chrissnell/GoBalloon/gps"
-	"github.com/tarm/goserial"
-	"io"
+	"github.com/chrissnell/GoBalloon/tnc"
 	"log"
-	"net"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 )
 
 type APRSTNC struct {
-	conn            io.ReadWriteCloser
-	netconn         net.Conn
-	gps             *gps.GPSReading
-	aprsPosition    chan geospatial.Point
-	aprsMessage     chan string
-	connecting      bool
-	connectingMutex sync.Mutex
-	connected       bool
-	connectedMutex  sync.Mutex
-	Remotetnc       *string
-	Localtncport    *string
-	Beaconint       *string
-	symbolTable     rune
-	symbolCode      rune
+	links        *tnc.Links
+	devices      map[string]*tnc.KISSDevice // The KISS TNCs our links are on, by kind:address
+	gps          *gps.GPSReading
+	aprsPosition chan geospatial.Point
+	aprsMessage  chan string
+	Remotetnc    *string
+	Localtncport *string
+	AGWPE        *string
+	Beaconint    *string
+	symbolTable  rune
+	symbolCode   rune
+	igate        *igateLink
+	ground       *flight.GroundStation
+	digi         *ax25.Digipeater
+	landing      *flight.LandingPredictor
+	commands     *aprs.Commands
 }
 
+// IsConnected reports whether any of our TNC links is up
 func (a *APRSTNC) IsConnected() bool {
-	a.connectedMutex.Lock()
-	defer a.connectedMutex.Unlock()
-	return a.connected
-}
-
-func (a *APRSTNC) Connected(c bool) {
-	a.connectedMutex.Lock()
-	defer a.connectedMutex.Unlock()
-	a.connected = c
+	return a.links != nil && a.links.Up()
 }
 
 func (a *APRSTNC) StartAPRS() {
 	log.Println("APRSTNC.StartAPRS()")
 
-	a.aprsMessage = make(chan string)
+	// Messages come from elsewhere in the controller (e.g. cutdown notices and wind reports)
+	a.aprsMessage = aprsMessage
 	a.aprsPosition = make(chan geospatial.Point)
 
-	// We're going to block here until the TNC connection is established
-	a.connectToTNC()
-
-	go a.incomingAPRSEventHandler()
-	go a.outgoingAPRSEventHandler()
-	go a.StartAPRSPositionBeacon()
-
-}
-
-func (a *APRSTNC) connectToTNC() {
-	if len(*a.Localtncport) > 0 {
-		// Block on setting up a new connection to the serial TNC
-		a.connectToSerialTNC()
-	} else if len(*a.Remotetnc) > 0 {
-		// Block on setting up a new connection to the serial TNC
-		a.connectToNetworkTNC()
-	} else {
-		log.Fatalln("Must provide either -remotetnc or -localtncport flag.")
+	// Bring up the links to our TNCs
+	links, err := a.newLinks()
+	if err != nil {
+		log.Fatalln(err)
 	}
+	a.links = links
+	a.links.Start()
 
-}
-
-func (a *APRSTNC) connectToSerialTNC() {
-	var err error
-
-	log.Println("APRSTNC.connectToSerialTNC()")
-
-	// This mutex controls access to the boolean that indicates when a connect/reconnect
-	// attempt is in progress
-	a.connectingMutex.Lock()
-
-	if a.connecting {
-		a.connectingMutex.Unlock()
-		log.Println("Skipping reconnect since a connection attempt is already in progress")
-		return
-	} else {
-		// A connection attempt is not in progress so we'll start a new one
-		a.connecting = true
-		a.connectingMutex.Unlock()
-
-		log.Println("Connecting to local TNC ", *a.Localtncport)
-
-		for {
-			sc := &serial.Config{Name: *a.Localtncport, Baud: 4800}
-			a.conn, err = serial.OpenPort(sc)
-			if err != nil {
-				// There is a known problem where some shitty USB <-> serial adapters will drop out and Linux
-				// will reattach them under a new device.  This code doesn't handle this situation currently
-				// but it would be a nice enhancement in the future.
-				log.Println("Sleeping 30 seconds and trying again")
-				time.Sleep(30 * time.Second)
-			} else {
-				a.Connected(true)
-				log.Printf("Connection to serial TNC on %v successful.\n", *a.Localtncport)
-
-				a.connectingMutex.Lock()
-				// Now that we've connected, we're no longer "connecting".  If a connection fails
-				// and connectToSerialTNC() is called now, it should trigger a reconnect, so we
-				// set a.connecting to false
-				a.connecting = false
-				a.connectingMutex.Unlock()
-				return
-			}
-		}
+	// We're going to block here until a TNC link is up
+	for !a.links.Up() {
+		time.Sleep(time.Second)
 	}
-}
 
-func (a *APRSTNC) connectToNetworkTNC() {
-	var err error
-
-	log.Println("APRSTNC.connectToNetworkTNC()")
-
-	// This mutex controls access to the boolean that indicates when a connect/reconnect
-	// attempt is in progress
-	a.connectingMutex.Lock()
+	go a.incomingAPRSEventHandler()
 
-	if a.connecting {
-		a.connectingMutex.Unlock()
-		log.Println("Skipping reconnect since a connection attempt is already in progress")
+	// On the ground we only listen for the balloon
+	if a.ground != nil {
 		return
-	} else {
-		// A connection attempt is not in progress so we'll start a new one
-		a.connecting = true
-		a.connectingMutex.Unlock()
+	}
 
-		log.Println("Connecting to remote TNC ", *a.Remotetnc)
+	go a.outgoingAPRSEventHandler()
+	go a.StartAPRSPositionBeacon()
 
-		for {
-			a.netconn, err = net.Dial("tcp", *a.Remotetnc)
-			if err != nil {
-				log.Printf("Could not connect to %v.  Error: %v", *a.Remotetnc, err)
-				log.Println("Sleeping 5 seconds and trying again")
-				time.Sleep(5 * time.Second)
-			} else {
-				a.Connected(true)
-				log.Printf("Connection to TNC %v successful", a.netconn.RemoteAddr())
-				a.conn = io.ReadWriteCloser(a.netconn)
-				a.netconn.SetReadDeadline(time.Now().Add(time.Minute * 3))
-				a.connectingMutex.Lock()
-				// Now that we've connected, we're no longer "connecting".  If a connection fails
-				// and connectToNetworkTNC() is called now, it should trigger a reconnect, so we
-				// set a.connecting to false
-				a.connecting = false
-				a.connectingMutex.Unlock()
-				return
-			}
-		}
-	}
 }
 
 func (a *APRSTNC) incomingAPRSEventHandler() {
@@ -172,56 +80,55 @@ func (a *APRSTNC) incomingAPRSEventHandler() {
 
 	for {
 
-		// We loop the creation of this decoder so that it is recreated in the event that
-		// the connection fails and we have to reconnect, creating a new a.conn and thus
-		// necessitating a new Decoder over that new conn.
-		d := ax25.NewDecoder(a.conn)
-
-		for {
+		// Retrieve a frame from whichever radio heard it
+		r, err := a.links.Next()
+		if err != nil {
+			log.Printf("Stopped receiving from TNCs: %v", err)
+			return
+		}
 
-			// Retrieve a packet
-			msg, err := d.Next()
-			if err != nil {
-				a.Connected(false)
-				log.Printf("Error retrieving APRS message via KISS: %v", err)
-				log.Println("Attempting to reconnect to TNC")
-				// Reconnect to the TNC and break this inner loop so that a new Decoder
-				// is created over the new connection
-				a.connectToTNC()
-				break
-			}
+		msg, err := r.Frame.APRSPacket()
+		if err != nil {
+			// Someone else's traffic
+			log.Printf("Skipping frame from %v: %v", r.Link.Transport, err)
+			continue
+		}
 
-			// Extend our read deadline on the net.Conn
-			a.netconn.SetReadDeadline(time.Now().Add(time.Minute * 3))
+		log.Printf("Incoming APRS packet received on %v: %+v\n", r.Link.Transport, msg)
 
-			log.Printf("Incoming APRS packet received: %+v\n", msg)
+		// Gate it before the parser gets its hands on it
+		if a.igate != nil {
+			a.igate.Gate(msg)
+		}
 
-			// Parse the packet
-			ad := aprs.ParsePacket(&msg)
+		if a.ground != nil {
+			a.ground.Handle(msg)
+			continue
+		}
 
-			// Look for messages addressed to the balloon
-			if ad.Message.Recipient.Callsign == balloonAddr.Callsign && ad.Message.Recipient.SSID == balloonAddr.SSID {
+		if a.digi != nil {
+			a.digipeat(msg)
+		}
 
-				if strings.Contains(strings.ToUpper(ad.Message.Text), "CUTDOWN") {
-					log.Println("CUTDOWN command received.  Initiating cutdown.")
-					// Initiate cutdown When we receive the cutdown command
-					InitiateCutdown()
-				}
-
-				// Send an ACK message in response to the cutdown command message
-				ack, err := aprs.CreateMessageACK(ad.Message)
-				if err != nil {
-					log.Printf("Error creating APRS message ACK: %v", err)
-				}
-				err = a.SendAPRSPacket(ack)
-				if err != nil {
-					log.Printf("Error sending APRS message ACK: %v", err)
-				}
-			}
+		// Parse the packet
+		ad := aprs.ParsePacket(&msg)
 
+		// Answer messages addressed to the balloon, e.g. the cutdown command
+		if a.commands != nil {
+			a.commands.Handle(ad.Message)
 		}
+	}
+}
 
+// newCommands answers messages to the balloon.  CUTDOWN cuts the balloon away.
+func (a *APRSTNC) newCommands(cutdown func()) *aprs.Commands {
+	c := aprs.NewCommands(balloonAddr, a.SendAPRSPacket)
+	c.Debug = *debug
+	c.Actions["CUTDOWN"] = func(aprs.Message) {
+		log.Println("CUTDOWN command received.  Initiating cutdown.")
+		cutdown()
 	}
+	return c
 }
 
 func (a *APRSTNC) outgoingAPRSEventHandler() {
@@ -237,8 +144,9 @@ func (a *APRSTNC) outgoingAPRSEventHandler() {
 
 		case p := <-a.aprsPosition:
 
-			// Send a postition packet
+			// Send a postition packet, with our vertical rate in the comment
 			pt := aprs.CreateCompressedPositionReport(p, a.symbolTable, a.symbolCode)
+			pt += fmt.Sprintf("Climb:%+.0fft/min", p.Climb)
 
 			log.Printf("Sending position report: %v\n", pt)
 			err := a.SendAPRSPacket(pt)
@@ -246,6 +154,21 @@ func (a *APRSTNC) outgoingAPRSEventHandler() {
 				log.Printf("Error sending position report: %v\n", err)
 			}
 
+		case o := <-aprsObject:
+
+			// Send an object report, e.g. our predicted landing point
+			ot, err := aprs.CreateObjectReport(o, '/', 'X')
+			if err != nil {
+				log.Printf("Error creating object report: %v\n", err)
+				continue
+			}
+
+			log.Printf("Sending object report: %v\n", ot)
+			err = a.SendAPRSPacket(ot)
+			if err != nil {
+				log.Printf("Error sending object report: %v\n", err)
+			}
+
 		case m := <-a.aprsMessage:
 
 			msg.Recipient.Callsign = *chasercall
@@ -308,28 +231,27 @@ func (a *APRSTNC) SendAPRSPacket(s string) error {
 		Body:   s,
 	}
 
-	packet, err := ax25.EncodeAX25Command(ap)
+	return a.writePacket(ap)
+}
+
+// writePacket sends a packet to the TNCs, waiting for a link to come back up if need be
+func (a *APRSTNC) writePacket(ap ax25.APRSPacket) error {
+
+	f, err := ax25.CommandFrame(ap)
 	if err != nil {
 		return fmt.Errorf("Unable to create packet: %v", err)
 	}
 
 	for {
-		_, err = a.conn.Write(packet)
-		if err != nil {
-			a.Connected(false)
-			log.Println("Error writing to TNC: ", err)
-			log.Println("Attempting to reconnect to TNC")
-			// Reconnect to the TNC and break this inner loop so that a new Decoder
-			// is created over the new connection
-			a.connectToTNC()
-		} else {
-			// Write was successful, so we break the loop
-			break
+		err = a.links.Send(f)
+		if err == nil || err == tnc.ErrNoTransmitter {
+			return err
 		}
+		log.Println("Error writing to TNC: ", err)
+		log.Println("Sleeping 5 seconds and trying again")
+		time.Sleep(5 * time.Second)
 	}
 
-	return nil
-
 }
 
 func (a *APRSTNC) StartAPRSPositionBeacon() {
@@ -20,6 +20,10 @@ func AltitudeCompress(a float64) []byte {
 	//  where:
 	//     a == altitude
 	//     x == our pre-compressed altitude, to be converted to Base91
+	// Altitudes at or below one foot can't be represented so we clamp them.
+	if a < 1 {
+		a = 1
+	}
 	precompAlt := int((math.Log(a) / math.Log(1.002)) + 0.5)
 
 	// Convert our pre-compressed altitude to funky APRS-style Base91
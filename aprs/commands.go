@@ -0,0 +1,119 @@
+// GoBalloon
+// commands.go - Answers APRS messages addressed to us and runs the commands they carry
+//
+// (c) 2014, Christopher Snell
+
+package aprs
+
+import (
+	"github.com/chrissnell/GoBalloon/ax25"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCommandDupWindow is how long we remember a message, so that the copies we hear
+// from each digipeater and the sender's retries run its command only once
+const DefaultCommandDupWindow = 10 * time.Minute
+
+// Commands answers messages addressed to Callsign.  Every copy of a message with an ID
+// is ACKed, as the sender keeps retrying until it hears an ACK, but a message runs its
+// command only once within DupWindow, however many copies we hear.  ACKs and REJs sent
+// to us are not answered.
+type Commands struct {
+	Callsign  ax25.APRSAddress
+	Actions   map[string]func(m Message) // Keyed by a word that, anywhere in the text, runs the action
+	Send      func(body string) error    // Transmits a message body, e.g. an ACK
+	DupWindow time.Duration
+	Debug     bool
+
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+// NewCommands returns Commands that answer messages to callsign and transmit with send
+func NewCommands(callsign ax25.APRSAddress, send func(body string) error) *Commands {
+	return &Commands{
+		Callsign:  callsign,
+		Actions:   make(map[string]func(m Message)),
+		Send:      send,
+		DupWindow: DefaultCommandDupWindow,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// Handle answers a message if it's addressed to us, and reports whether it was.  The ACK
+// goes out before any action runs, and actions run in their own goroutines, so that a
+// slow one, like a cutdown, doesn't hold up the ACK or anything else we hear.
+func (c *Commands) Handle(m Message) bool {
+	if !strings.EqualFold(m.Recipient.Callsign, c.Callsign.Callsign) || m.Recipient.SSID != c.Callsign.SSID {
+		return false
+	}
+
+	if m.ACK || m.REJ {
+		kind := "REJ"
+		if m.ACK {
+			kind = "ACK"
+		}
+		c.debugf("%v of %v from %v", kind, m.ID, m.Sender)
+		return true
+	}
+
+	if len(m.ID) > 0 {
+		ack, err := CreateMessageACK(m)
+		if err == nil {
+			err = c.Send(ack)
+		}
+		if err != nil {
+			log.Printf("Error sending APRS message ACK: %v", err)
+		}
+	}
+
+	if c.duplicate(m) {
+		c.debugf("Already handled %q from %v", m.Text, m.Sender)
+		return true
+	}
+
+	for word, action := range c.Actions {
+		if strings.Contains(strings.ToUpper(m.Text), strings.ToUpper(word)) {
+			log.Printf("%v command received from %v", word, m.Sender)
+			go action(m)
+		}
+	}
+
+	return true
+}
+
+// duplicate reports whether we've handled this message within the duplicate window, and
+// remembers it if we haven't.  Messages without an ID are known by their text.
+func (c *Commands) duplicate(m Message) bool {
+	key := m.Sender.String() + ":" + m.ID
+	if len(m.ID) == 0 {
+		key += ":" + m.Text
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	for k, t := range c.seen {
+		if now.Sub(t) > c.DupWindow {
+			delete(c.seen, k)
+		}
+	}
+
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+
+	c.seen[key] = now
+	return false
+}
+
+func (c *Commands) debugf(format string, args ...interface{}) {
+	if c.Debug {
+		log.Printf("Commands: "+format+"\n", args...)
+	}
+}
@@ -0,0 +1,46 @@
+// GoBalloon
+// maidenhead.go - Functions for creating and decoding APRS Maidenhead locator beacons
+//
+// (c) 2014, Christopher Snell
+
+package aprs
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"regexp"
+	"time"
+)
+
+// CreateMaidenheadLocatorBeacon builds an APRS Maidenhead locator beacon with a 6-character
+// grid locator, e.g. "[CN87ts]GoBalloon"
+func CreateMaidenheadLocatorBeacon(p geospatial.Point, comment string) (string, error) {
+	loc, err := geospatial.MaidenheadLocator(p.Lat, p.Lon, 6)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("[%s]%s", loc, comment), nil
+}
+
+// DecodeMaidenheadLocatorBeacon decodes an APRS Maidenhead locator beacon.  The position
+// returned is the center of the grid square.
+func DecodeMaidenheadLocatorBeacon(c string) (geospatial.Point, string, error) {
+	// Example:   [IO91SX] 35 miles NNW of London
+
+	var matches []string
+
+	mr := regexp.MustCompile(`^\[([A-Ra-r]{2}\d{2}(?:[A-Xa-x]{2})?)\](.*)$`)
+
+	if matches = mr.FindStringSubmatch(c); len(matches) == 0 {
+		return geospatial.Point{}, c, fmt.Errorf("Invalid Maidenhead locator beacon: %v", c)
+	}
+
+	p, err := geospatial.ParseMaidenheadLocator(matches[1])
+	if err != nil {
+		return p, matches[2], err
+	}
+	p.Time = time.Now()
+
+	return p, matches[2], nil
+}
@@ -0,0 +1,137 @@
+// GoBalloon
+// object.go - Functions for creating and decoding APRS object reports
+//
+// (c) 2014, Christopher Snell
+
+package aprs
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object is an APRS object: a named position reported on behalf of something that
+// isn't a station, such as a predicted landing point
+type Object struct {
+	Name     string
+	Live     bool // False if this report kills (deletes) the object
+	Position geospatial.Point
+	Comment  string
+}
+
+// CreateObjectReport builds an APRS object report with a compressed position and a
+// zulu DHM timestamp taken from the object's position
+func CreateObjectReport(o Object, symTable, symCode rune) (string, error) {
+	var buffer bytes.Buffer
+
+	if len(o.Name) == 0 || len(o.Name) > 9 {
+		return "", fmt.Errorf("Object name must be 1-9 characters: %q", o.Name)
+	}
+
+	// First byte in an object report is the data type indicator
+	buffer.WriteRune(';')
+
+	// The object's name is always nine characters, padded with spaces
+	buffer.WriteString(fmt.Sprintf("%-9s", o.Name))
+
+	if o.Live {
+		buffer.WriteRune('*')
+	} else {
+		buffer.WriteRune('_')
+	}
+
+	t := o.Position.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	buffer.WriteString(t.UTC().Format("021504"))
+	buffer.WriteRune('z')
+
+	// The rest is a compressed position, less its data type indicator
+	buffer.WriteString(CreateCompressedPositionReport(o.Position, symTable, symCode)[1:])
+
+	buffer.WriteString(o.Comment)
+
+	return buffer.String(), nil
+}
+
+// DecodeObjectReport decodes an APRS object report with either a compressed or uncompressed position
+func DecodeObjectReport(c string) (Object, rune, rune, string, error) {
+	// Example:   ;LEADER   *092345z4903.50N/07201.75W>
+
+	var matches []string
+	o := Object{}
+
+	or := regexp.MustCompile(`^;(.{9})([\*_])(\d{6})([zh/])(.*)$`)
+
+	if matches = or.FindStringSubmatch(c); len(matches) == 0 {
+		return o, ' ', ' ', c, fmt.Errorf("Invalid object report: %v", c)
+	}
+
+	o.Name = strings.TrimRight(matches[1], " ")
+	o.Live = matches[2] == "*"
+
+	pos := matches[5]
+
+	var p geospatial.Point
+	var symTable, symCode rune
+	var remains string
+	var err error
+
+	// A compressed position begins with its symbol table ID, rather than a digit
+	if len(pos) > 0 && (pos[0] < '0' || pos[0] > '9') {
+		p, symTable, symCode, remains, err = DecodeCompressedPositionReport("!" + pos)
+	} else {
+		p, symTable, symCode, remains, err = DecodeUncompressedPositionReportWithoutTimestamp("!" + pos)
+	}
+	if err != nil {
+		return o, symTable, symCode, remains, err
+	}
+
+	p.Time = decodeObjectTimestamp(matches[3], matches[4][0], time.Now())
+	o.Position = p
+	o.Comment = remains
+
+	return o, symTable, symCode, remains, nil
+}
+
+// decodeObjectTimestamp turns an object's DHM or HMS timestamp into a time.  A DHM stamp
+// is from this month unless its day is still to come, in which case it's from the most
+// recent month that has that day.
+func decodeObjectTimestamp(ts string, kind byte, now time.Time) time.Time {
+	a, _ := strconv.Atoi(ts[0:2])
+	b, _ := strconv.Atoi(ts[2:4])
+	c, _ := strconv.Atoi(ts[4:6])
+
+	switch kind {
+	case 'z', '/':
+		if a < 1 || a > 31 {
+			return now
+		}
+		loc := time.UTC
+		if kind == '/' {
+			loc = time.Local
+		}
+		now = now.In(loc)
+
+		month := now.Month()
+		if a > now.Day() {
+			month--
+		}
+		t := time.Date(now.Year(), month, a, b, c, 0, 0, loc)
+		for t.Day() != a {
+			// That month is too short, e.g. the 31st in a 30-day month
+			month--
+			t = time.Date(now.Year(), month, a, b, c, 0, 0, loc)
+		}
+		return t
+	case 'h':
+		return time.Date(now.Year(), now.Month(), now.Day(), a, b, c, 0, time.UTC)
+	}
+	return now
+}
@@ -20,6 +20,7 @@ type APRSData struct {
 	Message             Message
 	StandardTelemetry   StdTelemetryReport
 	CompressedTelemetry CompressedTelemetryReport
+	Object              Object
 	SymbolTable         rune
 	SymbolCode          rune
 	Comment             string
@@ -59,6 +60,22 @@ func ParsePacket(p *ax25.APRSPacket) *APRSData {
 		}
 	}
 
+	// Maidenhead locator beacons look like [IO91SX]
+	if len(d) >= 6 && d[0] == byte('[') {
+		ad.Position, p.Body, err = DecodeMaidenheadLocatorBeacon(p.Body)
+		if err != nil {
+			log.Printf("Error decoding Maidenhead locator beacon: %v\n", err)
+		}
+	}
+
+	// Object reports start with a ; and are at least 31 chars long
+	if len(d) >= 31 && d[0] == byte(';') {
+		ad.Object, ad.SymbolTable, ad.SymbolCode, p.Body, err = DecodeObjectReport(p.Body)
+		if err != nil {
+			log.Printf("Error decoding object report: %v\n", err)
+		}
+	}
+
 	if len(d) >= 32 {
 		// Signature of a standard uncompressed telemetry packet
 		if d[0] == byte('T') && d[1] == byte('#') && d[5] == byte(',') {
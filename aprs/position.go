@@ -9,7 +9,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/chrissnell/GoBalloon/geospatial"
-	"math"
 	"regexp"
 	"strconv"
 	"time"
@@ -17,7 +16,6 @@ import (
 
 func CreateUncompressedPositionReportWithoutTimestamp(p geospatial.Point, symTable, symCode rune, messaging bool) (string, error) {
 	var buffer bytes.Buffer
-	var lat_hem, lon_hem rune
 
 	if messaging {
 		buffer.WriteRune('=')
@@ -25,34 +23,19 @@ func CreateUncompressedPositionReportWithoutTimestamp(p geospatial.Point, symTab
 		buffer.WriteRune('!')
 	}
 
-	if math.Abs(p.Lat) > 90 {
-		return "", fmt.Errorf("Latitude is > +/- 90 degrees: %v\n", p.Lat)
+	lat, err := geospatial.FormatAPRSLatitude(p.Lat, p.Ambiguity)
+	if err != nil {
+		return "", err
 	}
 
-	if math.Abs(p.Lon) > 180 {
-		return "", fmt.Errorf("Longitude is > +/- 180 degrees: %v\n", p.Lon)
+	lon, err := geospatial.FormatAPRSLongitude(p.Lon, p.Ambiguity)
+	if err != nil {
+		return "", err
 	}
 
-	if p.Lat > 0 {
-		lat_hem = 'N'
-	} else {
-		lat_hem = 'S'
-	}
-
-	if p.Lon > 0 {
-		lon_hem = 'E'
-	} else {
-		lon_hem = 'W'
-	}
-
-	buffer.WriteString(geospatial.LatDecimalDegreesToDegreesDecimalMinutes(math.Abs(p.Lat)))
-	buffer.WriteRune(lat_hem)
-
+	buffer.WriteString(lat)
 	buffer.WriteRune(symTable)
-
-	buffer.WriteString(geospatial.LonDecimalDegreesToDegreesDecimalMinutes(math.Abs(p.Lon)))
-	buffer.WriteRune(lon_hem)
-
+	buffer.WriteString(lon)
 	buffer.WriteRune(symCode)
 
 	return buffer.String(), nil
@@ -173,37 +156,19 @@ func DecodeUncompressedPositionReportWithoutTimestamp(c string) (geospatial.Poin
 			symTable := rune(matches[4][0])
 			symCode := rune(matches[7][0])
 
-			la1, err := strconv.ParseFloat(matches[2][0:2], 64)
-			if err != nil {
-				return p, symTable, symCode, remains, err
-			}
-			la2, err := strconv.ParseFloat(matches[2][2:7], 64)
+			lat, ambiguity, err := geospatial.ParseAPRSLatitude(matches[2] + matches[3])
 			if err != nil {
 				return p, symTable, symCode, remains, err
 			}
 
-			lat := la1 + la2/60
-			if matches[3][0] == 'S' {
-				lat = 0 - lat
-			}
-
-			lo1, err := strconv.ParseFloat(matches[5][0:3], 64)
+			lon, err := geospatial.ParseAPRSLongitude(matches[5]+matches[6], ambiguity)
 			if err != nil {
 				return p, symTable, symCode, remains, err
 			}
-			lo2, err := strconv.ParseFloat(matches[5][3:8], 64)
-			if err != nil {
-				return p, symTable, symCode, remains, err
-			}
-
-			lon := lo1 + lo2/60
-
-			if matches[6][0] == 'W' {
-				lon = 0 - lon
-			}
 
 			p.Lat = lat
 			p.Lon = lon
+			p.Ambiguity = ambiguity
 
 			return p, symTable, symCode, remains, nil
 
@@ -263,37 +228,19 @@ func DecodeUncompressedPositionReportWithTimestamp(c string) (geospatial.Point,
 			symTable := rune(matches[6][0])
 			symCode := rune(matches[9][0])
 
-			la1, err := strconv.ParseFloat(matches[4][0:2], 64)
-			if err != nil {
-				return p, symTable, symCode, remains, err
-			}
-			la2, err := strconv.ParseFloat(matches[4][2:7], 64)
+			lat, ambiguity, err := geospatial.ParseAPRSLatitude(matches[4] + matches[5])
 			if err != nil {
 				return p, symTable, symCode, remains, err
 			}
 
-			lat := la1 + la2/60
-			if matches[5][0] == 'S' {
-				lat = 0 - lat
-			}
-
-			lo1, err := strconv.ParseFloat(matches[7][0:3], 64)
-			if err != nil {
-				return p, symTable, symCode, remains, err
-			}
-			lo2, err := strconv.ParseFloat(matches[7][3:8], 64)
+			lon, err := geospatial.ParseAPRSLongitude(matches[7]+matches[8], ambiguity)
 			if err != nil {
 				return p, symTable, symCode, remains, err
 			}
 
-			lon := lo1 + lo2/60
-
-			if matches[8][0] == 'W' {
-				lon = 0 - lon
-			}
-
 			p.Lat = lat
 			p.Lon = lon
+			p.Ambiguity = ambiguity
 
 			return p, symTable, symCode, remains, nil
 
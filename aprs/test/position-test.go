@@ -13,7 +13,7 @@ func main() {
 	p.Altitude = 10004
 	fmt.Printf("point: %#v\n", p)
 
-	position := aprs.CreateCompressedPositionReport(p, '/', 'O')
+	position := aprs.CreateCompressedPositionReport(*p, '/', 'O')
 	fmt.Printf("Compressed position: %v\n", position)
 
 	dp, st, sc, remains, err := aprs.DecodeCompressedPositionReport(position)
@@ -26,7 +26,7 @@ func main() {
 		fmt.Printf("Error: %v\n", err)
 	}
 
-	u_pos, err := aprs.CreateUncompressedPositionReportWithoutTimestamp(p, '/', 'O', true)
+	u_pos, err := aprs.CreateUncompressedPositionReportWithoutTimestamp(*p, '/', 'O', true)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
@@ -49,4 +49,21 @@ func main() {
 		fmt.Printf("symtable: %v   symcode: %v\n", sym_t, sym_c)
 		fmt.Printf("remains: %v\n", remains)
 	}
+
+	// The equator and prime meridian are N and E, minutes never round up to 60 and
+	// ambiguous positions decode to the middle of their box
+	for _, ep := range []geospatial.Point{
+		{Lat: 0, Lon: 0},
+		{Lat: -0.0000001, Lon: -0.0000001},
+		{Lat: 49.999999, Lon: -72.999999},
+		{Lat: 49.0583, Lon: -72.0292, Ambiguity: 2},
+	} {
+		enc, err := aprs.CreateUncompressedPositionReportWithoutTimestamp(ep, '/', 'O', false)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		dec, _, _, _, err := aprs.DecodeUncompressedPositionReportWithoutTimestamp(enc + "edge case")
+		fmt.Printf("%.7f,%.7f -> %v -> %.5f,%.5f ambiguity %v (err: %v)\n", ep.Lat, ep.Lon, enc, dec.Lat, dec.Lon, dec.Ambiguity, err)
+	}
 }
@@ -9,14 +9,18 @@
 package aprsis
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/chrissnell/GoBalloon/ax25"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/textproto"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var errEmptyMsg = errors.New("empty message")
@@ -24,14 +28,25 @@ var errInvalidMsg = errors.New("invalid message")
 
 // An APRSIS connection.
 type APRSIS struct {
-	conn   *textproto.Conn
-	rawLog io.Writer
+	conn      *textproto.Conn
+	netconn   net.Conn
+	rawLog    io.Writer
+	user      string
+	logresp   LoginResponse
+	sendMutex sync.Mutex
+	idle      time.Duration
 }
 
 // Next returns the next APRS message from this connection.
 func (a *APRSIS) Next() (rv ax25.APRSPacket, err error) {
 	var line string
 	for err == nil || err == errEmptyMsg {
+		// Servers send a "# " keepalive comment every 20 seconds or so, so any line at
+		// all proves the connection is still alive
+		if a.idle > 0 && a.netconn != nil {
+			a.netconn.SetReadDeadline(time.Now().Add(a.idle))
+		}
+
 		line, err = a.conn.ReadLine()
 		if err != nil {
 			return
@@ -71,6 +86,56 @@ func ParseAPRSISPacket(i string) ax25.APRSPacket {
 
 }
 
+// FormatTNC2 returns a packet as a TNC2-format line, e.g. N0CALL>APRS,WIDE2-1:!4903.50N/07201.75W-
+func FormatTNC2(p ax25.APRSPacket) string {
+	var b bytes.Buffer
+
+	b.WriteString(p.Source.String())
+	b.WriteByte('>')
+	b.WriteString(p.Dest.String())
+	for _, a := range p.Path {
+		b.WriteByte(',')
+		b.WriteString(a.String())
+	}
+	b.WriteByte(':')
+
+	// The APRS decoders eat into Body as they go, so prefer the original
+	if len(p.OriginalBody) > 0 {
+		b.WriteString(p.OriginalBody)
+	} else {
+		b.WriteString(p.Body)
+	}
+
+	return b.String()
+}
+
+// Send sends a packet to APRS-IS.  Packets from the callsign we logged in as go out as they
+// are and the server marks them as ours.  Anything else is taken to have been heard on RF
+// and gets a q-construct naming us as the IGate: qAR if our login was verified, qAO if not.
+func (a *APRSIS) Send(p ax25.APRSPacket) error {
+	if len(p.Source.Callsign) == 0 || len(p.Dest.Callsign) == 0 {
+		return errInvalidMsg
+	}
+
+	if !strings.EqualFold(p.Source.String(), a.user) {
+		q := "qAO"
+		if a.logresp.Verified {
+			q = "qAR"
+		}
+		p.Path = append(append([]ax25.APRSAddress{}, p.Path...), ax25.APRSAddress{Callsign: q}, AddressFromString(a.user))
+	}
+
+	line := FormatTNC2(p)
+	if strings.ContainsAny(line, "\r\n") {
+		return fmt.Errorf("Packet contains a line break: %q", line)
+	}
+
+	a.sendMutex.Lock()
+	defer a.sendMutex.Unlock()
+
+	return a.conn.PrintfLine("%s", line)
+}
+
 // Parse addresses from the array of strings that we extraced from the packet's path
 func parseAddresses(addrs []string) []ax25.APRSAddress {
 	rv := []ax25.APRSAddress{}
@@ -84,8 +149,12 @@ func parseAddresses(addrs []string) []ax25.APRSAddress {
 
 // AddressFromString builds an ax25.APRSAddress object from a string.
 func AddressFromString(s string) ax25.APRSAddress {
+	// A trailing * marks a digipeater that has repeated the packet
+	repeated := strings.HasSuffix(s, "*")
+	s = strings.TrimSuffix(s, "*")
+
 	parts := strings.Split(s, "-")
-	rv := ax25.APRSAddress{Callsign: parts[0]}
+	rv := ax25.APRSAddress{Callsign: parts[0], Repeated: repeated}
 	if len(parts) > 1 {
 		x, err := strconv.ParseInt(parts[1], 10, 32)
 		if err == nil {
@@ -100,27 +169,40 @@ func (a *APRSIS) SetRawLog(to io.Writer) {
 	a.rawLog = to
 }
 
+// SetIdleTimeout makes Next fail if nothing at all, not even a keepalive, arrives from
+// the server for d.  Zero waits forever.
+func (a *APRSIS) SetIdleTimeout(d time.Duration) {
+	a.idle = d
+}
+
 // Dial an APRS-IS service.
 func Dial(prot, addr string) (rv *APRSIS, err error) {
-	var conn *textproto.Conn
-	conn, err = textproto.Dial(prot, addr)
+	return DialTimeout(prot, addr, 0)
+}
+
+// DialTimeout dials an APRS-IS service, giving up after timeout.  Zero means no timeout.
+func DialTimeout(prot, addr string, timeout time.Duration) (rv *APRSIS, err error) {
+	var conn net.Conn
+	conn, err = net.DialTimeout(prot, addr, timeout)
 	if err != nil {
 		return
 	}
 
 	return &APRSIS{
-		conn:   conn,
-		rawLog: ioutil.Discard,
+		conn:    textproto.NewConn(conn),
+		netconn: conn,
+		rawLog:  ioutil.Discard,
 	}, nil
 }
 
-// Auth authenticates and optionally set a filter.
-func (a *APRSIS) Auth(user, pass, filter string) error {
-	if filter != "" {
-		filter = fmt.Sprintf(" filter %s", filter)
-	}
+// Close closes the connection to the APRS-IS server
+func (a *APRSIS) Close() error {
+	return a.conn.Close()
+}
 
-	//fmt.Printf("Logging in --> user  %s pass %s vers goaprs 0.1%s\n", user, pass, filter)
-	return a.conn.PrintfLine("user %s pass %s vers goaprs 0.1%s",
-		user, pass, filter)
+// Auth authenticates and optionally set a filter.  It waits for the server to answer
+// but, unlike Login, doesn't insist on a verified login.
+func (a *APRSIS) Auth(user, pass, filter string) error {
+	_, err := a.Login(user, pass, filter, false)
+	return err
 }
@@ -0,0 +1,306 @@
+// GoBalloon
+// client.go - A managed APRS-IS connection that survives stalls, drops and dead servers
+//
+// (c) 2014, Christopher Snell
+
+package aprsis
+
+import (
+	"errors"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for a managed Client
+const (
+	DefaultStallTimeout = 90 * time.Second
+	DefaultDialTimeout  = 30 * time.Second
+	DefaultMinBackoff   = time.Second
+	DefaultMaxBackoff   = 2 * time.Minute
+)
+
+// A connection that lasts this long is considered good, and resets the backoff
+const stableConnection = time.Minute
+
+// DefaultServers are the APRS2 rotates, tried in this order
+var DefaultServers = []string{"rotate.aprs2.net:14580", "noam.aprs2.net:14580", "euro.aprs2.net:14580"}
+
+// ErrNotConnected is returned when sending on a Client that isn't logged in to a server
+var ErrNotConnected = errors.New("Not connected to APRS-IS")
+
+// ConnectionState is where a Client is in its connection cycle
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateEvent reports a change in a Client's connection state
+type StateEvent struct {
+	State  ConnectionState
+	Server string
+	Login  LoginResponse // Set when State is StateConnected
+	Err    error         // Why we disconnected, if we know
+	Time   time.Time
+}
+
+// Client is a managed APRS-IS connection.  It logs in to the first server that will
+// have it, reconnects with exponential backoff when the connection drops or stalls,
+// fails over through the list of servers, and logs in again with the current filter.
+// Set the exported fields before calling Run.
+type Client struct {
+	Servers      []string
+	Callsign     string
+	Passcode     string
+	Transmit     bool // Treat an unverified login as a failure
+	StallTimeout time.Duration
+	DialTimeout  time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	RawLog       io.Writer
+	Debug        bool
+
+	// Packets receives everything the server sends us and must be drained.  Events
+	// receives state changes; it is buffered and events are dropped if nobody is listening.
+	Packets chan ax25.APRSPacket
+	Events  chan StateEvent
+
+	is        *APRSIS
+	state     ConnectionState
+	server    string
+	filter    Filters
+	mutex     sync.Mutex
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewClient returns a Client for callsign.  If no servers are given, DefaultServers are
+// used.  Use a passcode of "-1" for a receive-only connection, or "" to compute it from
+// the callsign.
+func NewClient(callsign, passcode string, servers ...string) *Client {
+	if len(servers) == 0 {
+		servers = DefaultServers
+	}
+
+	if len(passcode) == 0 {
+		passcode = strconv.Itoa(Passcode(callsign))
+	}
+
+	return &Client{
+		Servers:      servers,
+		Callsign:     callsign,
+		Passcode:     passcode,
+		StallTimeout: DefaultStallTimeout,
+		DialTimeout:  DefaultDialTimeout,
+		MinBackoff:   DefaultMinBackoff,
+		MaxBackoff:   DefaultMaxBackoff,
+		RawLog:       ioutil.Discard,
+		Packets:      make(chan ax25.APRSPacket, 100),
+		Events:       make(chan StateEvent, 10),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Run connects and keeps us connected until Close is called
+func (c *Client) Run() {
+	log.Println("aprsis.Client.Run()")
+
+	backoff := c.MinBackoff
+	next := 0
+
+	for {
+		if c.closed() {
+			return
+		}
+
+		server := c.Servers[next%len(c.Servers)]
+		next++
+
+		started := time.Now()
+		err := c.session(server)
+
+		if c.closed() {
+			return
+		}
+
+		c.setState(StateDisconnected, server, LoginResponse{}, err)
+		log.Printf("APRS-IS connection to %v ended: %v\n", server, err)
+
+		// A connection that stayed up a while earns a quick reconnect to the same server
+		if time.Since(started) > stableConnection {
+			backoff = c.MinBackoff
+			next--
+		}
+
+		// Wait, with a little jitter so a crowd of clients doesn't come back at once
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/4+1))
+		c.debugf("Reconnecting in %v", wait)
+
+		select {
+		case <-time.After(wait):
+		case <-c.stop:
+			return
+		}
+
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// session connects to one server, logs in and reads packets until something goes wrong
+func (c *Client) session(server string) error {
+	c.setState(StateConnecting, server, LoginResponse{}, nil)
+
+	is, err := DialTimeout("tcp", server, c.DialTimeout)
+	if err != nil {
+		return err
+	}
+	defer is.Close()
+
+	is.SetRawLog(c.RawLog)
+
+	c.mutex.Lock()
+	filter := c.filter.String()
+	c.mutex.Unlock()
+
+	lr, err := is.Login(c.Callsign, c.Passcode, filter, c.Transmit)
+	if err != nil {
+		return err
+	}
+
+	is.SetIdleTimeout(c.StallTimeout)
+
+	c.mutex.Lock()
+	c.is = is
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.is = nil
+		c.mutex.Unlock()
+	}()
+
+	c.setState(StateConnected, server, lr, nil)
+	log.Printf("Connected to APRS-IS server %v (%v) as %v, verified: %v\n", lr.Server, server, lr.Callsign, lr.Verified)
+
+	for {
+		p, err := is.Next()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case c.Packets <- p:
+		case <-c.stop:
+			return nil
+		}
+	}
+}
+
+// Send sends a packet through the current connection
+func (c *Client) Send(p ax25.APRSPacket) error {
+	c.mutex.Lock()
+	is := c.is
+	c.mutex.Unlock()
+
+	if is == nil {
+		return ErrNotConnected
+	}
+
+	return is.Send(p)
+}
+
+// SetFilter changes the server-side filter.  It takes effect immediately if we're
+// connected and is used every time we log in from now on.
+func (c *Client) SetFilter(fs Filters) error {
+	err := fs.Validate()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.filter = fs
+	is := c.is
+	c.mutex.Unlock()
+
+	if is == nil {
+		return nil
+	}
+
+	return is.SetFilter(fs)
+}
+
+// State returns the current connection state and the server we're using
+func (c *Client) State() (ConnectionState, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state, c.server
+}
+
+// Close disconnects and stops Run
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+
+		c.mutex.Lock()
+		if c.is != nil {
+			c.is.Close()
+		}
+		c.mutex.Unlock()
+
+		c.setState(StateClosed, "", LoginResponse{}, nil)
+	})
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) setState(s ConnectionState, server string, lr LoginResponse, err error) {
+	c.mutex.Lock()
+	c.state = s
+	c.server = server
+	c.mutex.Unlock()
+
+	select {
+	case c.Events <- StateEvent{State: s, Server: server, Login: lr, Err: err, Time: time.Now()}:
+	default:
+		c.debugf("Dropped state event: %v", s)
+	}
+}
+
+func (c *Client) debugf(format string, args ...interface{}) {
+	if c.Debug {
+		log.Printf("aprsis.Client: "+format+"\n", args...)
+	}
+}
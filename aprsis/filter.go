@@ -0,0 +1,370 @@
+// GoBalloon
+// filter.go - Typed APRS-IS server-side filters
+//
+// (c) 2014, Christopher Snell
+
+package aprsis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter is one APRS-IS server-side filter, e.g. r/47.2/-122.5/100
+type Filter interface {
+	String() string
+	Validate() error
+}
+
+// Filters is a set of filters.  The server passes a packet that matches any of them,
+// less any that match an excluded filter.
+type Filters []Filter
+
+// RangeFilter passes packets from stations within Distance km of a point (r/)
+type RangeFilter struct {
+	Lat      float64
+	Lon      float64
+	Distance float64
+}
+
+// PrefixFilter passes packets from callsigns starting with any of the prefixes (p/)
+type PrefixFilter struct {
+	Prefixes []string
+}
+
+// BudlistFilter passes packets from the listed callsigns, which may use * wildcards (b/)
+type BudlistFilter struct {
+	Calls []string
+}
+
+// ObjectFilter passes the named objects and items, which may use * wildcards (o/)
+type ObjectFilter struct {
+	Names []string
+}
+
+// TypeFilter passes packets of the given types (t/).  Types is any combination of
+// p(osition), o(bject), i(tem), m(essage), q(uery), s(tatus), t(elemetry),
+// u(ser-defined), n(WS) and w(eather).  If Call is set, only packets within Distance km
+// of that station pass.
+type TypeFilter struct {
+	Types    string
+	Call     string
+	Distance float64
+}
+
+// AreaFilter passes packets from stations inside a box (a/)
+type AreaFilter struct {
+	North float64
+	West  float64
+	South float64
+	East  float64
+}
+
+// FriendRangeFilter passes packets from stations within Distance km of another station's
+// last known position (f/)
+type FriendRangeFilter struct {
+	Call     string
+	Distance float64
+}
+
+// EntryFilter passes packets that entered APRS-IS through the listed IGates or servers,
+// which may use * wildcards (e/)
+type EntryFilter struct {
+	Calls []string
+}
+
+// ExcludeFilter turns a filter around so that the packets it matches are dropped
+type ExcludeFilter struct {
+	Filter Filter
+}
+
+const validPacketTypes = "poimqstunw"
+
+// formatNumber formats a coordinate or distance without trailing zeros
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func validateCoordinates(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("Latitude is > +/- 90 degrees: %v", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("Longitude is > +/- 180 degrees: %v", lon)
+	}
+	return nil
+}
+
+func validateDistance(d float64) error {
+	if !(d > 0) {
+		return fmt.Errorf("Filter distance must be greater than zero: %v", d)
+	}
+	return nil
+}
+
+// validateCalls checks a list of callsigns, prefixes or object names.  Slashes and
+// spaces would be taken as the end of the filter, so they're not allowed.
+func validateCalls(what string, calls []string) error {
+	if len(calls) == 0 {
+		return fmt.Errorf("%v filter needs at least one entry", what)
+	}
+	for _, c := range calls {
+		if len(c) == 0 || strings.ContainsAny(c, "/ \t\r\n") {
+			return fmt.Errorf("Invalid entry in %v filter: %q", what, c)
+		}
+	}
+	return nil
+}
+
+func (f RangeFilter) String() string {
+	return fmt.Sprintf("r/%v/%v/%v", formatNumber(f.Lat), formatNumber(f.Lon), formatNumber(f.Distance))
+}
+
+func (f RangeFilter) Validate() error {
+	if err := validateCoordinates(f.Lat, f.Lon); err != nil {
+		return err
+	}
+	return validateDistance(f.Distance)
+}
+
+func (f PrefixFilter) String() string {
+	return "p/" + strings.Join(f.Prefixes, "/")
+}
+
+func (f PrefixFilter) Validate() error {
+	return validateCalls("Prefix", f.Prefixes)
+}
+
+func (f BudlistFilter) String() string {
+	return "b/" + strings.Join(f.Calls, "/")
+}
+
+func (f BudlistFilter) Validate() error {
+	return validateCalls("Budlist", f.Calls)
+}
+
+func (f ObjectFilter) String() string {
+	return "o/" + strings.Join(f.Names, "/")
+}
+
+func (f ObjectFilter) Validate() error {
+	return validateCalls("Object", f.Names)
+}
+
+func (f TypeFilter) String() string {
+	if len(f.Call) > 0 {
+		return fmt.Sprintf("t/%v/%v/%v", f.Types, f.Call, formatNumber(f.Distance))
+	}
+	return "t/" + f.Types
+}
+
+func (f TypeFilter) Validate() error {
+	if len(f.Types) == 0 {
+		return fmt.Errorf("Type filter needs at least one type")
+	}
+	for _, t := range f.Types {
+		if !strings.ContainsRune(validPacketTypes, t) {
+			return fmt.Errorf("Invalid packet type %q in type filter.  Use any of %v.", t, validPacketTypes)
+		}
+	}
+	if len(f.Call) > 0 {
+		if err := validateCalls("Type", []string{f.Call}); err != nil {
+			return err
+		}
+		return validateDistance(f.Distance)
+	}
+	return nil
+}
+
+func (f AreaFilter) String() string {
+	return fmt.Sprintf("a/%v/%v/%v/%v", formatNumber(f.North), formatNumber(f.West), formatNumber(f.South), formatNumber(f.East))
+}
+
+func (f AreaFilter) Validate() error {
+	if err := validateCoordinates(f.North, f.West); err != nil {
+		return err
+	}
+	if err := validateCoordinates(f.South, f.East); err != nil {
+		return err
+	}
+	if f.North < f.South {
+		return fmt.Errorf("Area filter's north edge (%v) is south of its south edge (%v)", f.North, f.South)
+	}
+	if f.West > f.East {
+		return fmt.Errorf("Area filter's west edge (%v) is east of its east edge (%v); areas can't cross 180°", f.West, f.East)
+	}
+	return nil
+}
+
+func (f FriendRangeFilter) String() string {
+	return fmt.Sprintf("f/%v/%v", f.Call, formatNumber(f.Distance))
+}
+
+func (f FriendRangeFilter) Validate() error {
+	if err := validateCalls("Friend range", []string{f.Call}); err != nil {
+		return err
+	}
+	return validateDistance(f.Distance)
+}
+
+func (f EntryFilter) String() string {
+	return "e/" + strings.Join(f.Calls, "/")
+}
+
+func (f EntryFilter) Validate() error {
+	return validateCalls("Entry station", f.Calls)
+}
+
+func (f ExcludeFilter) String() string {
+	if f.Filter == nil {
+		return "-"
+	}
+	return "-" + f.Filter.String()
+}
+
+func (f ExcludeFilter) Validate() error {
+	if f.Filter == nil {
+		return fmt.Errorf("Exclude filter has nothing to exclude")
+	}
+	if _, ok := f.Filter.(ExcludeFilter); ok {
+		return fmt.Errorf("Can't exclude an exclude filter")
+	}
+	return f.Filter.Validate()
+}
+
+// String returns the filters in the form the server expects, separated by spaces
+func (fs Filters) String() string {
+	s := make([]string, 0, len(fs))
+	for _, f := range fs {
+		s = append(s, f.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// Validate checks every filter in the set
+func (fs Filters) Validate() error {
+	for _, f := range fs {
+		if f == nil {
+			return fmt.Errorf("Nil filter")
+		}
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFilter replaces the server-side filter on an open connection
+func (a *APRSIS) SetFilter(fs Filters) error {
+	err := fs.Validate()
+	if err != nil {
+		return err
+	}
+
+	a.sendMutex.Lock()
+	defer a.sendMutex.Unlock()
+
+	return a.conn.PrintfLine("#filter %s", fs.String())
+}
+
+// ParseFilters parses a space-separated filter string such as the one sent at login or
+// with #filter, e.g. "r/47.2/-122.5/100 b/N0CALL* -t/w"
+func ParseFilters(s string) (Filters, error) {
+	var fs Filters
+
+	for _, field := range strings.Fields(s) {
+		f, err := ParseFilter(field)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	return fs, fs.Validate()
+}
+
+// ParseFilter parses a single filter, e.g. "r/47.2/-122.5/100"
+func ParseFilter(s string) (Filter, error) {
+	if strings.HasPrefix(s, "-") {
+		f, err := ParseFilter(s[1:])
+		if err != nil {
+			return nil, err
+		}
+		return ExcludeFilter{Filter: f}, nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts[0]) != 1 {
+		return nil, fmt.Errorf("Invalid filter: %q", s)
+	}
+	args := parts[1:]
+
+	nums := func(want int) ([]float64, error) {
+		if len(args) != want {
+			return nil, fmt.Errorf("Filter %q needs %v parameters", s, want)
+		}
+		n := make([]float64, want)
+		for i, a := range args {
+			var err error
+			n[i], err = strconv.ParseFloat(a, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid number %q in filter %q", a, s)
+			}
+		}
+		return n, nil
+	}
+
+	var f Filter
+
+	switch parts[0] {
+	case "r":
+		n, err := nums(3)
+		if err != nil {
+			return nil, err
+		}
+		f = RangeFilter{Lat: n[0], Lon: n[1], Distance: n[2]}
+	case "p":
+		f = PrefixFilter{Prefixes: args}
+	case "b":
+		f = BudlistFilter{Calls: args}
+	case "o":
+		f = ObjectFilter{Names: args}
+	case "t":
+		tf := TypeFilter{Types: args[0]}
+		switch len(args) {
+		case 1:
+		case 3:
+			d, err := strconv.ParseFloat(args[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid distance %q in filter %q", args[2], s)
+			}
+			tf.Call = args[1]
+			tf.Distance = d
+		default:
+			return nil, fmt.Errorf("Filter %q needs 1 or 3 parameters", s)
+		}
+		f = tf
+	case "a":
+		n, err := nums(4)
+		if err != nil {
+			return nil, err
+		}
+		f = AreaFilter{North: n[0], West: n[1], South: n[2], East: n[3]}
+	case "f":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("Filter %q needs 2 parameters", s)
+		}
+		d, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid distance %q in filter %q", args[1], s)
+		}
+		f = FriendRangeFilter{Call: args[0], Distance: d}
+	case "e":
+		f = EntryFilter{Calls: args}
+	default:
+		return nil, fmt.Errorf("Unsupported filter type %q", parts[0])
+	}
+
+	return f, f.Validate()
+}
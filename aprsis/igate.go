@@ -0,0 +1,170 @@
+// GoBalloon
+// igate.go - An RF-to-Internet IGate that forwards packets heard on RF to APRS-IS
+//
+// (c) 2014, Christopher Snell
+
+package aprsis
+
+import (
+	"github.com/chrissnell/GoBalloon/ax25"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDupWindow is how long an IGate remembers a packet to suppress duplicates of it,
+// e.g. the copies we hear from each digipeater that repeats it
+const DefaultDupWindow = 30 * time.Second
+
+// Packets with any of these in their path must never be gated to APRS-IS
+var noGateCalls = []string{"TCPIP", "TCPXX", "NOGATE", "RFONLY"}
+
+// Sender is anything that can send packets to APRS-IS: an APRSIS connection or a Client
+type Sender interface {
+	Send(p ax25.APRSPacket) error
+}
+
+// IGate gates packets heard on RF to APRS-IS, following the usual IGate rules:
+//
+//   - packets with TCPIP, TCPXX, NOGATE, RFONLY or a q-construct in their path are not gated
+//   - third-party packets are unwrapped and the inner packet gated, unless it came
+//     from the Internet in the first place
+//   - queries are answered locally, not gated
+//   - duplicates heard within DupWindow are gated only once
+type IGate struct {
+	IS        Sender
+	DupWindow time.Duration
+	Debug     bool
+	seen      map[string]time.Time
+	seenMutex sync.Mutex
+}
+
+// NewIGate returns an IGate that sends to a logged-in APRS-IS connection or a Client
+func NewIGate(is Sender) *IGate {
+	return &IGate{
+		IS:        is,
+		DupWindow: DefaultDupWindow,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// Gate sends a packet heard on RF to APRS-IS if the IGate rules allow it.  It reports
+// whether the packet was sent.
+func (g *IGate) Gate(p ax25.APRSPacket) (bool, error) {
+	body := p.OriginalBody
+	if len(body) == 0 {
+		body = p.Body
+	}
+
+	if len(body) == 0 || len(p.Source.Callsign) == 0 {
+		return false, nil
+	}
+
+	if blocksGating(p.Path) {
+		g.debugf("Not gating %v: path forbids it", p.Source)
+		return false, nil
+	}
+
+	// Third-party packets carry a whole TNC2 packet after the }
+	if body[0] == '}' {
+		inner := ParseAPRSISPacket(body[1:])
+		if len(inner.Source.Callsign) == 0 {
+			return false, nil
+		}
+		if blocksGating(inner.Path) {
+			g.debugf("Not gating third-party packet from %v: it came from the Internet", inner.Source)
+			return false, nil
+		}
+		p = inner
+		body = inner.Body
+		if len(body) == 0 {
+			return false, nil
+		}
+	}
+
+	if body[0] == '?' {
+		g.debugf("Not gating query from %v", p.Source)
+		return false, nil
+	}
+
+	if g.duplicate(p.Source.String() + ">" + p.Dest.String() + ":" + body) {
+		g.debugf("Not gating duplicate from %v", p.Source)
+		return false, nil
+	}
+
+	p.Body = body
+	p.OriginalBody = body
+
+	err := g.IS.Send(p)
+	if err != nil {
+		return false, err
+	}
+
+	g.debugf("Gated %v", FormatTNC2(p))
+
+	return true, nil
+}
+
+// Run gates everything the decoder hears until it returns an error
+func (g *IGate) Run(d *ax25.Decoder) error {
+	for {
+		p, err := d.Next()
+		if ax25.IsFrameError(err) {
+			g.debugf("Skipping frame: %v", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = g.Gate(p)
+		if err != nil {
+			log.Printf("Error gating packet to APRS-IS: %v\n", err)
+		}
+	}
+}
+
+// duplicate reports whether we've gated this packet within the duplicate window, and
+// remembers it if we haven't
+func (g *IGate) duplicate(key string) bool {
+	g.seenMutex.Lock()
+	defer g.seenMutex.Unlock()
+
+	now := time.Now()
+
+	for k, t := range g.seen {
+		if now.Sub(t) > g.DupWindow {
+			delete(g.seen, k)
+		}
+	}
+
+	if _, ok := g.seen[key]; ok {
+		return true
+	}
+
+	g.seen[key] = now
+	return false
+}
+
+func (g *IGate) debugf(format string, args ...interface{}) {
+	if g.Debug {
+		log.Printf("IGate: "+format+"\n", args...)
+	}
+}
+
+func blocksGating(path []ax25.APRSAddress) bool {
+	for _, a := range path {
+		call := strings.ToUpper(strings.TrimSuffix(a.Callsign, "*"))
+		// A q-construct means the packet has already been through APRS-IS
+		if strings.HasPrefix(call, "QA") && len(call) == 3 {
+			return true
+		}
+		for _, ng := range noGateCalls {
+			if call == ng {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// GoBalloon
+// login.go - APRS-IS passcodes, login and server responses
+//
+// (c) 2014, Christopher Snell
+
+package aprsis
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The software name and version we send when logging in to APRS-IS
+const (
+	SoftwareName    = "GoBalloon"
+	SoftwareVersion = "0.2"
+)
+
+// How long we'll wait for the server to answer our login
+const loginTimeout = 30 * time.Second
+
+// ErrUnverified is returned by Login when we asked to transmit but the server did not
+// accept our passcode.  The server will silently drop anything we send.
+var ErrUnverified = errors.New("APRS-IS login is unverified; packets sent will be dropped")
+
+var logrespRE = regexp.MustCompile(`^#\s*logresp\s+(\S+)\s+(verified|unverified)(?:,\s*server\s+(\S+))?`)
+
+// LoginResponse is what the server told us about ourselves when we logged in
+type LoginResponse struct {
+	Callsign       string // Callsign as the server understood it
+	Verified       bool   // Passcode accepted; we may transmit
+	Server         string // Server name from logresp, e.g. T2TEXAS
+	ServerSoftware string // Server software from the banner, e.g. aprsc
+	ServerVersion  string // Server software version from the banner
+}
+
+// Passcode computes the APRS-IS passcode for a callsign.  The SSID is ignored.
+func Passcode(call string) int {
+	call = strings.ToUpper(strings.SplitN(call, "-", 2)[0])
+
+	hash := 0x73e2
+	for i := 0; i < len(call); i += 2 {
+		hash ^= int(call[i]) << 8
+		if i+1 < len(call) {
+			hash ^= int(call[i+1])
+		}
+	}
+
+	return hash & 0x7fff
+}
+
+// ParseLogresp parses the server's "# logresp" line
+func ParseLogresp(line string) (LoginResponse, error) {
+	m := logrespRE.FindStringSubmatch(line)
+	if m == nil {
+		return LoginResponse{}, fmt.Errorf("Not a logresp line: %q", line)
+	}
+
+	return LoginResponse{
+		Callsign: m[1],
+		Verified: m[2] == "verified",
+		Server:   m[3],
+	}, nil
+}
+
+// ParseBanner parses the comment line that an APRS-IS server sends when we connect,
+// e.g. "# aprsc 2.1.4-g408ed49", into the server software and version
+func ParseBanner(line string) (software, version string, err error) {
+	if !strings.HasPrefix(line, "#") {
+		return "", "", fmt.Errorf("Not a server banner: %q", line)
+	}
+
+	f := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(f) == 0 || f[0] == "logresp" {
+		return "", "", fmt.Errorf("Not a server banner: %q", line)
+	}
+
+	if len(f) > 1 {
+		version = f[1]
+	}
+
+	return f[0], version, nil
+}
+
+// Login logs in to APRS-IS and waits for the server's logresp.  pass may be "-1" for a
+// receive-only login.  If transmit is true, an unverified login is an error.
+func (a *APRSIS) Login(user, pass, filter string, transmit bool) (LoginResponse, error) {
+	var lr LoginResponse
+
+	if filter != "" {
+		filter = fmt.Sprintf(" filter %s", filter)
+	}
+
+	err := a.conn.PrintfLine("user %s pass %s vers %s %s%s", user, pass, SoftwareName, SoftwareVersion, filter)
+	if err != nil {
+		return lr, err
+	}
+
+	if a.netconn != nil {
+		a.netconn.SetReadDeadline(time.Now().Add(loginTimeout))
+		defer a.netconn.SetReadDeadline(time.Time{})
+	}
+
+	var software, version string
+
+	for {
+		line, err := a.conn.ReadLine()
+		if err != nil {
+			return lr, fmt.Errorf("No login response from APRS-IS server: %v", err)
+		}
+
+		fmt.Fprintf(a.rawLog, "%s\n", line)
+
+		if !strings.HasPrefix(line, "#") {
+			// Packets may start flowing before the logresp on some servers
+			continue
+		}
+
+		lr, err = ParseLogresp(line)
+		if err != nil {
+			if len(software) == 0 {
+				software, version, _ = ParseBanner(line)
+			}
+			continue
+		}
+
+		lr.ServerSoftware = software
+		lr.ServerVersion = version
+		break
+	}
+
+	a.user = strings.ToUpper(user)
+	a.logresp = lr
+
+	if transmit && !lr.Verified {
+		return lr, ErrUnverified
+	}
+
+	return lr, nil
+}
+
+// Verified reports whether the server accepted our passcode at login
+func (a *APRSIS) Verified() bool {
+	return a.logresp.Verified
+}
@@ -0,0 +1,658 @@
+// GoBalloon
+// server.go - A small APRS-IS server for testing and for networks without the Internet
+//
+// (c) 2014, Christopher Snell
+
+package aprsis
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeepaliveInterval is how often the server sends "# " keepalives to its clients
+const DefaultKeepaliveInterval = 20 * time.Second
+
+// How many lines we'll queue up for a client before deciding it's too slow and dropping it
+const clientQueueLength = 256
+
+// Server is a stand-in for an APRS-IS server.  It accepts logins, checks passcodes,
+// applies each client's filter and relays packets between its clients.  It can also
+// gate packets to and from a KISS TNC, which makes it a complete APRS-IS for a launch
+// site without Internet access.
+//
+// Unlike a real APRS-IS server, a client with no filter receives every packet.
+// Unverified clients may listen but anything they send is dropped.
+type Server struct {
+	Name              string // Our server callsign, used in q-constructs and logresp
+	KeepaliveInterval time.Duration
+	Debug             bool
+
+	listener   net.Listener
+	clients    map[*serverClient]bool
+	stations   map[string]geospatial.Point
+	tnc        io.Writer
+	tncMutex   sync.Mutex
+	tncGate    *IGate
+	transmitRF bool
+	mutex      sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+type serverClient struct {
+	server   *Server
+	conn     net.Conn
+	call     string
+	verified bool
+	filter   Filters
+	queue    chan string
+	gone     chan struct{}
+	goneOnce sync.Once
+}
+
+// serverPacket is a packet on its way through the server, with everything the filters
+// need to know about it worked out in advance
+type serverPacket struct {
+	packet ax25.APRSPacket
+	line   string
+	source string
+	entry  string
+	kind   byte
+	object string
+	to     string
+	pos    geospatial.Point
+	hasPos bool
+	from   *serverClient
+}
+
+// NewServer returns a Server that calls itself name
+func NewServer(name string) *Server {
+	return &Server{
+		Name:              strings.ToUpper(name),
+		KeepaliveInterval: DefaultKeepaliveInterval,
+		clients:           make(map[*serverClient]bool),
+		stations:          make(map[string]geospatial.Point),
+		done:              make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens for clients on a TCP address such as ":14580"
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts clients on l until the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	log.Printf("Server.Serve(): APRS-IS server %v listening on %v\n", s.Name, l.Addr())
+
+	s.mutex.Lock()
+	s.listener = l
+	s.mutex.Unlock()
+
+	go s.keepalive()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go s.handleClient(conn)
+	}
+}
+
+// Addr returns the address we're listening on
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the server and disconnects every client
+func (s *Server) Close() error {
+	var err error
+
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+		for c := range s.clients {
+			c.close()
+		}
+	})
+
+	return err
+}
+
+// Clients returns the callsigns of the logged-in clients
+func (s *Server) Clients() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var calls []string
+	for c := range s.clients {
+		calls = append(calls, c.call)
+	}
+	return calls
+}
+
+// AttachTNC gates packets heard by a KISS TNC to our clients, following the usual IGate
+// rules.  If transmit is true, packets that verified clients send under their own
+// callsign are transmitted on RF too.
+func (s *Server) AttachTNC(tnc io.ReadWriter, transmit bool) {
+	s.tncMutex.Lock()
+	s.tnc = tnc
+	s.transmitRF = transmit
+	s.tncGate = NewIGate(s)
+	s.tncGate.Debug = s.Debug
+	s.tncMutex.Unlock()
+
+	go func() {
+		err := s.tncGate.Run(ax25.NewDecoder(tnc))
+		log.Printf("Server.AttachTNC(): stopped reading from TNC: %v\n", err)
+	}()
+}
+
+// Send injects a packet heard on RF.  It gets a qAR q-construct naming this server as
+// the IGate and goes to every client whose filter wants it.  This makes Server a Sender,
+// so an IGate can feed it directly.
+func (s *Server) Send(p ax25.APRSPacket) error {
+	p.Path = append(append([]ax25.APRSAddress{}, p.Path...), ax25.APRSAddress{Callsign: "qAR"}, AddressFromString(s.Name))
+	s.relay(s.newServerPacket(p, nil))
+	return nil
+}
+
+func (s *Server) keepalive() {
+	if s.KeepaliveInterval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(s.KeepaliveInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-t.C:
+			line := fmt.Sprintf("# %v %v %v %v", SoftwareName, SoftwareVersion, now.UTC().Format("2 Jan 2006 15:04:05 GMT"), s.Name)
+			s.mutex.Lock()
+			for c := range s.clients {
+				c.send(line)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+func (s *Server) handleClient(conn net.Conn) {
+	c := &serverClient{
+		server: s,
+		conn:   conn,
+		queue:  make(chan string, clientQueueLength),
+		gone:   make(chan struct{}),
+	}
+	defer c.close()
+
+	go c.writer()
+
+	c.send(fmt.Sprintf("# %v %v", SoftwareName, SoftwareVersion))
+
+	r := bufio.NewReader(conn)
+
+	// Clients have to log in promptly
+	conn.SetReadDeadline(time.Now().Add(loginTimeout))
+	line, err := readLine(r)
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	err = c.login(line)
+	if err != nil {
+		c.send("# " + err.Error())
+		s.debugf("Rejected login from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.clients[c] = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, c)
+		s.mutex.Unlock()
+	}()
+
+	log.Printf("Server: %v logged in from %v, verified: %v\n", c.call, conn.RemoteAddr(), c.verified)
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			s.debugf("%v disconnected: %v", c.call, err)
+			return
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == '#' {
+			c.command(line)
+			continue
+		}
+
+		s.fromClient(c, line)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// login handles the client's "user CALL pass PASSCODE vers SOFTWARE VERSION filter ..." line
+func (c *serverClient) login(line string) error {
+	f := strings.Fields(line)
+	if len(f) < 2 || f[0] != "user" {
+		return fmt.Errorf("Login with: user CALL pass PASSCODE vers SOFTWARE VERSION [filter ...]")
+	}
+
+	c.call = strings.ToUpper(f[1])
+	pass := ""
+
+	for i := 2; i < len(f); i++ {
+		switch f[i] {
+		case "pass":
+			if i+1 < len(f) {
+				pass = f[i+1]
+				i++
+			}
+		case "filter":
+			fs, err := ParseFilters(strings.Join(f[i+1:], " "))
+			if err != nil {
+				return err
+			}
+			c.filter = fs
+			i = len(f)
+		}
+	}
+
+	c.verified = pass == strconv.Itoa(Passcode(c.call)) && pass != "-1"
+
+	status := "unverified"
+	if c.verified {
+		status = "verified"
+	}
+	c.send(fmt.Sprintf("# logresp %v %v, server %v", c.call, status, c.server.Name))
+
+	return nil
+}
+
+// command handles a "#" line from a client.  #filter is the only one that does anything.
+func (c *serverClient) command(line string) {
+	f := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(f) == 0 || f[0] != "filter" {
+		return
+	}
+
+	fs, err := ParseFilters(strings.Join(f[1:], " "))
+	if err != nil {
+		c.send("# filter error: " + err.Error())
+		return
+	}
+
+	c.server.mutex.Lock()
+	c.filter = fs
+	c.server.mutex.Unlock()
+
+	c.send("# filter " + fs.String() + " is active")
+}
+
+// fromClient handles a packet sent by a client
+func (s *Server) fromClient(c *serverClient, line string) {
+	if !c.verified {
+		s.debugf("Dropped packet from unverified client %v: %v", c.call, line)
+		return
+	}
+
+	p := ParseAPRSISPacket(line)
+	if len(p.Source.Callsign) == 0 {
+		s.debugf("Dropped unparseable packet from %v: %v", c.call, line)
+		return
+	}
+
+	own := strings.EqualFold(p.Source.String(), c.call)
+
+	// Packets get a q-construct showing how they got here, unless an IGate has already
+	// given them one
+	if qConstructIndex(p.Path) < 0 {
+		q := "qAS"
+		if own {
+			q = "qAC"
+		}
+		p.Path = append(p.Path, ax25.APRSAddress{Callsign: q}, AddressFromString(s.Name))
+	}
+
+	sp := s.newServerPacket(p, c)
+	s.relay(sp)
+
+	if own {
+		s.transmit(p)
+	}
+}
+
+// transmit sends a client's own packet out on RF, if we have a TNC and are allowed to
+func (s *Server) transmit(p ax25.APRSPacket) {
+	s.tncMutex.Lock()
+	defer s.tncMutex.Unlock()
+
+	if s.tnc == nil || !s.transmitRF {
+		return
+	}
+
+	// Everything from the q-construct or TCPIP on only means something on APRS-IS
+	var path []ax25.APRSAddress
+	for _, a := range p.Path {
+		call := strings.ToUpper(strings.TrimSuffix(a.Callsign, "*"))
+		if call == "TCPIP" || (len(call) == 3 && strings.HasPrefix(call, "QA")) {
+			break
+		}
+		path = append(path, a)
+	}
+	if len(path) == 0 {
+		path = []ax25.APRSAddress{{Callsign: "WIDE2", SSID: 1}}
+	}
+	p.Path = path
+
+	frame, err := ax25.EncodeAX25Command(p)
+	if err != nil {
+		log.Printf("Server: could not encode packet for RF: %v\n", err)
+		return
+	}
+
+	// Don't gate our own transmission back to APRS-IS when the TNC echoes it
+	if s.tncGate != nil {
+		s.tncGate.duplicate(p.Source.String() + ">" + p.Dest.String() + ":" + p.Body)
+	}
+
+	_, err = s.tnc.Write(frame)
+	if err != nil {
+		log.Printf("Server: could not transmit packet: %v\n", err)
+	}
+}
+
+func qConstructIndex(path []ax25.APRSAddress) int {
+	for i, a := range path {
+		if len(a.Callsign) == 3 && strings.HasPrefix(strings.ToUpper(a.Callsign), "QA") {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) newServerPacket(p ax25.APRSPacket, from *serverClient) *serverPacket {
+	sp := &serverPacket{
+		packet: p,
+		line:   FormatTNC2(p),
+		source: strings.ToUpper(p.Source.String()),
+		from:   from,
+	}
+
+	if i := qConstructIndex(p.Path); i >= 0 && i+1 < len(p.Path) {
+		sp.entry = strings.ToUpper(p.Path[i+1].String())
+	}
+
+	body := p.Body
+	if len(body) == 0 {
+		return sp
+	}
+
+	// The decoders chew on the packet, so give them a copy
+	cp := p
+	ad := aprs.ParsePacket(&cp)
+
+	switch body[0] {
+	case '!', '=', '/', '@', '`', '\'', '$', '[':
+		sp.kind = 'p'
+		if ad.SymbolCode == '_' {
+			sp.kind = 'w'
+		}
+		sp.pos = ad.Position
+	case ';':
+		sp.kind = 'o'
+		sp.object = strings.TrimSpace(ad.Object.Name)
+		sp.pos = ad.Object.Position
+	case ')':
+		sp.kind = 'i'
+		if end := strings.IndexAny(body, "!_"); end > 1 {
+			sp.object = body[1:end]
+		}
+	case ':':
+		sp.kind = 'm'
+		if len(body) >= 11 {
+			sp.to = strings.ToUpper(strings.TrimSpace(body[1:10]))
+			switch {
+			case strings.HasPrefix(sp.to, "NWS"):
+				sp.kind = 'n'
+			case strings.HasPrefix(body[11:], "PARM.") || strings.HasPrefix(body[11:], "UNIT.") ||
+				strings.HasPrefix(body[11:], "EQNS.") || strings.HasPrefix(body[11:], "BITS."):
+				sp.kind = 't'
+			}
+		}
+	case '?':
+		sp.kind = 'q'
+	case '>':
+		sp.kind = 's'
+	case 'T':
+		sp.kind = 't'
+	case '{':
+		sp.kind = 'u'
+	case '_':
+		sp.kind = 'w'
+	}
+
+	sp.hasPos = sp.pos.Lat != 0 || sp.pos.Lon != 0
+
+	if sp.hasPos {
+		name := sp.source
+		if len(sp.object) > 0 {
+			name = strings.ToUpper(sp.object)
+		}
+		s.mutex.Lock()
+		s.stations[name] = sp.pos
+		s.mutex.Unlock()
+	}
+
+	return sp
+}
+
+// relay sends a packet to every client that wants it, other than the one it came from
+func (s *Server) relay(sp *serverPacket) {
+	s.debugf("Relaying %v", sp.line)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		if c == sp.from {
+			continue
+		}
+		if s.wants(c, sp) {
+			c.send(sp.line)
+		}
+	}
+}
+
+// wants reports whether a client's filter passes a packet.  Messages addressed to the
+// client always pass.  Called with s.mutex held.
+func (s *Server) wants(c *serverClient, sp *serverPacket) bool {
+	if len(sp.to) > 0 && sp.to == c.call {
+		return true
+	}
+
+	if len(c.filter) == 0 {
+		return true
+	}
+
+	pass := false
+	for _, f := range c.filter {
+		if ex, ok := f.(ExcludeFilter); ok {
+			if s.matches(ex.Filter, sp) {
+				return false
+			}
+			continue
+		}
+		if !pass && s.matches(f, sp) {
+			pass = true
+		}
+	}
+
+	return pass
+}
+
+// matches reports whether one filter matches a packet.  Called with s.mutex held.
+func (s *Server) matches(f Filter, sp *serverPacket) bool {
+	switch f := f.(type) {
+	case RangeFilter:
+		pos, ok := s.position(sp)
+		center := geospatial.Point{Lat: f.Lat, Lon: f.Lon}
+		return ok && center.HaversineDistanceTo(pos).Kilometers() <= f.Distance
+
+	case PrefixFilter:
+		for _, p := range f.Prefixes {
+			if strings.HasPrefix(sp.source, strings.ToUpper(p)) {
+				return true
+			}
+		}
+
+	case BudlistFilter:
+		return matchAny(f.Calls, sp.source)
+
+	case ObjectFilter:
+		return (sp.kind == 'o' || sp.kind == 'i') && matchAny(f.Names, strings.ToUpper(sp.object))
+
+	case TypeFilter:
+		if sp.kind == 0 || strings.IndexByte(f.Types, sp.kind) < 0 {
+			return false
+		}
+		if len(f.Call) == 0 {
+			return true
+		}
+		return s.near(strings.ToUpper(f.Call), f.Distance, sp)
+
+	case AreaFilter:
+		pos, ok := s.position(sp)
+		return ok && pos.Lat <= f.North && pos.Lat >= f.South && pos.Lon >= f.West && pos.Lon <= f.East
+
+	case FriendRangeFilter:
+		return s.near(strings.ToUpper(f.Call), f.Distance, sp)
+
+	case EntryFilter:
+		return len(sp.entry) > 0 && matchAny(f.Calls, sp.entry)
+	}
+
+	return false
+}
+
+// position returns where a packet is from: its own position, or failing that the last
+// position we heard from its source
+func (s *Server) position(sp *serverPacket) (geospatial.Point, bool) {
+	if sp.hasPos {
+		return sp.pos, true
+	}
+	pos, ok := s.stations[sp.source]
+	return pos, ok
+}
+
+// near reports whether a packet is from within km of the last known position of call
+func (s *Server) near(call string, km float64, sp *serverPacket) bool {
+	center, ok := s.stations[call]
+	if !ok {
+		return false
+	}
+	pos, ok := s.position(sp)
+	return ok && center.HaversineDistanceTo(pos).Kilometers() <= km
+}
+
+// matchAny matches name against a list of patterns that may use * wildcards
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) debugf(format string, args ...interface{}) {
+	if s.Debug {
+		log.Printf("Server: "+format+"\n", args...)
+	}
+}
+
+// send queues a line for the client.  A client that can't keep up is disconnected.
+func (c *serverClient) send(line string) {
+	select {
+	case c.queue <- line:
+	case <-c.gone:
+	default:
+		log.Printf("Server: %v can't keep up; disconnecting\n", c.call)
+		c.close()
+	}
+}
+
+func (c *serverClient) writer() {
+	for {
+		select {
+		case line := <-c.queue:
+			_, err := fmt.Fprintf(c.conn, "%s\r\n", line)
+			if err != nil {
+				c.close()
+				return
+			}
+		case <-c.gone:
+			return
+		}
+	}
+}
+
+func (c *serverClient) close() {
+	c.goneOnce.Do(func() {
+		close(c.gone)
+		c.conn.Close()
+	})
+}
@@ -22,7 +22,7 @@ var call, pass, filter, server, rawlog string
 func init() {
 	flag.StringVar(&server, "server", "second.aprs.net:14580", "APRS-IS upstream")
 	flag.StringVar(&call, "call", "", "Your callsign (for APRS-IS)")
-	flag.StringVar(&pass, "pass", "", "Your call pass (for APRS-IS)")
+	flag.StringVar(&pass, "pass", "", "Your call pass (for APRS-IS).  Computed from -call if not given.")
 	flag.StringVar(&filter, "filter", "", "Optional filter for APRS-IS server")
 	flag.StringVar(&rawlog, "rawlog", "", "Path to log raw messages")
 
@@ -36,7 +36,15 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	is.Auth(call, pass, filter)
+	if pass == "" {
+		pass = fmt.Sprintf("%d", aprsis.Passcode(call))
+	}
+
+	lr, err := is.Login(call, pass, filter, false)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Logged in to %v (%v %v) as %v, verified: %v\n", lr.Server, lr.ServerSoftware, lr.ServerVersion, lr.Callsign, lr.Verified)
 
 	if rawlog != "" {
 		logWriter, err := os.OpenFile(rawlog,
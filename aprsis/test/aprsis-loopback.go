@@ -0,0 +1,102 @@
+// GoBalloon
+// aprsis-loopback.go - Runs the APRS-IS client against a local APRS-IS server
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// connect starts a managed client and waits for it to log in
+func connect(addr, call, pass string, filter aprsis.Filters) *aprsis.Client {
+	c := aprsis.NewClient(call, pass, addr)
+	if filter != nil {
+		c.SetFilter(filter)
+	}
+	go c.Run()
+
+	for {
+		select {
+		case ev := <-c.Events:
+			if ev.State == aprsis.StateConnected {
+				return c
+			}
+		case <-time.After(5 * time.Second):
+			log.Fatalf("%v never connected\n", call)
+		}
+	}
+}
+
+// expect waits briefly for a packet containing want, or for silence if want is empty
+func expect(c *aprsis.Client, want string) bool {
+	for {
+		select {
+		case p := <-c.Packets:
+			line := aprsis.FormatTNC2(p)
+			if len(want) > 0 && strings.Contains(line, want) {
+				return true
+			}
+			if len(want) == 0 {
+				fmt.Printf("      unexpected: %v\n", line)
+				return false
+			}
+		case <-time.After(500 * time.Millisecond):
+			return len(want) == 0
+		}
+	}
+}
+
+func main() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	s := aprsis.NewServer("TEST")
+	go s.Serve(l)
+	addr := l.Addr().String()
+
+	// The chase car hears everything near the launch site; the ground station only
+	// wants the balloon
+	chase := connect(addr, "N0CALL-9", "", aprsis.Filters{aprsis.RangeFilter{Lat: 47.2, Lon: -122.5, Distance: 100}})
+	ground := connect(addr, "N0CALL-10", "", aprsis.Filters{aprsis.BudlistFilter{Calls: []string{"N0CALL-11"}}})
+	lurker := connect(addr, "N0CALL-5", "-1", nil)
+
+	balloon := aprsis.ParseAPRSISPacket("N0CALL-11>APRS,WIDE2-1:!4712.00N/12230.00WO/A=030000")
+	far := aprsis.ParseAPRSISPacket("W1AW>APRS:!4140.00N/07240.00W-")
+
+	checks.True(chase.Send(balloon) == nil, "gated balloon packet sent")
+	checks.True(expect(ground, "N0CALL-11>APRS,WIDE2-1,qAR,N0CALL-9"), "ground station gets the balloon with the chase car's q-construct")
+	checks.True(expect(lurker, "N0CALL-11"), "client with no filter gets everything")
+	checks.True(expect(chase, ""), "sender doesn't get its own packet back")
+
+	checks.True(chase.Send(far) == nil, "far-away packet sent")
+	checks.True(expect(ground, ""), "budlist filter drops other stations")
+
+	checks.True(lurker.Send(aprsis.ParseAPRSISPacket("N0CALL-5>APRS:>hello")) == nil, "unverified client sends")
+	checks.True(expect(chase, ""), "unverified client's packet is dropped")
+
+	checks.True(ground.Send(aprsis.ParseAPRSISPacket("N0CALL-10>APRS::N0CALL-5 :hi there{1")) == nil, "message sent")
+	checks.True(expect(lurker, "qAC,TEST::N0CALL-5 :hi there"), "own packets get qAC and messages reach their addressee")
+
+	ground.SetFilter(aprsis.Filters{aprsis.TypeFilter{Types: "s"}})
+	time.Sleep(200 * time.Millisecond)
+	chase.Send(aprsis.ParseAPRSISPacket("N0CALL-9>APRS:>On our way"))
+	checks.True(expect(ground, ">On our way"), "#filter changes the filter on an open connection")
+
+	checks.True(len(s.Clients()) == 3, "server has 3 clients: %v", s.Clients())
+
+	s.Close()
+	ev := <-chase.Events
+	checks.True(ev.State == aprsis.StateDisconnected, "client notices the server going away: %v", ev.Err)
+
+	checks.Done()
+}
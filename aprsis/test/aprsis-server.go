@@ -0,0 +1,35 @@
+// GoBalloon
+// aprsis-server.go - Runs a local APRS-IS server, optionally gated to a KISS TNC
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"flag"
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"log"
+	"net"
+)
+
+func main() {
+	listen := flag.String("listen", ":14580", "Address to listen for APRS-IS clients on")
+	name := flag.String("name", "GOBALLOON", "Server name, used in q-constructs")
+	tnc := flag.String("tnc", "", "Optional KISS TNC to gate to and from, e.g. 10.50.0.25:6700")
+	transmit := flag.Bool("transmit", false, "Transmit packets that clients send under their own callsign on the TNC")
+	debug := flag.Bool("debug", false, "Enable debugging information")
+	flag.Parse()
+
+	s := aprsis.NewServer(*name)
+	s.Debug = *debug
+
+	if len(*tnc) > 0 {
+		conn, err := net.Dial("tcp", *tnc)
+		if err != nil {
+			log.Fatalf("Could not connect to TNC %v: %v\n", *tnc, err)
+		}
+		s.AttachTNC(conn, *transmit)
+	}
+
+	log.Fatalln(s.ListenAndServe(*listen))
+}
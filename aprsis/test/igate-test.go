@@ -0,0 +1,54 @@
+// GoBalloon
+// igate-test.go - Runs packets heard on RF through the IGate rules
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+)
+
+// sender remembers what the IGate sent to APRS-IS
+type sender struct {
+	sent []string
+}
+
+func (s *sender) Send(p ax25.APRSPacket) error {
+	s.sent = append(s.sent, aprsis.FormatTNC2(p))
+	return nil
+}
+
+func main() {
+	s := &sender{}
+	g := aprsis.NewIGate(s)
+
+	for _, t := range []struct {
+		heard string
+		gated bool
+	}{
+		{"N0CALL-11>APRS,WIDE2-1:>Up, up and away", true},
+		{"N0CALL-11>APRS,WIDE2-1:>Up, up and away", false},
+		{"N0CALL-11>APRS,TCPIP*:>From the Internet", false},
+		{"N0CALL-11>APRS,NOGATE:>Stay on RF", false},
+		{"N0CALL-11>APRS:?APRS?", false},
+		{"N0CALL-1>APRS:}K1ABC>APRS,WIDE1-1:>Third party", true},
+		{"N0CALL-1>APRS:}K1ABC>APRS,TCPIP,N0CALL-1*:>Back from the Internet", false},
+		{"N0CALL-1>APRS:}K1ABC>APRS:?APRS?", false},
+		{"N0CALL-1>APRS:}K1ABC>APRS,WIDE1-1:", false},
+		{"N0CALL-1>APRS:}", false},
+	} {
+		gated, err := g.Gate(aprsis.ParseAPRSISPacket(t.heard))
+		checks.Equal(t.heard, gated, t.gated)
+		checks.Equal(t.heard+" error", err, nil)
+	}
+
+	checks.Equal("sent", len(s.sent), 2)
+	if len(s.sent) == 2 {
+		checks.Equal("third party unwrapped", s.sent[1], "K1ABC>APRS,WIDE1-1:>Third party")
+	}
+
+	checks.Done()
+}
@@ -7,17 +7,58 @@ import (
 	"fmt"
 )
 
-// APRSAddress represents an AX.25 source or destination address
+// APRSAddress represents an AX.25 source, destination or digipeater address.
+//
+// The last byte of an address on the air holds the SSID and three flags:
+//
+//	bit 7      C bit (source/destination) or H bit (digipeater)
+//	bits 6-5   reserved, normally 11
+//	bits 4-1   SSID
+//	bit 0      address extension bit, set on the last address in the frame
 type APRSAddress struct {
 	Callsign string
 	SSID     uint8
+	Repeated bool // The H bit: this digipeater has repeated the packet
+	Command  bool // The C bit of a source or destination address
+	Last     bool // The extension bit: this is the last address in the frame.  Set when decoding.
 }
 
-// Returns a string representation of a full AX.25 address
+// Returns a string representation of a full AX.25 address.  Digipeaters that have
+// repeated the packet are marked with a *, as in TNC2 monitor format.
 func (a APRSAddress) String() string {
+	s := a.Callsign
 	if a.SSID != 0 {
-		return fmt.Sprintf("%s-%d", a.Callsign, a.SSID)
+		s = fmt.Sprintf("%s-%d", a.Callsign, a.SSID)
+	}
+	if a.Repeated {
+		s += "*"
+	}
+	return s
+}
+
+// ssidByte encodes the SSID and flags of an address.  The C bit is used for sources and
+// destinations and the H bit for digipeaters.
+func (a APRSAddress) ssidByte(digipeater bool) byte {
+	// The reserved bits are always set
+	b := byte(0x60) | (a.SSID&0xf)<<1
+
+	if (digipeater && a.Repeated) || (!digipeater && a.Command) {
+		b |= 0x80
+	}
+	if a.Last {
+		b |= 1
+	}
+
+	return b
+}
+
+// parseSSIDByte fills in an address's SSID and flags from its last byte on the air
+func (a *APRSAddress) parseSSIDByte(b byte, digipeater bool) {
+	a.SSID = (b >> 1) & 0xf
+	a.Last = b&1 != 0
+	if digipeater {
+		a.Repeated = b&0x80 != 0
 	} else {
-		return a.Callsign
+		a.Command = b&0x80 != 0
 	}
 }
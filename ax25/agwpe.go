@@ -0,0 +1,398 @@
+// GoBalloon
+// agwpe.go - AGWPE TCP API client, for soundcard modems like Direwolf and SoundModem
+//
+// (c) 2014, Christopher Snell
+
+package ax25
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAGWPEPort is the TCP port AGWPE servers usually listen on
+const DefaultAGWPEPort = 8000
+
+// Every AGWPE message starts with a 36-byte header:
+//
+//	0      port (0 is the first radio)
+//	4      data kind, an ASCII letter
+//	6      PID
+//	8-17   from callsign, NUL-padded
+//	18-27  to callsign, NUL-padded
+//	28-31  length of the data that follows, little-endian
+//
+// with the other bytes reserved.
+const agwpeHeaderLen = 36
+
+// maxAGWPEData is far more than any frame.  A longer message means we've lost our place.
+const maxAGWPEData = 64 * 1024
+
+// agwpeTimeout is how long we'll wait for the server to answer a registration
+const agwpeTimeout = 10 * time.Second
+
+// ErrAGWPEClosed is returned by Next and NextFrame once the client has been closed
+var ErrAGWPEClosed = errors.New("AGWPE client closed")
+
+// AGWPE data kinds
+const (
+	agwRegister   byte = 'X'
+	agwMonitor    byte = 'm'
+	agwRawMonitor byte = 'k'
+	agwUnproto    byte = 'M'
+	agwUnprotoVia byte = 'V'
+	agwRaw        byte = 'K'
+	agwMonitorUI  byte = 'U'
+)
+
+// agwpeFrame is one message to or from an AGWPE server
+type agwpeFrame struct {
+	Port     byte
+	Kind     byte
+	PID      byte
+	CallFrom string
+	CallTo   string
+	Data     []byte
+}
+
+// AGWPEClient talks to a soundcard modem over the AGWPE TCP API instead of KISS
+type AGWPEClient struct {
+	Debug bool
+
+	conn       io.ReadWriteCloser
+	frames     chan agwpeFrame
+	registered chan bool
+	done       chan struct{}
+	closeOnce  sync.Once
+	err        error
+	writeMutex sync.Mutex
+}
+
+// NewAGWPEClient starts a client over a connection to an AGWPE server
+func NewAGWPEClient(conn io.ReadWriteCloser) *AGWPEClient {
+	c := &AGWPEClient{
+		conn:       conn,
+		frames:     make(chan agwpeFrame, 64),
+		registered: make(chan bool, 1),
+		done:       make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Register tells the server we're using a callsign.  Unproto frames don't need it, but
+// some servers want every callsign we send as registered.
+func (c *AGWPEClient) Register(call APRSAddress) error {
+	err := c.write(agwpeFrame{Kind: agwRegister, CallFrom: agwpeCall(call)})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ok := <-c.registered:
+		if !ok {
+			return fmt.Errorf("AGWPE server refused to register %v", call)
+		}
+		return nil
+	case <-time.After(agwpeTimeout):
+		return fmt.Errorf("No answer from AGWPE server registering %v", call)
+	}
+}
+
+// Monitor asks the server for the UI frames it hears, decoded into text.  Use Monitor or
+// MonitorRaw but not both, or you'll hear everything twice.
+func (c *AGWPEClient) Monitor() error {
+	return c.write(agwpeFrame{Kind: agwMonitor})
+}
+
+// MonitorRaw asks the server for every frame it hears, exactly as heard
+func (c *AGWPEClient) MonitorRaw() error {
+	return c.write(agwpeFrame{Kind: agwRawMonitor})
+}
+
+// Send transmits an APRS packet as an unproto frame.  Packets we're digipeating, with H
+// bits set in their paths, go out raw, since the unproto commands can't carry H bits.
+func (c *AGWPEClient) Send(p APRSPacket) error {
+	// Like the server does for unproto frames, we send commands
+	f, err := CommandFrame(p)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range f.Path {
+		if a.Repeated {
+			return c.SendFrame(f)
+		}
+	}
+
+	af := agwpeFrame{
+		Port:     byte(f.Port),
+		Kind:     agwUnproto,
+		PID:      f.PID,
+		CallFrom: agwpeCall(f.Source),
+		CallTo:   agwpeCall(f.Dest),
+		Data:     f.Info,
+	}
+
+	if len(f.Path) > 0 {
+		// The data starts with the number of digipeaters and ten bytes for each
+		af.Kind = agwUnprotoVia
+		af.Data = []byte{byte(len(f.Path))}
+		for _, a := range f.Path {
+			call := make([]byte, 10)
+			copy(call, agwpeCall(a))
+			af.Data = append(af.Data, call...)
+		}
+		af.Data = append(af.Data, f.Info...)
+	}
+
+	return c.write(af)
+}
+
+// SendFrame transmits a frame of any kind, exactly as given
+func (c *AGWPEClient) SendFrame(f Frame) error {
+	ax, err := f.encodeAX25()
+	if err != nil {
+		return err
+	}
+
+	// Raw frames start with a byte that the server ignores
+	return c.write(agwpeFrame{
+		Port: byte(f.Port),
+		Kind: agwRaw,
+		Data: append([]byte{0}, ax...),
+	})
+}
+
+// NextFrame returns the next frame we hear
+func (c *AGWPEClient) NextFrame() (Frame, error) {
+	af, ok := <-c.frames
+	if !ok {
+		return Frame{}, c.err
+	}
+
+	var f Frame
+	var err error
+
+	if af.Kind == agwRaw {
+		if len(af.Data) < 2 {
+			return Frame{}, errShortMsg
+		}
+		f, err = decodeFrame(af.Data[1:])
+	} else {
+		f, err = parseMonitorUI(af.Data)
+	}
+
+	f.Port = int(af.Port)
+	return f, err
+}
+
+// Next returns the next APRS packet we hear.  Like Decoder.Next, it returns a
+// *NotAPRSError for other frames and a *MalformedFrameError for garbage.
+func (c *AGWPEClient) Next() (APRSPacket, error) {
+	f, err := c.NextFrame()
+	if err != nil {
+		return APRSPacket{}, err
+	}
+	return f.APRSPacket()
+}
+
+// Close closes the connection to the server
+func (c *AGWPEClient) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// read sorts out what the server sends us until the connection fails
+func (c *AGWPEClient) read() {
+	for {
+		f, err := readAGWPEFrame(c.conn)
+		if err != nil {
+			c.err = err
+			close(c.frames)
+			return
+		}
+
+		switch f.Kind {
+		case agwRegister:
+			select {
+			case c.registered <- len(f.Data) > 0 && f.Data[0] == 1:
+			default:
+			}
+		case agwMonitorUI, agwRaw:
+			select {
+			case c.frames <- f:
+			case <-c.done:
+				// Nobody's listening any more
+				c.err = ErrAGWPEClosed
+				close(c.frames)
+				return
+			}
+		default:
+			c.debugf("Ignoring %q message from server", f.Kind)
+		}
+	}
+}
+
+func (c *AGWPEClient) write(f agwpeFrame) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := c.conn.Write(f.encode())
+	return err
+}
+
+func (c *AGWPEClient) debugf(format string, args ...interface{}) {
+	if c.Debug {
+		log.Printf("AGWPE: "+format+"\n", args...)
+	}
+}
+
+func (f agwpeFrame) encode() []byte {
+	b := make([]byte, agwpeHeaderLen, agwpeHeaderLen+len(f.Data))
+
+	b[0] = f.Port
+	b[4] = f.Kind
+	b[6] = f.PID
+	// Leave room for the NULs
+	copy(b[8:17], f.CallFrom)
+	copy(b[18:27], f.CallTo)
+	binary.LittleEndian.PutUint32(b[28:32], uint32(len(f.Data)))
+
+	return append(b, f.Data...)
+}
+
+func readAGWPEFrame(r io.Reader) (agwpeFrame, error) {
+	h := make([]byte, agwpeHeaderLen)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return agwpeFrame{}, err
+	}
+
+	n := binary.LittleEndian.Uint32(h[28:32])
+	if n > maxAGWPEData {
+		return agwpeFrame{}, fmt.Errorf("AGWPE message claims %v bytes of data; lost sync with server", n)
+	}
+
+	f := agwpeFrame{
+		Port:     h[0],
+		Kind:     h[4],
+		PID:      h[6],
+		CallFrom: agwpeString(h[8:18]),
+		CallTo:   agwpeString(h[18:28]),
+		Data:     make([]byte, n),
+	}
+	if _, err := io.ReadFull(r, f.Data); err != nil {
+		return agwpeFrame{}, err
+	}
+
+	return f, nil
+}
+
+// agwpeString returns a NUL-padded string from a header
+func agwpeString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// agwpeCall formats an address the way AGWPE wants it, e.g. N0CALL-11
+func agwpeCall(a APRSAddress) string {
+	return APRSAddress{Callsign: a.Callsign, SSID: a.SSID}.String()
+}
+
+// parseAGWPECall parses an address from a monitored frame, where a * marks a digipeater
+// that has repeated it
+func parseAGWPECall(s string) APRSAddress {
+	a := APRSAddress{}
+
+	if strings.HasSuffix(s, "*") {
+		a.Repeated = true
+		s = strings.TrimSuffix(s, "*")
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	a.Callsign = parts[0]
+	if len(parts) == 2 {
+		ssid, _ := strconv.Atoi(parts[1])
+		a.SSID = uint8(ssid)
+	}
+
+	return a
+}
+
+// The first line of a monitored UI frame, e.g.
+//
+//	1:Fm N0CALL-11 To APZ001 Via WIDE1-1*,WIDE2-1 <UI pid=F0 Len=20 >[12:34:56]
+//
+// followed by the information field and a CR
+var (
+	monitorHeader = regexp.MustCompile(`^\s*\d+:Fm (\S+) To (\S+)(?: Via (\S+))? <UI([^>]*)>`)
+	monitorPID    = regexp.MustCompile(`pid=([0-9A-Fa-f]{2})`)
+	monitorLen    = regexp.MustCompile(`Len=(\d+)`)
+)
+
+// parseMonitorUI turns a monitored UI frame back into a Frame
+func parseMonitorUI(data []byte) (f Frame, err error) {
+	cr := bytes.IndexByte(data, '\r')
+	if cr < 0 {
+		err = errTruncatedMsg
+		return
+	}
+
+	m := monitorHeader.FindSubmatch(data[:cr])
+	if m == nil {
+		err = &MalformedFrameError{"unrecognized AGWPE monitor header"}
+		return
+	}
+
+	f.Source = parseAGWPECall(string(m[1]))
+	f.Dest = parseAGWPECall(string(m[2]))
+	f.Path = []APRSAddress{}
+	if len(m[3]) > 0 {
+		for _, d := range strings.Split(string(m[3]), ",") {
+			f.Path = append(f.Path, parseAGWPECall(d))
+		}
+	}
+
+	// Only the last digipeater to repeat the frame is marked, but those before it have
+	// repeated it too
+	for i := len(f.Path) - 1; i >= 0; i-- {
+		if f.Path[i].Repeated {
+			for j := 0; j < i; j++ {
+				f.Path[j].Repeated = true
+			}
+			break
+		}
+	}
+
+	f.Control = ctlUI
+	f.PID = PIDNoLayer3
+	if p := monitorPID.FindSubmatch(m[4]); p != nil {
+		pid, _ := strconv.ParseUint(string(p[1]), 16, 8)
+		f.PID = byte(pid)
+	}
+
+	info := data[cr+1:]
+	n := -1
+	if l := monitorLen.FindSubmatch(m[4]); l != nil {
+		n, _ = strconv.Atoi(string(l[1]))
+	}
+	if n >= 0 && n <= len(info) {
+		info = info[:n]
+	} else {
+		info = bytes.TrimRight(info, "\r\x00")
+	}
+	f.Info = append([]byte{}, info...)
+
+	return
+}
@@ -10,7 +10,7 @@ package ax25
 
 import (
 	"bufio"
-	"errors"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -25,6 +25,7 @@ type APRSPacket struct {
 	Path         []APRSAddress
 	Body         string
 	OriginalBody string
+	Port         int // KISS port (0-15) the packet was heard on or is to be sent on
 }
 
 type Decoder struct {
@@ -33,8 +34,39 @@ type Decoder struct {
 
 const reasonableSize = 15
 
-var errShortMsg = errors.New("Message unreasonably short")
-var errTruncatedMsg = errors.New("Truncated message")
+// MalformedFrameError is returned for data that can't be an AX.25 frame: radio noise, a
+// truncated read or a confused TNC
+type MalformedFrameError struct {
+	Reason string
+}
+
+func (e *MalformedFrameError) Error() string {
+	return "Malformed AX.25 frame: " + e.Reason
+}
+
+// NotAPRSError is returned by Next for a well-formed frame that isn't APRS, such as
+// connected-mode or NET/ROM traffic.  The frame is included for anyone who wants it.
+type NotAPRSError struct {
+	Frame Frame
+}
+
+func (e *NotAPRSError) Error() string {
+	return fmt.Sprintf("Not an APRS frame: %v frame with PID %#02x", e.Frame.Kind(), e.Frame.PID)
+}
+
+var errShortMsg = &MalformedFrameError{"message unreasonably short"}
+var errTruncatedMsg = &MalformedFrameError{"truncated message"}
+var errLongPath = &MalformedFrameError{"too many digipeaters in path"}
+
+// IsFrameError reports whether err is about a single frame, bad or not APRS, rather than
+// the connection to the TNC.  It's safe to keep reading after one of these.
+func IsFrameError(err error) bool {
+	switch err.(type) {
+	case *MalformedFrameError, *NotAPRSError:
+		return true
+	}
+	return false
+}
 
 // NewDecoder gets a new decoder over this reader.
 func NewDecoder(r io.Reader) *Decoder {
@@ -43,17 +75,10 @@ func NewDecoder(r io.Reader) *Decoder {
 
 // Process the next APRS packet we get
 func (d *Decoder) Next() (APRSPacket, error) {
-	var err error
-
-	frame := []byte{}
-	// Keep reading so long as our frame is < 15 bytes
-	for len(frame) <= reasonableSize {
-		// Read forward until we encounter 0xc0 and return this data including that 0xc0.
-		frame, err = d.r.ReadBytes(byte(0xc0))
-		if err != nil {
-			// Unable to read for some reason so we return an empty APRSPacket{} struct and our error
-			return APRSPacket{}, err
-		}
+	frame, err := d.readKISS(reasonableSize)
+	if err != nil {
+		// Unable to read for some reason so we return an empty APRSPacket{} struct and our error
+		return APRSPacket{}, err
 	}
 
 	// For debugging, uncomment the following:
@@ -68,7 +93,24 @@ func (d *Decoder) Next() (APRSPacket, error) {
 	return decodeMessage(frame)
 }
 
-func parseAX25Address(in []byte) APRSAddress {
+// readKISS returns the next KISS data frame longer than min bytes, starting with its
+// command byte and ending with its FEND
+func (d *Decoder) readKISS(min int) ([]byte, error) {
+	for {
+		// Read forward until we encounter 0xc0 and return this data including that 0xc0.
+		frame, err := d.r.ReadBytes(fend)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep reading so long as our frame is too short, and skip anything that isn't data
+		if len(frame) > min && frame[0]&0x0f == KISSData {
+			return frame, nil
+		}
+	}
+}
+
+func parseAX25Address(in []byte, digipeater bool) APRSAddress {
 	out := make([]byte, len(in))
 
 	// We iterate through each byte of the address and shift right one bit.
@@ -81,9 +123,11 @@ func parseAX25Address(in []byte) APRSAddress {
 
 	a := APRSAddress{
 		Callsign: strings.TrimSpace(string(out[:len(out)-1])),
-		SSID:     uint8(out[len(out)-1] & 0xf),
 	}
 
+	// The last byte also carries the C or H bit and the address extension bit
+	a.parseSSIDByte(in[len(in)-1], digipeater)
+
 	return a
 }
 
@@ -101,7 +145,7 @@ func decodeMessage(frame []byte) (dm APRSPacket, err error) {
 	   A KISS frame looks something like this:
 	   ------------------------------------------------------------------------------------
 	   Frame End (FEND)   1 byte (0xc0)
-	   Command            1 byte (0x00)
+	   Command            1 byte (0x00 for data; the high nibble is the TNC port)
 	   Dest Addr          7 bytes (Callsign + SSID, can be generic digipeater path)
 	   Source Addr        7 bytes (Callsign + SSID)
 	   Digipeater Addrs   0-56 bytes (Digipeater path)
@@ -122,50 +166,58 @@ func decodeMessage(frame []byte) (dm APRSPacket, err error) {
 		return
 	}
 
-	//origFrame := frame
-
-	// Discard the first byte (0x00, the AX.25 Command field)
-	frame = frame[:len(frame)-1]
-
-	// Next comes the 7-byte destination address. AX.25 addresses are in the format CCCCCCS,
-	// where C = callsign and S = SSID.  Since each btye of the address is shifted one
-	// bit to the left, we'll use our decodeAddr() to decode it.  Gotta love 1980s protocols!
-	dm.Dest = parseAX25Address(frame[1:8])
+	// Discard the KISS command byte and the trailing FEND, undo the KISS escaping and
+	// decode what's left
+	f, err := decodeFrame(kissUnescape(frame[1 : len(frame)-1]))
+	if err != nil {
+		return
+	}
+	f.Port = int(frame[0] >> 4)
 
-	// Next verse same as the first.  Same old protocol, could be worse.
-	dm.Source = parseAX25Address(frame[8:15])
+	// APRS travels in UI frames with no layer 3 protocol.  Anything else is someone
+	// else's traffic.
+	return f.APRSPacket()
+}
 
-	// Initialize our message's path with an empty array of APRSAddress
-	dm.Path = []APRSAddress{}
+// IsCommand reports whether this is an AX.25 command, with the destination's C bit set
+// and the source's clear.  Frames from AX.25 version 1 stations set both or neither.
+func (p APRSPacket) IsCommand() bool {
+	return p.Dest.Command && !p.Source.Command
+}
 
-	// At this point, we can discard the parts of the packet we've already processed
-	frame = frame[15:]
+// IsResponse reports whether this is an AX.25 response, with the source's C bit set and
+// the destination's clear
+func (p APRSPacket) IsResponse() bool {
+	return p.Source.Command && !p.Dest.Command
+}
 
-	// Now we're going to bite off 7-byte chunks of the frame and decode them as digipeater
-	// addresses to be stored in our dm.Path array.  We stop when we reach the Control Field
-	// section of packet, which is delimited by a 0x03.
-	for len(frame) > 7 && frame[0] != 3 {
-		dm.Path = append(dm.Path, parseAX25Address(frame[:7]))
-		// As we parse each digipeater address, we remove it from the remaining frame
-		frame = frame[7:]
+// RepeatedBy returns the digipeaters in the path that have repeated this packet, in the
+// order they did so
+func (p APRSPacket) RepeatedBy() []APRSAddress {
+	var rb []APRSAddress
+	for _, a := range p.Path {
+		if !a.Repeated {
+			break
+		}
+		rb = append(rb, a)
 	}
+	return rb
+}
 
-	// At this point, if there's less than 2 bytes remaining in the frame, or we don't
-	// have the Control Field (0x03) or Protocol ID (0xf0) at the beginning of the remaining
-	// frame, we have a truncated frame, so we throw an error.
-	if len(frame) < 2 || frame[0] != 3 || frame[1] != 0xf0 {
-		err = errTruncatedMsg
-		return
+// HeardFrom returns the station whose transmission we received: the last digipeater
+// to repeat the packet, or its source if we heard it direct.  Digipeaters insert their
+// own callsign ahead of the WIDEn-N alias they use up, so used-up aliases are skipped
+// in favor of the station in front of them.
+func (p APRSPacket) HeardFrom() APRSAddress {
+	rb := p.RepeatedBy()
+	for i := len(rb) - 1; i >= 0; i-- {
+		if !isWideHop(rb[i]) {
+			return rb[i]
+		}
 	}
-
-	// If we're still going now, we can safely assume that everything remaining is APRS
-	// data.   We'll convert the remaining bytes to a string and save it as the APRSMessage body.
-	dm.Body = string(frame[2:])
-
-	// APRSMessage.Body gets modified by the APRS decoder so we'll save a copy of it in the struct
-	// that will remain in its original form
-	dm.OriginalBody = dm.Body
-
-	return
-
+	if len(rb) > 0 {
+		// Only aliases, from digipeaters that don't identify themselves
+		return rb[len(rb)-1]
+	}
+	return p.Source
 }
@@ -0,0 +1,258 @@
+// GoBalloon
+// digipeater.go - An APRS digipeater: WIDEn-N path handling, duplicate suppression,
+// viscous delay and rate limiting
+//
+// (c) 2014, Christopher Snell
+
+package ax25
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for a Digipeater
+const (
+	DefaultDigiMaxHops    = 2
+	DefaultDigiDupWindow  = 30 * time.Second
+	DefaultDigiRateLimit  = 10
+	DefaultDigiRateWindow = time.Minute
+)
+
+// An AX.25 frame carries at most eight digipeater addresses
+const maxPathLength = 8
+
+// Digipeater repeats APRS packets following the New-N paradigm.  The first unused
+// address in a packet's path is examined:
+//
+//   - our own callsign, or one of our aliases, is marked as repeated (aliases are
+//     replaced by our callsign)
+//   - WIDEn-N, with n no greater than MaxHops, has N decremented and our callsign
+//     inserted in front of it so the packet's route can be traced.  When N reaches
+//     zero, the WIDEn address is marked as repeated.
+//
+// Packets heard more than once within DupWindow are repeated only once.  With a
+// ViscousDelay, a packet is held for that long and dropped if we hear another
+// digipeater repeat it first, which makes us a fill-in that only speaks when nobody
+// else did.  No more than RateLimit packets are repeated in any RateWindow, so that a
+// digipeater with a view of several states can't flood the channel.
+type Digipeater struct {
+	Callsign     APRSAddress
+	Aliases      []APRSAddress
+	MaxHops      uint8
+	DupWindow    time.Duration
+	ViscousDelay time.Duration
+	RateLimit    int // Zero for no limit
+	RateWindow   time.Duration
+	Send         func(APRSPacket) error
+	Debug        bool
+
+	seen    map[string]time.Time
+	pending map[string]*time.Timer
+	sent    []time.Time
+	mutex   sync.Mutex
+}
+
+// NewDigipeater returns a Digipeater that repeats as callsign and transmits with send
+func NewDigipeater(callsign APRSAddress, send func(APRSPacket) error) *Digipeater {
+	return &Digipeater{
+		Callsign:   callsign,
+		MaxHops:    DefaultDigiMaxHops,
+		DupWindow:  DefaultDigiDupWindow,
+		RateLimit:  DefaultDigiRateLimit,
+		RateWindow: DefaultDigiRateWindow,
+		Send:       send,
+		seen:       make(map[string]time.Time),
+		pending:    make(map[string]*time.Timer),
+	}
+}
+
+// Digipeat returns the packet as we would repeat it, and whether we should repeat it
+// at all.  It only looks at the path; duplicates and rate limits are up to Handle.
+func (d *Digipeater) Digipeat(p APRSPacket) (APRSPacket, bool) {
+	// Never repeat ourselves
+	if sameStation(p.Source, d.Callsign) {
+		return p, false
+	}
+
+	// Find the first digipeater that hasn't repeated the packet yet
+	next := -1
+	for i, a := range p.Path {
+		if !a.Repeated {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		return p, false
+	}
+
+	// Work on our own copy of the path so we don't disturb the caller's packet
+	path := append([]APRSAddress{}, p.Path...)
+	hop := path[next]
+	me := d.Callsign
+	me.Repeated = true
+
+	switch {
+	case sameStation(hop, d.Callsign) || d.isAlias(hop):
+		path[next] = me
+
+	case isWideHop(hop):
+		n, _ := strconv.Atoi(hop.Callsign[4:])
+		if hop.SSID == 0 || hop.SSID > uint8(n) || hop.SSID > d.MaxHops || n > int(d.MaxHops) {
+			return p, false
+		}
+
+		hop.SSID--
+		if hop.SSID == 0 {
+			hop.Repeated = true
+		}
+		path[next] = hop
+
+		// Put our callsign in front of it, if there's room
+		if len(path) < maxPathLength {
+			path = append(path[:next], append([]APRSAddress{me}, path[next:]...)...)
+		}
+
+	default:
+		return p, false
+	}
+
+	p.Path = path
+	return p, true
+}
+
+// Handle takes a packet we've heard and repeats it if it should be repeated
+func (d *Digipeater) Handle(p APRSPacket) error {
+	key := dupKey(p)
+
+	d.mutex.Lock()
+
+	// Someone else has repeated a packet we were holding, so we don't need to
+	if t, ok := d.pending[key]; ok {
+		t.Stop()
+		delete(d.pending, key)
+		d.mutex.Unlock()
+		d.debugf("Another digipeater beat us to %v", p.Source)
+		return nil
+	}
+
+	if d.duplicate(key) {
+		d.mutex.Unlock()
+		return nil
+	}
+
+	out, ok := d.Digipeat(p)
+	if !ok {
+		d.mutex.Unlock()
+		return nil
+	}
+
+	if d.ViscousDelay > 0 {
+		d.pending[key] = time.AfterFunc(d.ViscousDelay, func() {
+			d.mutex.Lock()
+			_, still := d.pending[key]
+			delete(d.pending, key)
+			d.mutex.Unlock()
+
+			if still {
+				err := d.transmit(out)
+				if err != nil {
+					log.Printf("Error digipeating packet: %v\n", err)
+				}
+			}
+		})
+		d.mutex.Unlock()
+		return nil
+	}
+
+	d.mutex.Unlock()
+	return d.transmit(out)
+}
+
+// transmit sends a packet unless we're over our rate limit
+func (d *Digipeater) transmit(p APRSPacket) error {
+	d.mutex.Lock()
+
+	if d.RateLimit > 0 {
+		now := time.Now()
+		i := 0
+		for i < len(d.sent) && now.Sub(d.sent[i]) > d.RateWindow {
+			i++
+		}
+		d.sent = d.sent[i:]
+
+		if len(d.sent) >= d.RateLimit {
+			d.mutex.Unlock()
+			d.debugf("Rate limit reached, not repeating %v", p.Source)
+			return nil
+		}
+		d.sent = append(d.sent, now)
+	}
+
+	d.mutex.Unlock()
+
+	d.debugf("Repeating %v>%v via %v", p.Source, p.Dest, p.Path)
+
+	return d.Send(p)
+}
+
+// duplicate reports whether we've heard this packet within the duplicate window, and
+// remembers it if we haven't.  Called with d.mutex held.
+func (d *Digipeater) duplicate(key string) bool {
+	now := time.Now()
+
+	for k, t := range d.seen {
+		if now.Sub(t) > d.DupWindow {
+			delete(d.seen, k)
+		}
+	}
+
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+
+	d.seen[key] = now
+	return false
+}
+
+func (d *Digipeater) isAlias(a APRSAddress) bool {
+	for _, alias := range d.Aliases {
+		if sameStation(a, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Digipeater) debugf(format string, args ...interface{}) {
+	if d.Debug {
+		log.Printf("Digipeater: "+format+"\n", args...)
+	}
+}
+
+// dupKey identifies a packet regardless of the path it took to reach us
+func dupKey(p APRSPacket) string {
+	body := p.OriginalBody
+	if len(body) == 0 {
+		body = p.Body
+	}
+	return strings.ToUpper(p.Source.Callsign) + "-" + strconv.Itoa(int(p.Source.SSID)) + ">" +
+		strings.ToUpper(p.Dest.Callsign) + ":" + body
+}
+
+// isWideHop reports whether an address is a WIDEn-N alias, e.g. WIDE2-1
+func isWideHop(a APRSAddress) bool {
+	call := strings.ToUpper(a.Callsign)
+	if len(call) != 5 || !strings.HasPrefix(call, "WIDE") {
+		return false
+	}
+	return call[4] >= '1' && call[4] <= '7'
+}
+
+func sameStation(a, b APRSAddress) bool {
+	return strings.EqualFold(a.Callsign, b.Callsign) && a.SSID == b.SSID
+}
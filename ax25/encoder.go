@@ -9,104 +9,83 @@
 package ax25
 
 import (
-	"bytes"
 	"errors"
 )
 
-// A mask of 11100000, merged into the SSID byte with inclusive OR
-// If this is an AX.25 command packet, we merge it into the destination
-// address.  Otherwise, for an AX.25 response packet, we merge it into the
-// source address
-var setSSIDMask = byte(0x70 << 1)
-
-// A mask of 01100000, merged into the SSID byte with inclusive OR
-// If this is an AX.25 command packet, we merge it into the source
-// address.  Otherwise, for an AX.25 response packet, we merge it into the
-// destination address
-var clearSSIDMask = byte(0x30 << 1)
-
-// This encodes an AX.25 command packet.  It is differentiated from
-// the response packet function below by the bitmask applied to the SSID bytes.
+// This encodes an AX.25 command packet.  It is differentiated from the response
+// packet function below by the C bits: a command has the destination's C bit set
+// and the source's clear.
 func EncodeAX25Command(in APRSPacket) ([]byte, error) {
-	return CreatePacket(in, clearSSIDMask, setSSIDMask)
+	in.Dest.Command = true
+	in.Source.Command = false
+	return CreatePacket(in)
 }
 
+// EncodeAX25Response encodes an AX.25 response packet, with the source's C bit set and
+// the destination's clear
 func EncodeAX25Response(in APRSPacket) ([]byte, error) {
-	return CreatePacket(in, setSSIDMask, clearSSIDMask)
+	in.Dest.Command = false
+	in.Source.Command = true
+	return CreatePacket(in)
 }
 
-func CreatePacket(a APRSPacket, smask, dmask byte) (em []byte, err error) {
+// CommandFrame returns the UI frame that carries a packet as an AX.25 command, for links
+// to TNCs that take frames rather than KISS.  It checks that the frame can be encoded.
+func CommandFrame(in APRSPacket) (Frame, error) {
+	in.Dest.Command = true
+	in.Source.Command = false
 
-	if len(a.Source.Callsign) < 4 {
-		err = errors.New("Invalid source address.")
-		return
+	f, err := in.frame()
+	if err != nil {
+		return Frame{}, err
 	}
-
-	if a.Body == "" {
-		err = errors.New("APRS body is nil.")
-		return
+	if _, err = f.encodeAX25(); err != nil {
+		return Frame{}, err
 	}
+	return f, nil
+}
 
-	if a.Dest.Callsign == "" {
-		a.Dest = APRSAddress{
-			Callsign: "APZ001",
-		}
+// CreatePacket encodes a packet as a KISS frame, using the C bits already set on its
+// source and destination addresses
+func CreatePacket(a APRSPacket) (em []byte, err error) {
+	f, err := a.frame()
+	if err != nil {
+		return
 	}
 
-	p := &bytes.Buffer{}
-
-	// First, we send a Frame End (FEND)
-	p.Write([]byte{0xc0})
-
-	// Next comes our command field
-	p.Write([]byte{0x00})
+	return f.Encode()
+}
 
-	// Next comes the destination address
-	p.Write(encodeAX25Address(a.Dest, dmask))
+// frame returns the UI frame that carries a packet
+func (a APRSPacket) frame() (Frame, error) {
 
-	// Then the source address.  This part is a little tricky.
-	// If we are going to use digipeaters (i.e. we have a path), we have to set
-	// the last (least significant) bit of the SSID byte to 0.  If we *don't* have
-	// a path, we set it to 1.
-	mask := smask
-	if len(a.Path) == 0 {
-		// We don't have a path, so we set that last bit to 1
-		mask |= 1
+	if len(a.Source.Callsign) < 4 {
+		return Frame{}, errors.New("Invalid source address.")
 	}
-	// Now that we have our mask, we can encode our source address
-	p.Write(encodeAX25Address(a.Source, mask))
-
-	// Then our digipeater path
-	for i, v := range a.Path {
 
-		mask = byte(0x70 << 1)
-
-		// If this is the last station in the path, we also set our mask
-		// 0x61.   I'm not totally sure on this part and can't square it
-		// with the AX.25 spec but it works, so there.
-		if i == len(a.Path)-1 {
-			mask = byte(0x61)
-		}
-		p.Write(encodeAX25Address(v, mask))
+	if a.Body == "" {
+		return Frame{}, errors.New("APRS body is nil.")
 	}
 
-	// Then a control field (0x03 signifies that this is a UI-Frame)
-	p.Write([]byte{0x03})
-
-	// Then comes our protocol ID
-	p.Write([]byte{0xf0})
-
-	// Now comes the information field: the actual APRS data
-	p.WriteString(a.Body)
-
-	// Finally, another FEND
-	p.Write([]byte{0xc0})
+	if a.Dest.Callsign == "" {
+		a.Dest.Callsign = "APZ001"
+	}
 
-	return p.Bytes(), nil
+	// APRS goes in a UI frame with no layer 3 protocol
+	f := Frame{
+		Dest:    a.Dest,
+		Source:  a.Source,
+		Path:    a.Path,
+		Control: ctlUI,
+		PID:     PIDNoLayer3,
+		Info:    []byte(a.Body),
+		Port:    a.Port,
+	}
 
+	return f, nil
 }
 
-func encodeAX25Address(in APRSAddress, mask byte) []byte {
+func encodeAX25Address(in APRSAddress, digipeater bool) []byte {
 	out := make([]byte, 7)
 
 	for i := 0; i < len(out); i++ {
@@ -117,7 +96,7 @@ func encodeAX25Address(in APRSAddress, mask byte) []byte {
 		out[i] = byte(p) << 1
 	}
 
-	out[6] = mask | (byte(in.SSID) << 1)
+	out[6] = in.ssidByte(digipeater)
 
 	return out
 
@@ -0,0 +1,365 @@
+// GoBalloon
+// frame.go - Raw AX.25 frames of any type, for connected mode as well as APRS
+//
+// (c) 2014, Christopher Snell
+
+package ax25
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Frame is an AX.25 frame of any type: UI frames carrying APRS, and the I, S and U
+// frames of connected mode
+type Frame struct {
+	Dest    APRSAddress
+	Source  APRSAddress
+	Path    []APRSAddress
+	Control byte
+	PID     byte // Only I and UI frames carry a PID
+	Info    []byte
+	Port    int // KISS port (0-15) the frame was heard on or is to be sent on
+}
+
+// Protocol IDs
+const (
+	PIDISO8208  byte = 0x01
+	PIDTCPIP    byte = 0xcc
+	PIDARP      byte = 0xcd
+	PIDFlexNet  byte = 0xce
+	PIDNETROM   byte = 0xcf
+	PIDNoLayer3 byte = 0xf0
+	PIDEscape   byte = 0xff
+)
+
+// Control fields of S and U frames, with the poll/final bit clear
+const (
+	ctlRR    byte = 0x01
+	ctlRNR   byte = 0x05
+	ctlREJ   byte = 0x09
+	ctlSREJ  byte = 0x0d
+	ctlUI    byte = 0x03
+	ctlDM    byte = 0x0f
+	ctlSABM  byte = 0x2f
+	ctlDISC  byte = 0x43
+	ctlUA    byte = 0x63
+	ctlSABME byte = 0x6f
+	ctlFRMR  byte = 0x87
+	ctlXID   byte = 0xaf
+	ctlTEST  byte = 0xe3
+	ctlPF    byte = 0x10
+)
+
+// FrameType is the broad class of a frame: information, supervisory or unnumbered
+type FrameType int
+
+const (
+	FrameI FrameType = iota
+	FrameS
+	FrameU
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameI:
+		return "I"
+	case FrameS:
+		return "S"
+	}
+	return "U"
+}
+
+// FrameKind is the specific kind of frame, e.g. RR or SABM
+type FrameKind int
+
+const (
+	KindUnknown FrameKind = iota
+	KindI
+	KindRR
+	KindRNR
+	KindREJ
+	KindSREJ
+	KindUI
+	KindDM
+	KindSABM
+	KindDISC
+	KindUA
+	KindSABME
+	KindFRMR
+	KindXID
+	KindTEST
+)
+
+var kindNames = map[FrameKind]string{
+	KindI:     "I",
+	KindRR:    "RR",
+	KindRNR:   "RNR",
+	KindREJ:   "REJ",
+	KindSREJ:  "SREJ",
+	KindUI:    "UI",
+	KindDM:    "DM",
+	KindSABM:  "SABM",
+	KindDISC:  "DISC",
+	KindUA:    "UA",
+	KindSABME: "SABME",
+	KindFRMR:  "FRMR",
+	KindXID:   "XID",
+	KindTEST:  "TEST",
+}
+
+func (k FrameKind) String() string {
+	if n, ok := kindNames[k]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+var supervisoryKinds = map[byte]FrameKind{
+	ctlRR:   KindRR,
+	ctlRNR:  KindRNR,
+	ctlREJ:  KindREJ,
+	ctlSREJ: KindSREJ,
+}
+
+var unnumberedKinds = map[byte]FrameKind{
+	ctlUI:    KindUI,
+	ctlDM:    KindDM,
+	ctlSABM:  KindSABM,
+	ctlDISC:  KindDISC,
+	ctlUA:    KindUA,
+	ctlSABME: KindSABME,
+	ctlFRMR:  KindFRMR,
+	ctlXID:   KindXID,
+	ctlTEST:  KindTEST,
+}
+
+// Type returns the frame's broad class, from the low bits of its control field
+func (f Frame) Type() FrameType {
+	switch {
+	case f.Control&1 == 0:
+		return FrameI
+	case f.Control&3 == 1:
+		return FrameS
+	}
+	return FrameU
+}
+
+// Kind returns the specific kind of frame
+func (f Frame) Kind() FrameKind {
+	switch f.Type() {
+	case FrameI:
+		return KindI
+	case FrameS:
+		return supervisoryKinds[f.Control&0x0f]
+	}
+	return unnumberedKinds[f.Control&^ctlPF]
+}
+
+// PollFinal returns the poll/final bit: poll in a command, final in a response
+func (f Frame) PollFinal() bool {
+	return f.Control&ctlPF != 0
+}
+
+// NS returns an I frame's send sequence number
+func (f Frame) NS() int {
+	return int(f.Control>>1) & 7
+}
+
+// NR returns the receive sequence number of an I or S frame: the next I frame the
+// sender expects from us
+func (f Frame) NR() int {
+	return int(f.Control>>5) & 7
+}
+
+// IsCommand reports whether this frame is an AX.25 command, with the destination's C bit
+// set and the source's clear
+func (f Frame) IsCommand() bool {
+	return f.Dest.Command && !f.Source.Command
+}
+
+// IsAPRS reports whether this frame carries APRS: a UI frame with no layer 3 protocol
+func (f Frame) IsAPRS() bool {
+	return f.Kind() == KindUI && f.PID == PIDNoLayer3
+}
+
+// APRSPacket returns the frame as an APRS packet, or a *NotAPRSError if it isn't one
+func (f Frame) APRSPacket() (APRSPacket, error) {
+	if !f.IsAPRS() {
+		return APRSPacket{}, &NotAPRSError{Frame: f}
+	}
+
+	p := APRSPacket{
+		Source: f.Source,
+		Dest:   f.Dest,
+		Path:   f.Path,
+		Body:   string(f.Info),
+		Port:   f.Port,
+	}
+
+	// APRSMessage.Body gets modified by the APRS decoder so we'll save a copy of it in the
+	// struct that will remain in its original form
+	p.OriginalBody = p.Body
+
+	return p, nil
+}
+
+// hasPID reports whether frames with this control field carry a PID: I frames and UI frames
+func hasPID(control byte) bool {
+	return control&1 == 0 || control&^ctlPF == ctlUI
+}
+
+// Encode returns the frame wrapped in KISS framing, ready to write to a TNC
+func (f Frame) Encode() ([]byte, error) {
+	ax, err := f.encodeAX25()
+	if err != nil {
+		return nil, err
+	}
+
+	// FEND, the KISS data command for our port, the escaped frame, and another FEND
+	p := &bytes.Buffer{}
+	p.Write([]byte{fend, byte(f.Port<<4) | KISSData})
+	p.Write(kissEscape(ax))
+	p.WriteByte(fend)
+
+	return p.Bytes(), nil
+}
+
+// encodeAX25 returns the bare AX.25 frame, without KISS framing or an FCS
+func (f Frame) encodeAX25() ([]byte, error) {
+	if err := validAddress(f.Dest); err != nil {
+		return nil, fmt.Errorf("Invalid destination address: %v", err)
+	}
+	if err := validAddress(f.Source); err != nil {
+		return nil, fmt.Errorf("Invalid source address: %v", err)
+	}
+	if len(f.Path) > maxPathLength {
+		return nil, errLongPath
+	}
+	if f.Port < 0 || f.Port >= KISSPorts {
+		return nil, fmt.Errorf("KISS port %v is not 0-%v", f.Port, KISSPorts-1)
+	}
+
+	ax := &bytes.Buffer{}
+
+	// Destination, then source.  The last address in the frame has its extension bit
+	// (the least significant bit of the SSID byte) set to mark the end of the address
+	// field, so if we *don't* have a path, that's the source.
+	f.Dest.Last = false
+	ax.Write(encodeAX25Address(f.Dest, false))
+	f.Source.Last = len(f.Path) == 0
+	ax.Write(encodeAX25Address(f.Source, false))
+
+	// Then our digipeater path.  Digipeaters that have already repeated this frame get
+	// their H bit set.
+	for i, v := range f.Path {
+		if err := validAddress(v); err != nil {
+			return nil, fmt.Errorf("Invalid digipeater address: %v", err)
+		}
+		v.Last = i == len(f.Path)-1
+		ax.Write(encodeAX25Address(v, true))
+	}
+
+	ax.WriteByte(f.Control)
+	if hasPID(f.Control) {
+		ax.WriteByte(f.PID)
+	}
+	ax.Write(f.Info)
+
+	return ax.Bytes(), nil
+}
+
+// NextFrame returns the next frame of any type we receive
+func (d *Decoder) NextFrame() (Frame, error) {
+	frame, err := d.readKISS(2)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	// Drop the KISS command byte and the trailing FEND
+	f, err := decodeFrame(kissUnescape(frame[1 : len(frame)-1]))
+	f.Port = int(frame[0] >> 4)
+	return f, err
+}
+
+// decodeFrame decodes an AX.25 frame, without its KISS framing
+func decodeFrame(b []byte) (f Frame, err error) {
+	f.Dest, f.Source, f.Path, b, err = parseAddressField(b)
+	if err != nil {
+		return
+	}
+
+	if len(b) < 1 {
+		err = errTruncatedMsg
+		return
+	}
+	f.Control = b[0]
+	b = b[1:]
+
+	if hasPID(f.Control) {
+		if len(b) < 1 {
+			err = errTruncatedMsg
+			return
+		}
+		f.PID = b[0]
+		b = b[1:]
+	}
+
+	f.Info = append([]byte{}, b...)
+
+	return
+}
+
+// parseAddressField decodes the destination, source and digipeater addresses at the
+// start of an AX.25 frame and returns what follows them
+func parseAddressField(b []byte) (dest, source APRSAddress, path []APRSAddress, rest []byte, err error) {
+	if len(b) < 14 {
+		err = errShortMsg
+		return
+	}
+
+	// AX.25 addresses are in the format CCCCCCS, where C = callsign and S = SSID.  Since
+	// each btye of the address is shifted one bit to the left, we'll use our
+	// parseAX25Address() to decode it.  Gotta love 1980s protocols!
+	dest = parseAX25Address(b[0:7], false)
+
+	// Next verse same as the first.  Same old protocol, could be worse.
+	source = parseAX25Address(b[7:14], false)
+
+	path = []APRSAddress{}
+	b = b[14:]
+
+	// Now we're going to bite off 7-byte chunks of the frame and decode them as digipeater
+	// addresses.  We stop at the address with its extension bit set, which is the last
+	// one before the Control Field.
+	last := source.Last
+	for !last {
+		if len(b) < 7 {
+			err = errTruncatedMsg
+			return
+		}
+		if len(path) == maxPathLength {
+			err = errLongPath
+			return
+		}
+		digi := parseAX25Address(b[:7], true)
+		last = digi.Last
+		path = append(path, digi)
+		// As we parse each digipeater address, we remove it from the remaining frame
+		b = b[7:]
+	}
+
+	rest = b
+	return
+}
+
+// validAddress checks that an address will fit in the six characters AX.25 allows
+func validAddress(a APRSAddress) error {
+	if len(a.Callsign) == 0 || len(a.Callsign) > 6 {
+		return fmt.Errorf("Callsign %q must be 1-6 characters", a.Callsign)
+	}
+	if a.SSID > 15 {
+		return fmt.Errorf("SSID %v of %v is > 15", a.SSID, a.Callsign)
+	}
+	return nil
+}
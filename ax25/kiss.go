@@ -0,0 +1,253 @@
+// GoBalloon
+// kiss.go - KISS framing, ports and TNC parameter commands
+//
+// (c) 2014, Christopher Snell
+
+package ax25
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// KISS special characters.  A FEND inside a frame is sent as FESC TFEND and a FESC as
+// FESC TFESC, so that binary data can't end a frame early.
+const (
+	fend  = 0xc0
+	fesc  = 0xdb
+	tfend = 0xdc
+	tfesc = 0xdd
+)
+
+// KISS commands.  The byte after a FEND carries the command in its low nibble and the
+// TNC port (0-15) in its high nibble, except for KISSReturn, which is the whole byte.
+const (
+	KISSData        byte = 0x00
+	KISSTXDelay     byte = 0x01
+	KISSPersistence byte = 0x02
+	KISSSlotTime    byte = 0x03
+	KISSTXTail      byte = 0x04
+	KISSFullDuplex  byte = 0x05
+	KISSSetHardware byte = 0x06
+	KISSReturn      byte = 0xff
+)
+
+// KISSPorts is the number of ports a KISS TNC can have
+const KISSPorts = 16
+
+// KISSParams are the channel access parameters of a TNC port.  Nil fields are left at
+// whatever the TNC already has.
+type KISSParams struct {
+	TXDelay     *time.Duration // Time between keying the transmitter and sending data
+	Persistence *int           // 0-255: transmit in a free slot with probability (p+1)/256
+	SlotTime    *time.Duration // Time to wait between checks of a busy channel
+	TXTail      *time.Duration // Time to hold the transmitter up after the data (obsolete)
+	FullDuplex  *bool          // Transmit without waiting for a clear channel
+}
+
+// KISSCommand returns a KISS frame that sends a command other than KISSData to a port
+func KISSCommand(port int, cmd byte, value ...byte) ([]byte, error) {
+	if port < 0 || port >= KISSPorts {
+		return nil, fmt.Errorf("KISS port %v is not 0-%v", port, KISSPorts-1)
+	}
+	if cmd == KISSData || cmd > KISSSetHardware {
+		return nil, fmt.Errorf("Not a KISS parameter command: %#02x", cmd)
+	}
+
+	b := []byte{fend, byte(port<<4) | cmd}
+	b = append(b, kissEscape(value)...)
+	return append(b, fend), nil
+}
+
+// ConfigureTNC sends the parameters that are set in p to a port on the TNC
+func ConfigureTNC(w io.Writer, port int, p KISSParams) error {
+	var cmds [][]byte
+
+	add := func(cmd byte, value byte) error {
+		c, err := KISSCommand(port, cmd, value)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, c)
+		return nil
+	}
+
+	// The times all go in units of 10 ms
+	for _, t := range []struct {
+		cmd byte
+		d   *time.Duration
+	}{
+		{KISSTXDelay, p.TXDelay},
+		{KISSSlotTime, p.SlotTime},
+		{KISSTXTail, p.TXTail},
+	} {
+		if t.d == nil {
+			continue
+		}
+		units := (*t.d + 5*time.Millisecond) / (10 * time.Millisecond)
+		if *t.d < 0 || units > 255 {
+			return fmt.Errorf("KISS time %v is not 0-2.55s", *t.d)
+		}
+		if err := add(t.cmd, byte(units)); err != nil {
+			return err
+		}
+	}
+
+	if p.Persistence != nil {
+		if *p.Persistence < 0 || *p.Persistence > 255 {
+			return fmt.Errorf("KISS persistence %v is not 0-255", *p.Persistence)
+		}
+		if err := add(KISSPersistence, byte(*p.Persistence)); err != nil {
+			return err
+		}
+	}
+
+	if p.FullDuplex != nil {
+		var fd byte
+		if *p.FullDuplex {
+			fd = 1
+		}
+		if err := add(KISSFullDuplex, fd); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range cmds {
+		if _, err := w.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExitKISS sends the "return from KISS" command, which takes a TNC out of KISS mode and
+// back to its own command interpreter.  Some TNCs stay in KISS mode, even across power
+// cycles, until they get this.
+func ExitKISS(w io.Writer) error {
+	_, err := w.Write([]byte{fend, KISSReturn, fend})
+	return err
+}
+
+// KISSDemux splits the frames from a multi-port TNC into a stream for each port, so that
+// each port can have its own Decoder
+type KISSDemux struct {
+	r     *bufio.Reader
+	ports [KISSPorts]*io.PipeWriter
+	mutex sync.Mutex
+}
+
+// NewKISSDemux gets a new demultiplexer over the stream from a TNC
+func NewKISSDemux(r io.Reader) *KISSDemux {
+	return &KISSDemux{r: bufio.NewReader(r)}
+}
+
+// Port returns the frames received on a port.  It must be read from, or Run will block.
+// Frames for ports nobody has asked for are discarded.
+func (k *KISSDemux) Port(port int) (io.Reader, error) {
+	if port < 0 || port >= KISSPorts {
+		return nil, fmt.Errorf("KISS port %v is not 0-%v", port, KISSPorts-1)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.ports[port] != nil {
+		return nil, fmt.Errorf("KISS port %v is already being read", port)
+	}
+	pr, pw := io.Pipe()
+	k.ports[port] = pw
+	return pr, nil
+}
+
+// Release stops handing out a port's frames, and ends its stream, so that the port can
+// be asked for again
+func (k *KISSDemux) Release(port int) {
+	if port < 0 || port >= KISSPorts {
+		return
+	}
+
+	k.mutex.Lock()
+	pw := k.ports[port]
+	k.ports[port] = nil
+	k.mutex.Unlock()
+
+	if pw != nil {
+		pw.Close()
+	}
+}
+
+// Run hands out frames to their ports until the TNC stream fails, and then passes the
+// error on to all of them
+func (k *KISSDemux) Run() error {
+	for {
+		frame, err := k.r.ReadBytes(fend)
+		if err != nil {
+			k.mutex.Lock()
+			for _, pw := range k.ports {
+				if pw != nil {
+					pw.CloseWithError(err)
+				}
+			}
+			k.mutex.Unlock()
+			return err
+		}
+
+		// Skip the empty frames between back-to-back FENDs, and anything that isn't data
+		if len(frame) < 2 || frame[0]&0x0f != KISSData {
+			continue
+		}
+
+		k.mutex.Lock()
+		pw := k.ports[frame[0]>>4]
+		k.mutex.Unlock()
+
+		if pw != nil {
+			pw.Write(append([]byte{fend}, frame...))
+		}
+	}
+}
+
+// kissEscape escapes any FEND or FESC bytes in the contents of a frame
+func kissEscape(in []byte) []byte {
+	out := make([]byte, 0, len(in))
+
+	for _, b := range in {
+		switch b {
+		case fend:
+			out = append(out, fesc, tfend)
+		case fesc:
+			out = append(out, fesc, tfesc)
+		default:
+			out = append(out, b)
+		}
+	}
+
+	return out
+}
+
+// kissUnescape reverses kissEscape.  A FESC followed by anything else is passed through
+// as-is, which is what most TNCs do.
+func kissUnescape(in []byte) []byte {
+	out := make([]byte, 0, len(in))
+
+	for i := 0; i < len(in); i++ {
+		if in[i] == fesc && i+1 < len(in) {
+			switch in[i+1] {
+			case tfend:
+				out = append(out, fend)
+				i++
+				continue
+			case tfesc:
+				out = append(out, fesc)
+				i++
+				continue
+			}
+		}
+		out = append(out, in[i])
+	}
+
+	return out
+}
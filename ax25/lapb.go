@@ -0,0 +1,879 @@
+// GoBalloon
+// lapb.go - AX.25 connected mode (LAPB): reliable sessions between two stations, for
+// pulling flight logs and photos off the payload after it lands
+//
+// (c) 2014, Christopher Snell
+
+package ax25
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for connected-mode sessions
+const (
+	DefaultT1     = 5 * time.Second // Wait this long for an acknowledgement before polling
+	DefaultT3     = 3 * time.Minute // Poll an idle link this often to make sure it's still there
+	DefaultN2     = 10              // Give up after this many unanswered polls
+	DefaultWindow = 4               // Unacknowledged I frames we'll have outstanding (k), 1-7
+	DefaultPaclen = 128             // Largest information field we'll send
+)
+
+// How much received data we'll hold for a slow reader before telling the other end
+// we're busy, and how many I frames a writer can queue before Write blocks
+const (
+	maxReceiveBuffer = 16 * 1024
+	maxQueuedFrames  = 16
+)
+
+var (
+	ErrConnRefused   = errors.New("AX.25 connection refused")
+	ErrConnTimeout   = errors.New("AX.25 link timed out")
+	ErrConnReset     = errors.New("AX.25 connection reset by peer")
+	ErrConnClosed    = errors.New("AX.25 connection closed")
+	ErrStationClosed = errors.New("AX.25 station closed")
+)
+
+// timeoutError is returned when a read or write deadline passes
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "AX.25 i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Network makes an APRSAddress usable as a net.Addr
+func (a APRSAddress) Network() string {
+	return "ax25"
+}
+
+// Station runs AX.25 connected-mode sessions over a KISS TNC.  It owns the TNC: UI frames
+// (e.g. APRS) that it hears are passed to UI.  Set the exported fields before calling Run.
+type Station struct {
+	Callsign APRSAddress
+	T1       time.Duration
+	T3       time.Duration
+	N2       int
+	Window   int
+	Paclen   int
+	UI       func(Frame)
+	Debug    bool
+
+	tnc        io.ReadWriter
+	sessions   map[string]*Conn
+	accept     chan *Conn
+	listening  bool
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewStation returns a Station that answers to callsign on tnc
+func NewStation(tnc io.ReadWriter, callsign APRSAddress) *Station {
+	return &Station{
+		Callsign: callsign,
+		T1:       DefaultT1,
+		T3:       DefaultT3,
+		N2:       DefaultN2,
+		Window:   DefaultWindow,
+		Paclen:   DefaultPaclen,
+		tnc:      tnc,
+		sessions: make(map[string]*Conn),
+		accept:   make(chan *Conn, 8),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Run reads frames from the TNC and hands them to their sessions until the TNC fails
+// or the Station is closed
+func (s *Station) Run() error {
+	log.Println("ax25.Station.Run()")
+
+	d := NewDecoder(s.tnc)
+
+	for {
+		f, err := d.NextFrame()
+		if _, bad := err.(*MalformedFrameError); bad {
+			// Noise from the radio
+			s.debugf("Discarding bad frame: %v", err)
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return err
+		}
+
+		s.receive(f)
+	}
+}
+
+// Listen starts accepting incoming connections.  Until it's called, we refuse them.
+func (s *Station) Listen() {
+	s.mutex.Lock()
+	s.listening = true
+	s.mutex.Unlock()
+}
+
+// Accept waits for the next incoming connection
+func (s *Station) Accept() (*Conn, error) {
+	select {
+	case c := <-s.accept:
+		return c, nil
+	case <-s.closed:
+		return nil, ErrStationClosed
+	}
+}
+
+// Dial connects to remote, optionally through digipeaters, and waits until it answers
+func (s *Station) Dial(remote APRSAddress, via ...APRSAddress) (*Conn, error) {
+	key := stationKey(remote)
+
+	s.mutex.Lock()
+	if _, ok := s.sessions[key]; ok {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("Already connected to %v", remote)
+	}
+	c := s.newConn(remote, via)
+	s.sessions[key] = c
+	s.mutex.Unlock()
+
+	err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close drops every session and stops accepting new ones.  Run returns once the TNC
+// is closed.
+func (s *Station) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		s.mutex.Lock()
+		conns := make([]*Conn, 0, len(s.sessions))
+		for _, c := range s.sessions {
+			conns = append(conns, c)
+		}
+		s.listening = false
+		s.mutex.Unlock()
+
+		for _, c := range conns {
+			c.abort(ErrStationClosed)
+		}
+	})
+	return nil
+}
+
+// receive dispatches a frame we've heard
+func (s *Station) receive(f Frame) {
+	if f.Kind() == KindUI {
+		if s.UI != nil {
+			s.UI(f)
+		}
+		return
+	}
+
+	// Connected-mode frames must be for us, and must have made it through every digipeater
+	if !sameStation(f.Dest, s.Callsign) {
+		return
+	}
+	for _, a := range f.Path {
+		if !a.Repeated {
+			return
+		}
+	}
+
+	key := stationKey(f.Source)
+
+	s.mutex.Lock()
+	c := s.sessions[key]
+	if c == nil && f.Kind() == KindSABM && s.listening {
+		// Only Run sends on the accept channel, so there's no race in checking for room
+		if len(s.accept) == cap(s.accept) {
+			// Nobody is accepting connections fast enough
+			s.mutex.Unlock()
+			s.sendDM(f)
+			return
+		}
+
+		c = s.newConn(f.Source, reversePath(f.Path))
+		s.sessions[key] = c
+		s.mutex.Unlock()
+
+		c.accepted(f)
+		s.accept <- c
+		return
+	}
+	s.mutex.Unlock()
+
+	if c == nil {
+		// We're not connected to them, so tell them so (but never answer a DM or UA)
+		if kind := f.Kind(); kind != KindDM && kind != KindUA {
+			s.sendDM(f)
+		}
+		return
+	}
+
+	c.receive(f)
+}
+
+func (s *Station) newConn(remote APRSAddress, via []APRSAddress) *Conn {
+	remote.Repeated = false
+	remote.Command = false
+	remote.Last = false
+
+	path := make([]APRSAddress, len(via))
+	for i, a := range via {
+		path[i] = APRSAddress{Callsign: a.Callsign, SSID: a.SSID}
+	}
+
+	c := &Conn{
+		station: s,
+		remote:  remote,
+		path:    path,
+	}
+	c.cond = sync.NewCond(&c.mutex)
+
+	return c
+}
+
+// remove forgets a session that has ended
+func (s *Station) remove(c *Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := stationKey(c.remote)
+	if s.sessions[key] == c {
+		delete(s.sessions, key)
+	}
+}
+
+// sendDM answers a frame with a disconnected-mode response
+func (s *Station) sendDM(f Frame) {
+	dm := Frame{
+		Dest:    APRSAddress{Callsign: f.Source.Callsign, SSID: f.Source.SSID},
+		Source:  APRSAddress{Callsign: s.Callsign.Callsign, SSID: s.Callsign.SSID, Command: true},
+		Path:    reversePath(f.Path),
+		Control: ctlDM | f.Control&ctlPF,
+	}
+
+	err := s.Send(dm)
+	if err != nil {
+		log.Printf("Error sending AX.25 DM: %v\n", err)
+	}
+}
+
+// Send writes a frame to the TNC, e.g. a UI frame carrying APRS
+func (s *Station) Send(f Frame) error {
+	b, err := f.Encode()
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	_, err = s.tnc.Write(b)
+	return err
+}
+
+func (s *Station) window() int {
+	if s.Window < 1 {
+		return 1
+	}
+	if s.Window > 7 {
+		return 7
+	}
+	return s.Window
+}
+
+func (s *Station) debugf(format string, args ...interface{}) {
+	if s.Debug {
+		log.Printf("ax25.Station %v: "+format+"\n", append([]interface{}{s.Callsign}, args...)...)
+	}
+}
+
+type connState int
+
+const (
+	stateDisconnected connState = iota
+	stateConnecting
+	stateConnected
+	stateDisconnecting
+)
+
+// Conn is an AX.25 connected-mode session.  It's a net.Conn, so it can be used with
+// anything that works over TCP.
+type Conn struct {
+	station *Station
+	remote  APRSAddress
+	path    []APRSAddress
+	state   connState
+	err     error // Why the link went down
+
+	// Sequence state, modulo 8.  outq holds the data for every I frame from V(A) on;
+	// the first sent of them have been transmitted, so V(S) is va+sent.  When we go back
+	// to resend, highest remembers how many had been transmitted.
+	va       int
+	vr       int
+	outq     [][]byte
+	sent     int
+	highest  int
+	recv     bytes.Buffer
+	ownBusy  bool
+	peerBusy bool
+	rejSent  bool
+	ackDue   bool
+	retries  int
+
+	// Timers are invalidated by bumping their generation, so a timer that fires just as
+	// it's stopped does nothing
+	t1gen     int
+	t1running bool
+	t3gen     int
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	mutex sync.Mutex
+	cond  *sync.Cond
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// connect sends SABM and waits for the other end to answer
+func (c *Conn) connect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.state = stateConnecting
+	c.sendU(ctlSABM, true, true)
+	c.startT1()
+
+	for c.state == stateConnecting {
+		c.cond.Wait()
+	}
+
+	if c.state != stateConnected {
+		return c.err
+	}
+	return nil
+}
+
+// accepted answers an incoming SABM
+func (c *Conn) accepted(sabm Frame) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.state = stateConnected
+	c.sendU(ctlUA, false, sabm.PollFinal())
+	c.startT3()
+
+	c.station.debugf("Accepted connection from %v", c.remote)
+}
+
+// receive handles a frame from the other end
+func (c *Conn) receive(f Frame) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pf := f.PollFinal()
+
+	switch f.Kind() {
+	case KindSABM:
+		if c.state == stateDisconnecting {
+			c.sendU(ctlDM, false, pf)
+			return
+		}
+		// The other end is (re)starting the link, so anything in flight is lost
+		c.va, c.vr, c.sent, c.highest = 0, 0, 0, 0
+		c.outq = nil
+		c.peerBusy, c.rejSent = false, false
+		c.state = stateConnected
+		c.sendU(ctlUA, false, pf)
+		c.stopT1()
+		c.startT3()
+		c.cond.Broadcast()
+
+	case KindDISC:
+		c.sendU(ctlUA, false, pf)
+		c.down(io.EOF)
+
+	case KindUA:
+		switch c.state {
+		case stateConnecting:
+			c.state = stateConnected
+			c.retries = 0
+			c.stopT1()
+			c.startT3()
+			c.cond.Broadcast()
+			c.station.debugf("Connected to %v", c.remote)
+		case stateDisconnecting:
+			c.down(ErrConnClosed)
+		}
+
+	case KindDM:
+		switch c.state {
+		case stateConnecting:
+			c.down(ErrConnRefused)
+		case stateDisconnecting:
+			c.down(ErrConnClosed)
+		default:
+			c.down(ErrConnReset)
+		}
+
+	case KindFRMR:
+		c.down(ErrConnReset)
+
+	case KindI:
+		c.receiveI(f, pf)
+
+	case KindRR, KindRNR, KindREJ:
+		c.receiveS(f, pf, f.IsCommand())
+	}
+}
+
+// receiveI handles an information frame.  Called with c.mutex held.
+func (c *Conn) receiveI(f Frame, pf bool) {
+	if c.state != stateConnected {
+		return
+	}
+
+	ns, nr := f.NS(), f.NR()
+
+	if !c.ackUpTo(nr) {
+		return
+	}
+
+	switch {
+	case c.ownBusy:
+		// We've nowhere to put it; they'll send it again once we say we're ready
+	case ns == c.vr:
+		c.recv.Write(f.Info)
+		c.vr = (c.vr + 1) % 8
+		c.rejSent = false
+		if c.recv.Len() >= maxReceiveBuffer {
+			c.ownBusy = true
+		}
+		c.cond.Broadcast()
+	case !c.rejSent:
+		// Out of sequence: ask for everything from the one we're missing
+		c.rejSent = true
+		c.sendS(ctlREJ, false, pf)
+		c.transmit()
+		return
+	}
+
+	if pf {
+		c.sendS(c.readyOrBusy(), false, true)
+	} else {
+		c.ackDue = true
+	}
+
+	// Our acknowledgement can ride along with any I frames we have to send
+	c.transmit()
+	if c.ackDue {
+		c.sendS(c.readyOrBusy(), false, false)
+	}
+}
+
+// receiveS handles a supervisory frame.  Called with c.mutex held.
+func (c *Conn) receiveS(f Frame, pf, command bool) {
+	if c.state != stateConnected {
+		return
+	}
+
+	kind := f.Kind()
+	c.peerBusy = kind == KindRNR
+
+	if !c.ackUpTo(f.NR()) {
+		return
+	}
+
+	if kind == KindREJ {
+		// Go back and send everything from N(R) again
+		c.sent = 0
+	}
+
+	if command && pf {
+		// They're asking where we're up to
+		c.sendS(c.readyOrBusy(), false, true)
+	}
+
+	if !command && pf {
+		// The answer to our poll.  Anything still outstanding was lost.
+		c.retries = 0
+		c.stopT1()
+		c.sent = 0
+	}
+
+	c.transmit()
+
+	if !c.t1running {
+		c.startT3()
+	}
+}
+
+// ackUpTo processes an acknowledgement of every I frame before nr.  It reports false if
+// nr acknowledges frames we haven't sent.  Called with c.mutex held.
+func (c *Conn) ackUpTo(nr int) bool {
+	n := (nr - c.va + 8) % 8
+	if n > c.highest {
+		c.station.debugf("Ignoring bad N(R) %v from %v (V(A) %v, %v outstanding)", nr, c.remote, c.va, c.highest)
+		return false
+	}
+	if n == 0 {
+		return true
+	}
+
+	c.outq = c.outq[n:]
+	c.highest -= n
+	c.sent -= n
+	if c.sent < 0 {
+		c.sent = 0
+	}
+	c.va = nr
+	c.retries = 0
+	c.cond.Broadcast()
+
+	if c.sent == 0 {
+		c.stopT1()
+		c.startT3()
+	} else {
+		c.startT1()
+	}
+
+	return true
+}
+
+// transmit sends as many queued I frames as the window allows.  Called with c.mutex held.
+func (c *Conn) transmit() {
+	for c.state == stateConnected && !c.peerBusy && c.sent < len(c.outq) && c.sent < c.station.window() {
+		ns := (c.va + c.sent) % 8
+		control := byte(ns<<1) | byte(c.vr<<5)
+
+		c.sendFrame(c.frame(control, true, c.outq[c.sent]))
+		c.sent++
+		if c.sent > c.highest {
+			c.highest = c.sent
+		}
+		c.ackDue = false
+
+		if !c.t1running {
+			c.startT1()
+		}
+	}
+}
+
+// t1Expired runs when we've waited too long for an answer
+func (c *Conn) t1Expired() {
+	c.retries++
+
+	if c.retries > c.station.N2 {
+		switch c.state {
+		case stateConnecting:
+			c.down(ErrConnTimeout)
+		case stateDisconnecting:
+			c.down(ErrConnClosed)
+		default:
+			c.sendU(ctlDM, false, false)
+			c.down(ErrConnTimeout)
+		}
+		return
+	}
+
+	switch c.state {
+	case stateConnecting:
+		c.sendU(ctlSABM, true, true)
+	case stateDisconnecting:
+		c.sendU(ctlDISC, true, true)
+	case stateConnected:
+		// Ask the other end where it's up to
+		c.sendS(c.readyOrBusy(), true, true)
+	default:
+		return
+	}
+
+	c.startT1()
+}
+
+// t3Expired runs when the link has been idle a while
+func (c *Conn) t3Expired() {
+	if c.state != stateConnected || c.t1running {
+		return
+	}
+
+	c.retries = 0
+	c.sendS(c.readyOrBusy(), true, true)
+	c.startT1()
+}
+
+func (c *Conn) startT1() {
+	c.t1gen++
+	gen := c.t1gen
+	c.t1running = true
+	c.t3gen++
+
+	time.AfterFunc(c.station.T1, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if gen == c.t1gen {
+			c.t1running = false
+			c.t1Expired()
+		}
+	})
+}
+
+func (c *Conn) stopT1() {
+	c.t1gen++
+	c.t1running = false
+}
+
+func (c *Conn) startT3() {
+	c.t3gen++
+	gen := c.t3gen
+
+	time.AfterFunc(c.station.T3, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if gen == c.t3gen {
+			c.t3Expired()
+		}
+	})
+}
+
+// down ends the session.  Called with c.mutex held.
+func (c *Conn) down(err error) {
+	if c.state == stateDisconnected {
+		return
+	}
+
+	c.station.debugf("Link to %v down: %v", c.remote, err)
+
+	c.state = stateDisconnected
+	c.err = err
+	c.stopT1()
+	c.t3gen++
+	c.cond.Broadcast()
+
+	c.station.remove(c)
+}
+
+// abort ends the session without telling the other end
+func (c *Conn) abort(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.down(err)
+}
+
+func (c *Conn) readyOrBusy() byte {
+	if c.ownBusy {
+		return ctlRNR
+	}
+	return ctlRR
+}
+
+// frame builds a frame to the other end.  Commands have the destination's C bit set.
+func (c *Conn) frame(control byte, command bool, info []byte) Frame {
+	f := Frame{
+		Dest:    c.remote,
+		Source:  c.station.Callsign,
+		Path:    c.path,
+		Control: control,
+		Info:    info,
+	}
+	f.Dest.Command = command
+	f.Source.Command = !command
+	f.Source.Repeated = false
+
+	if hasPID(control) {
+		f.PID = PIDNoLayer3
+	}
+
+	return f
+}
+
+func (c *Conn) sendU(control byte, command, pf bool) {
+	if pf {
+		control |= ctlPF
+	}
+	c.sendFrame(c.frame(control, command, nil))
+}
+
+func (c *Conn) sendS(kind byte, command, pf bool) {
+	control := kind | byte(c.vr<<5)
+	if pf {
+		control |= ctlPF
+	}
+	c.sendFrame(c.frame(control, command, nil))
+	c.ackDue = false
+}
+
+func (c *Conn) sendFrame(f Frame) {
+	err := c.station.Send(f)
+	if err != nil {
+		log.Printf("Error sending AX.25 frame to %v: %v\n", c.remote, err)
+	}
+}
+
+// wait waits for something to change, or for deadline to pass.  It reports whether the
+// deadline has passed.  Called with c.mutex held.
+func (c *Conn) wait(deadline time.Time) bool {
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return true
+		}
+		t := time.AfterFunc(d, func() {
+			c.mutex.Lock()
+			c.cond.Broadcast()
+			c.mutex.Unlock()
+		})
+		defer t.Stop()
+	}
+
+	c.cond.Wait()
+	return false
+}
+
+// Read reads data the other end has sent us.  Once the other end disconnects and
+// we've read everything it sent, Read returns io.EOF.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for c.recv.Len() == 0 {
+		if c.state != stateConnected {
+			if c.err != nil {
+				return 0, c.err
+			}
+			return 0, ErrConnClosed
+		}
+		if c.wait(c.readDeadline) {
+			return 0, timeoutError{}
+		}
+	}
+
+	n, _ := c.recv.Read(b)
+
+	// Let the other end know once we've room for more
+	if c.ownBusy && c.recv.Len() < maxReceiveBuffer/2 {
+		c.ownBusy = false
+		c.sendS(ctlRR, false, false)
+	}
+
+	return n, nil
+}
+
+// Write queues data to send to the other end in Paclen-sized I frames.  It blocks while
+// too much is waiting to be acknowledged.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := 0
+	for len(b) > 0 {
+		for c.state == stateConnected && len(c.outq) >= maxQueuedFrames {
+			if c.wait(c.writeDeadline) {
+				return n, timeoutError{}
+			}
+		}
+		if c.state != stateConnected {
+			if c.err == nil || c.err == io.EOF {
+				return n, ErrConnClosed
+			}
+			return n, c.err
+		}
+
+		seg := b
+		if len(seg) > c.station.Paclen {
+			seg = seg[:c.station.Paclen]
+		}
+		c.outq = append(c.outq, append([]byte{}, seg...))
+		b = b[len(seg):]
+		n += len(seg)
+
+		c.transmit()
+	}
+
+	return n, nil
+}
+
+// Close waits for everything we've written to be acknowledged, then disconnects
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for c.state == stateConnected && len(c.outq) > 0 {
+		c.cond.Wait()
+	}
+
+	if c.state == stateConnected {
+		c.state = stateDisconnecting
+		c.retries = 0
+		c.t3gen++
+		c.sendU(ctlDISC, true, true)
+		c.startT1()
+	}
+
+	for c.state != stateDisconnected {
+		c.cond.Wait()
+	}
+
+	return nil
+}
+
+// LocalAddr returns our callsign
+func (c *Conn) LocalAddr() net.Addr {
+	return c.station.Callsign
+}
+
+// RemoteAddr returns the other station's callsign
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	return nil
+}
+
+// stationKey identifies a station regardless of the flags on its address
+func stationKey(a APRSAddress) string {
+	return fmt.Sprintf("%s-%d", strings.ToUpper(a.Callsign), a.SSID)
+}
+
+// reversePath turns the path a frame took to reach us into the path for our reply
+func reversePath(path []APRSAddress) []APRSAddress {
+	rev := make([]APRSAddress, len(path))
+	for i, a := range path {
+		rev[len(path)-1-i] = APRSAddress{Callsign: a.Callsign, SSID: a.SSID}
+	}
+	return rev
+}
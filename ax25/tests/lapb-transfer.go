@@ -0,0 +1,192 @@
+package main
+
+// Pulls a "photo" off a simulated payload over an AX.25 connected-mode link, first on a
+// clean channel and then on one that loses frames
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tnc is one end of a radio link.  Each Write is one KISS frame, which is lost with
+// probability loss; the rest arrive at the other end in order.
+type tnc struct {
+	io.Reader
+	out  chan []byte
+	loss float64
+	sent int
+	lost int
+	mu   sync.Mutex
+}
+
+func (t *tnc) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	t.sent++
+	drop := rand.Float64() < t.loss
+	if drop {
+		t.lost++
+	}
+	t.mu.Unlock()
+
+	if !drop {
+		t.out <- append([]byte{}, b...)
+	}
+	return len(b), nil
+}
+
+// link connects two TNCs
+func link(loss float64) (*tnc, *tnc) {
+	ar, aw := io.Pipe()
+	br, bw := io.Pipe()
+
+	a := &tnc{Reader: ar, out: make(chan []byte, 1000), loss: loss}
+	b := &tnc{Reader: br, out: make(chan []byte, 1000), loss: loss}
+
+	go func() {
+		for f := range a.out {
+			bw.Write(f)
+		}
+	}()
+	go func() {
+		for f := range b.out {
+			aw.Write(f)
+		}
+	}()
+
+	return a, b
+}
+
+var (
+	ground  = ax25.APRSAddress{Callsign: "N0CALL", SSID: 1}
+	balloon = ax25.APRSAddress{Callsign: "N0CALL", SSID: 11}
+)
+
+func stations(loss float64) (*ax25.Station, *ax25.Station, *tnc) {
+	gt, bt := link(loss)
+
+	g := ax25.NewStation(gt, ground)
+	b := ax25.NewStation(bt, balloon)
+	for _, s := range []*ax25.Station{g, b} {
+		s.T1 = 100 * time.Millisecond
+		s.T3 = time.Second
+		s.N2 = 20
+		go s.Run()
+	}
+
+	return g, b, gt
+}
+
+// serve sends files to whoever asks, one request per connection
+func serve(s *ax25.Station, files map[string][]byte) {
+	for {
+		c, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go func(c *ax25.Conn) {
+			name, err := bufio.NewReader(c).ReadString('\n')
+			if err != nil {
+				c.Close()
+				return
+			}
+			c.Write(files[strings.TrimSpace(name)])
+			c.Close()
+		}(c)
+	}
+}
+
+func fetch(s *ax25.Station, name string) ([]byte, error) {
+	c, err := s.Dial(balloon)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(c, "%v\n", name)
+	data, err := ioutil.ReadAll(c)
+	c.Close()
+	return data, err
+}
+
+func main() {
+	// Plenty of FENDs and FESCs to make sure KISS escaping works
+	photo := make([]byte, 20000)
+	rand.Read(photo)
+	for i := 0; i < len(photo); i += 97 {
+		photo[i] = 0xc0
+		photo[i+1] = 0xdb
+	}
+	files := map[string][]byte{"photo.jpg": photo}
+	want := fmt.Sprintf("%x", sha1.Sum(photo))
+
+	for _, loss := range []float64{0, 0.2} {
+		g, b, gt := stations(loss)
+		b.Listen()
+		go serve(b, files)
+
+		start := time.Now()
+		data, err := fetch(g, "photo.jpg")
+		checks.Equal(fmt.Sprintf("fetch with %.0f%% loss", loss*100), err, nil)
+		checks.Equal(fmt.Sprintf("photo intact with %.0f%% loss", loss*100), fmt.Sprintf("%x", sha1.Sum(data)), want)
+		fmt.Printf("     %v bytes in %v, %v frames sent by ground, %v lost\n", len(data), time.Since(start), gt.sent, gt.lost)
+
+		g.Close()
+		b.Close()
+	}
+
+	// Nobody listening
+	g, _, _ := stations(0)
+	_, err := g.Dial(balloon)
+	checks.Equal("refused when not listening", err, ax25.ErrConnRefused)
+
+	// Nobody there at all
+	g, b, _ := stations(1)
+	g.N2 = 3
+	b.Listen()
+	_, err = g.Dial(balloon)
+	checks.Equal("times out when nobody answers", err, ax25.ErrConnTimeout)
+
+	// UI frames go to the UI handler, not to a session
+	g, b, _ = stations(0)
+	heard := make(chan ax25.Frame, 1)
+	g.UI = func(f ax25.Frame) { heard <- f }
+	pkt, _ := ax25.EncodeAX25Command(ax25.APRSPacket{Source: balloon, Dest: ax25.APRSAddress{Callsign: "APZ001"}, Body: ">hello"})
+	frame, _ := ax25.NewDecoder(bytes.NewReader(pkt)).NextFrame()
+	b.Send(frame)
+	select {
+	case f := <-heard:
+		checks.Equal("UI frame heard", string(f.Info), ">hello")
+	case <-time.After(time.Second):
+		checks.Equal("UI frame heard", "nothing", ">hello")
+	}
+
+	// Read deadlines
+	g, b, _ = stations(0)
+	b.Listen()
+	go func() {
+		c, _ := b.Accept()
+		time.Sleep(time.Second)
+		c.Close()
+	}()
+	c, err := g.Dial(balloon)
+	checks.Equal("dial for deadline", err, nil)
+	if err == nil {
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = c.Read(make([]byte, 10))
+		te, ok := err.(interface{ Timeout() bool })
+		checks.Equal("read deadline", ok && te.Timeout(), true)
+		c.SetReadDeadline(time.Time{})
+		_, err = c.Read(make([]byte, 10))
+		checks.Equal("EOF after remote close", err, io.EOF)
+	}
+
+	checks.Done()
+}
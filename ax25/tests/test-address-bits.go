@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+)
+
+func decode(frame []byte) (ax25.APRSPacket, error) {
+	return ax25.NewDecoder(bytes.NewReader(frame)).Next()
+}
+
+func main() {
+	p := ax25.APRSPacket{
+		Source: ax25.APRSAddress{Callsign: "NW5W", SSID: 7},
+		Dest:   ax25.APRSAddress{Callsign: "APZ001"},
+		Path: []ax25.APRSAddress{
+			{Callsign: "K7ABC", SSID: 3, Repeated: true},
+			{Callsign: "WIDE1", Repeated: true},
+			{Callsign: "WIDE2", SSID: 1},
+		},
+		Body: "!4715.68N/12228.20W-GoBalloon Test",
+	}
+
+	// Byte layout: FEND, command, dest, source, three digipeaters
+	frame, err := ax25.EncodeAX25Command(p)
+	checks.Equal("encode command", err, nil)
+	checks.Equal("dest SSID byte (C set)", fmt.Sprintf("%#x", frame[8]), "0xe0")
+	checks.Equal("source SSID byte (C clear)", fmt.Sprintf("%#x", frame[15]), "0x6e")
+	checks.Equal("repeated digi SSID byte (H set)", fmt.Sprintf("%#x", frame[22]), "0xe6")
+	checks.Equal("repeated alias SSID byte (H set)", fmt.Sprintf("%#x", frame[29]), "0xe0")
+	checks.Equal("last digi SSID byte (extension set)", fmt.Sprintf("%#x", frame[36]), "0x63")
+
+	d, err := decode(frame)
+	checks.Equal("decode command", err, nil)
+	checks.Equal("is command", d.IsCommand(), true)
+	checks.Equal("is response", d.IsResponse(), false)
+	checks.Equal("path", d.Path, "[K7ABC-3* WIDE1* WIDE2-1]")
+	checks.Equal("last flags", fmt.Sprint(d.Source.Last, d.Path[0].Last, d.Path[2].Last), "false false true")
+	checks.Equal("repeated by", d.RepeatedBy(), "[K7ABC-3* WIDE1*]")
+	checks.Equal("heard from", d.HeardFrom(), "K7ABC-3*")
+
+	frame, err = ax25.EncodeAX25Response(p)
+	checks.Equal("encode response", err, nil)
+	d, err = decode(frame)
+	checks.Equal("decode response", err, nil)
+	checks.Equal("is response", d.IsResponse(), true)
+	checks.Equal("is command", d.IsCommand(), false)
+
+	// No path: the source is the last address
+	p.Path = nil
+	frame, _ = ax25.EncodeAX25Command(p)
+	checks.Equal("source SSID byte with no path", fmt.Sprintf("%#x", frame[15]), "0x6f")
+	d, err = decode(frame)
+	checks.Equal("decode with no path", err, nil)
+	checks.Equal("heard direct from", d.HeardFrom(), "NW5W-7")
+	checks.Equal("repeated by nobody", len(d.RepeatedBy()), 0)
+
+	// Heard via a digipeater that doesn't identify itself
+	p.Path = []ax25.APRSAddress{{Callsign: "WIDE2", SSID: 1, Repeated: true}}
+	frame, _ = ax25.EncodeAX25Command(p)
+	d, _ = decode(frame)
+	checks.Equal("heard from an alias", d.HeardFrom(), "WIDE2-1*")
+
+	// A body that starts with 0x03 used to be mistaken for the control field
+	p.Path = []ax25.APRSAddress{{Callsign: "WIDE2", SSID: 1}}
+	p.Body = "\x03\xf0odd"
+	frame, _ = ax25.EncodeAX25Command(p)
+	d, err = decode(frame)
+	checks.Equal("decode odd body", err, nil)
+	checks.Equal("odd body", fmt.Sprintf("%q", d.Body), fmt.Sprintf("%q", "\x03\xf0odd"))
+
+	// Nine digipeaters is more than AX.25 allows
+	p.Body = ">too long"
+	p.Path = nil
+	for i := 0; i < 9; i++ {
+		p.Path = append(p.Path, ax25.APRSAddress{Callsign: "WIDE1", SSID: 1})
+	}
+	frame, _ = ax25.EncodeAX25Command(p)
+	_, err = decode(frame)
+	checks.Equal("path too long", err != nil, true)
+
+	checks.Done()
+}
@@ -0,0 +1,152 @@
+package main
+
+// Talks to a fake AGWPE server over a pipe: registration, unproto sends with and without
+// a path, raw sends for digipeated packets, and both kinds of monitored frames
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"io"
+	"net"
+)
+
+type message struct {
+	port, kind, pid byte
+	from, to        string
+	data            []byte
+}
+
+func field(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func readMessage(r io.Reader) (message, error) {
+	h := make([]byte, 36)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return message{}, err
+	}
+	m := message{port: h[0], kind: h[4], pid: h[6], from: field(h[8:18]), to: field(h[18:28])}
+	m.data = make([]byte, binary.LittleEndian.Uint32(h[28:32]))
+	_, err := io.ReadFull(r, m.data)
+	return m, err
+}
+
+func writeMessage(w io.Writer, m message) {
+	h := make([]byte, 36)
+	h[0], h[4], h[6] = m.port, m.kind, m.pid
+	copy(h[8:17], m.from)
+	copy(h[18:27], m.to)
+	binary.LittleEndian.PutUint32(h[28:32], uint32(len(m.data)))
+	w.Write(append(h, m.data...))
+}
+
+func main() {
+	client, server := net.Pipe()
+	c := ax25.NewAGWPEClient(client)
+
+	// The server says yes to N0CALL-11 and no to anyone else
+	got := make(chan message, 10)
+	go func() {
+		for {
+			m, err := readMessage(server)
+			if err != nil {
+				close(got)
+				return
+			}
+			if m.kind == 'X' {
+				ok := byte(0)
+				if m.from == "N0CALL-11" {
+					ok = 1
+				}
+				writeMessage(server, message{kind: 'X', from: m.from, data: []byte{ok}})
+				continue
+			}
+			got <- m
+		}
+	}()
+
+	balloon := ax25.APRSAddress{Callsign: "N0CALL", SSID: 11}
+	checks.Equal("register", c.Register(balloon), nil)
+	checks.Equal("register refused", c.Register(ax25.APRSAddress{Callsign: "NOCALL"}) != nil, true)
+
+	checks.Equal("monitor raw", c.MonitorRaw(), nil)
+	m := <-got
+	checks.Equal("monitor raw kind", string(m.kind), "k")
+
+	// No path: 'M'
+	p := ax25.APRSPacket{Source: balloon, Body: ">hello", Port: 1}
+	checks.Equal("send direct", c.Send(p), nil)
+	m = <-got
+	checks.Equal("direct kind", string(m.kind), "M")
+	checks.Equal("direct header", fmt.Sprintf("%v %#02x %v>%v", m.port, m.pid, m.from, m.to), "1 0xf0 N0CALL-11>APZ001")
+	checks.Equal("direct data", string(m.data), ">hello")
+
+	// A path: 'V', with the digipeaters ahead of the data
+	p.Port = 0
+	p.Path = []ax25.APRSAddress{{Callsign: "WIDE1", SSID: 1}, {Callsign: "WIDE2", SSID: 1}}
+	checks.Equal("send via", c.Send(p), nil)
+	m = <-got
+	checks.Equal("via kind", string(m.kind), "V")
+	checks.Equal("via count", m.data[0], 2)
+	checks.Equal("via digis", field(m.data[1:11])+","+field(m.data[11:21]), "WIDE1-1,WIDE2-1")
+	checks.Equal("via data", string(m.data[21:]), ">hello")
+
+	// Digipeated, with an H bit set: raw, so the H bit survives
+	p.Path = []ax25.APRSAddress{{Callsign: "N0CALL", SSID: 11, Repeated: true}, {Callsign: "WIDE2", SSID: 1}}
+	checks.Equal("send digipeated", c.Send(p), nil)
+	m = <-got
+	checks.Equal("digipeated kind", string(m.kind), "K")
+	kiss, _ := ax25.EncodeAX25Command(p)
+	// Raw data is a zero byte and the bare frame, the same as a KISS frame without FENDs
+	checks.Equal("digipeated frame", fmt.Sprintf("% x", m.data), fmt.Sprintf("% x", kiss[1:len(kiss)-1]))
+
+	// Frames the server heard, raw...
+	heard, _ := ax25.EncodeAX25Command(ax25.APRSPacket{
+		Source: ax25.APRSAddress{Callsign: "K7ABC", SSID: 9},
+		Path:   []ax25.APRSAddress{{Callsign: "WIDE1", Repeated: true}, {Callsign: "WIDE2", SSID: 1}},
+		Body:   "!4715.68N/12228.20W-raw",
+	})
+	writeMessage(server, message{port: 2, kind: 'K', data: heard[1 : len(heard)-1]})
+	r, err := c.Next()
+	checks.Equal("raw heard", err, nil)
+	checks.Equal("raw packet", fmt.Sprintf("%v %v %v %v", r.Port, r.Source, r.Path, r.Body), "2 K7ABC-9 [WIDE1* WIDE2-1] !4715.68N/12228.20W-raw")
+
+	// ...and decoded to text
+	body := "!4715.68N/12228.20W-text\rwith a CR"
+	mon := fmt.Sprintf(" 1:Fm K7ABC-9 To APRS Via K7XYZ-3,WIDE1*,WIDE2-1 <UI pid=F0 Len=%v >[12:34:56]\r%v\r\x00", len(body), body)
+	writeMessage(server, message{kind: 'U', from: "K7ABC-9", to: "APRS", data: []byte(mon)})
+	r, err = c.Next()
+	checks.Equal("monitored heard", err, nil)
+	checks.Equal("monitored packet", fmt.Sprintf("%v>%v %v", r.Source, r.Dest, r.Path), "K7ABC-9>APRS [K7XYZ-3* WIDE1* WIDE2-1]")
+	checks.Equal("monitored body", fmt.Sprintf("%q", r.Body), fmt.Sprintf("%q", body))
+	checks.Equal("heard from", r.HeardFrom(), "K7XYZ-3*")
+
+	// Without a path or length
+	writeMessage(server, message{kind: 'U', data: []byte(" 1:Fm K7ABC To APRS <UI pid=F0 >[12:34:56]\r>direct\r")})
+	r, err = c.Next()
+	checks.Equal("short monitored", fmt.Sprintf("%v %v %v %q", err, r.Source, len(r.Path), r.Body), `<nil> K7ABC 0 ">direct"`)
+
+	// Not APRS
+	writeMessage(server, message{kind: 'U', data: []byte(" 1:Fm K7ABC To ID <UI pid=CF Len=3 >[12:34:56]\rabc\r")})
+	_, err = c.Next()
+	_, notAPRS := err.(*ax25.NotAPRSError)
+	checks.Equal("NET/ROM is not APRS", notAPRS, true)
+
+	writeMessage(server, message{kind: 'U', data: []byte("gibberish\r")})
+	_, err = c.Next()
+	checks.Equal("garbage is a frame error", ax25.IsFrameError(err), true)
+
+	// The end of the connection
+	server.Close()
+	_, err = c.Next()
+	checks.Equal("connection closed", err != nil && !ax25.IsFrameError(err), true)
+	c.Close()
+
+	checks.Done()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"strings"
+	"time"
+)
+
+func pathString(path []ax25.APRSAddress) string {
+	s := make([]string, len(path))
+	for i, a := range path {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// parsePath turns "WIDE1*,WIDE2-1" into a path
+func parsePath(s string) []ax25.APRSAddress {
+	var path []ax25.APRSAddress
+	for _, f := range strings.Split(s, ",") {
+		a := ax25.APRSAddress{Repeated: strings.HasSuffix(f, "*")}
+		f = strings.TrimSuffix(f, "*")
+		parts := strings.Split(f, "-")
+		a.Callsign = parts[0]
+		if len(parts) > 1 {
+			var ssid int
+			fmt.Sscan(parts[1], &ssid)
+			a.SSID = uint8(ssid)
+		}
+		path = append(path, a)
+	}
+	return path
+}
+
+func packet(source, path, body string) ax25.APRSPacket {
+	return ax25.APRSPacket{
+		Source: ax25.APRSAddress{Callsign: source},
+		Dest:   ax25.APRSAddress{Callsign: "APRS"},
+		Path:   parsePath(path),
+		Body:   body,
+	}
+}
+
+func main() {
+	var sent []ax25.APRSPacket
+	send := func(p ax25.APRSPacket) error {
+		sent = append(sent, p)
+		return nil
+	}
+
+	me := ax25.APRSAddress{Callsign: "BALLON", SSID: 11}
+	d := ax25.NewDigipeater(me, send)
+	d.Aliases = []ax25.APRSAddress{{Callsign: "RELAY"}}
+
+	// Path handling
+	paths := []struct{ in, out string }{
+		{"WIDE1-1,WIDE2-1", "BALLON-11*,WIDE1*,WIDE2-1"},
+		{"WIDE2-2", "BALLON-11*,WIDE2-1"},
+		{"WIDE2-1", "BALLON-11*,WIDE2*"},
+		{"N0CALL*,WIDE1*,WIDE2-1", "N0CALL*,WIDE1*,BALLON-11*,WIDE2*"},
+		{"BALLON-11", "BALLON-11*"},
+		{"BALLON-11,WIDE2-1", "BALLON-11*,WIDE2-1"},
+		{"RELAY,WIDE2-2", "BALLON-11*,WIDE2-2"},
+		{"WIDE3-3", ""},
+		{"WIDE2-3", ""},
+		{"WIDE1*,WIDE2*", ""},
+		{"N0CALL-1,WIDE2-1", ""},
+		{"TCPIP*", ""},
+		{"A*,B*,C*,D*,E*,F*,G*,WIDE2-1", "A*,B*,C*,D*,E*,F*,G*,WIDE2*"},
+	}
+	for _, t := range paths {
+		out, ok := d.Digipeat(packet("N0CALL", t.in, ">test"))
+		got := ""
+		if ok {
+			got = pathString(out.Path)
+		}
+		checks.Equal("path "+t.in, got, t.out)
+	}
+
+	_, ok := d.Digipeat(ax25.APRSPacket{Source: me, Path: parsePath("WIDE2-1"), Body: ">test"})
+	checks.Equal("own packets are not repeated", ok, false)
+
+	// Duplicate suppression: the same packet via a different path is still a duplicate
+	d.Handle(packet("N0CALL", "WIDE1-1,WIDE2-1", ">dup"))
+	d.Handle(packet("N0CALL", "K7XYZ*,WIDE1*,WIDE2-1", ">dup"))
+	d.Handle(packet("N0CALL", "WIDE1-1,WIDE2-1", ">dup"))
+	checks.Equal("duplicates repeated once", len(sent), 1)
+
+	// Rate limiting, on a fresh digipeater so the packet above doesn't count
+	sent = nil
+	d = ax25.NewDigipeater(me, send)
+	d.RateLimit = 3
+	for i := 0; i < 5; i++ {
+		d.Handle(packet("N0CALL", "WIDE2-1", fmt.Sprintf(">rate %v", i)))
+	}
+	checks.Equal("rate limit", len(sent), 3)
+
+	// Viscous delay: a packet someone else repeats first is dropped
+	sent = nil
+	d.RateLimit = 0
+	d.ViscousDelay = 200 * time.Millisecond
+	d.Handle(packet("N0CALL", "WIDE1-1", ">viscous 1"))
+	d.Handle(packet("N0CALL", "K7XYZ*,WIDE1*", ">viscous 1"))
+	d.Handle(packet("N0CALL", "WIDE1-1", ">viscous 2"))
+	checks.Equal("viscous: held", len(sent), 0)
+	time.Sleep(400 * time.Millisecond)
+	checks.Equal("viscous: repeated only when nobody else did", len(sent), 1)
+	if len(sent) == 1 {
+		checks.Equal("viscous: packet", sent[0].Body, ">viscous 2")
+	}
+
+	// The H bits survive encoding and decoding
+	p := packet("N0CALL", "N1ABC*,WIDE1*,WIDE2-1", ">h bits")
+	frame, err := ax25.EncodeAX25Command(p)
+	if err != nil {
+		checks.Equal("encode", err, nil)
+	}
+	dp, err := ax25.NewDecoder(bytes.NewReader(frame)).Next()
+	checks.Equal("decode error", err, nil)
+	checks.Equal("decoded path", pathString(dp.Path), "N1ABC*,WIDE1*,WIDE2-1")
+
+	checks.Done()
+}
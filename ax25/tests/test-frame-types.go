@@ -0,0 +1,108 @@
+package main
+
+// Builds frames of each type by hand and checks that the decoder classifies them, and
+// that only APRS frames come back from Next()
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+)
+
+var (
+	ground  = ax25.APRSAddress{Callsign: "N0CALL", SSID: 1}
+	balloon = ax25.APRSAddress{Callsign: "N0CALL", SSID: 11}
+)
+
+func roundTrip(f ax25.Frame) ax25.Frame {
+	b, err := f.Encode()
+	if err != nil {
+		checks.Equal("encode", err, nil)
+		return ax25.Frame{}
+	}
+	d, err := ax25.NewDecoder(bytes.NewReader(b)).NextFrame()
+	checks.Equal(fmt.Sprintf("decode %#02x", f.Control), err, nil)
+	return d
+}
+
+func main() {
+	f := roundTrip(ax25.Frame{Dest: balloon, Source: ground, Control: 0x3f})
+	checks.Equal("SABM type", f.Type(), "U")
+	checks.Equal("SABM kind", f.Kind(), "SABM")
+	checks.Equal("SABM poll", f.PollFinal(), true)
+
+	f = roundTrip(ax25.Frame{Dest: ground, Source: balloon, Control: 0x73})
+	checks.Equal("UA kind", f.Kind(), "UA")
+	checks.Equal("UA final", f.PollFinal(), true)
+
+	// I frame with N(S)=3, N(R)=5, P=0, carrying NET/ROM
+	f = roundTrip(ax25.Frame{Dest: balloon, Source: ground, Control: 5<<5 | 3<<1, PID: ax25.PIDNETROM, Info: []byte("hello")})
+	checks.Equal("I type", f.Type(), "I")
+	checks.Equal("I kind", f.Kind(), "I")
+	checks.Equal("I N(S)", f.NS(), 3)
+	checks.Equal("I N(R)", f.NR(), 5)
+	checks.Equal("I poll", f.PollFinal(), false)
+	checks.Equal("I PID", fmt.Sprintf("%#02x", f.PID), "0xcf")
+	checks.Equal("I info", string(f.Info), "hello")
+	checks.Equal("I is not APRS", f.IsAPRS(), false)
+
+	// RR with N(R)=2, P=1
+	f = roundTrip(ax25.Frame{Dest: balloon, Source: ground, Control: 2<<5 | 0x10 | 0x01})
+	checks.Equal("RR type", f.Type(), "S")
+	checks.Equal("RR kind", f.Kind(), "RR")
+	checks.Equal("RR N(R)", f.NR(), 2)
+	checks.Equal("RR poll", f.PollFinal(), true)
+	checks.Equal("RR has no PID", f.PID, 0)
+
+	f = roundTrip(ax25.Frame{Dest: balloon, Source: ground, Control: 0x09})
+	checks.Equal("REJ kind", f.Kind(), "REJ")
+
+	// A UI frame with some other protocol isn't APRS
+	ui := ax25.Frame{Dest: ax25.APRSAddress{Callsign: "APZ001"}, Source: balloon, Control: 0x03, PID: ax25.PIDTCPIP, Info: []byte("not aprs")}
+	f = roundTrip(ui)
+	checks.Equal("UI kind", f.Kind(), "UI")
+	_, err := f.APRSPacket()
+	_, notAPRS := err.(*ax25.NotAPRSError)
+	checks.Equal("UI/0xcc is not APRS", notAPRS, true)
+
+	// Next() returns an error saying why each frame that isn't an APRS packet was passed over
+	stream := &bytes.Buffer{}
+	for _, fr := range []ax25.Frame{
+		{Dest: balloon, Source: ground, Control: 0x3f},
+		ui,
+	} {
+		b, _ := fr.Encode()
+		stream.Write(b)
+	}
+	pkt, _ := ax25.EncodeAX25Command(ax25.APRSPacket{Source: balloon, Path: []ax25.APRSAddress{{Callsign: "WIDE2", SSID: 1}}, Body: ">hello"})
+	stream.Write(pkt)
+	// Noise: two addresses, neither marked as the last, then a stray byte
+	noise := []byte{0xc0, 0x00, 0x96, 0x9c, 0x6e, 0x82, 0x88, 0x8a, 0x60, 0x96, 0x9c, 0x6e, 0x82, 0x88, 0x8a, 0x60, 0x03, 0xc0}
+	stream.Write(noise)
+	stream.Write(pkt)
+
+	d := ax25.NewDecoder(stream)
+	var got []string
+	for {
+		p, err := d.Next()
+		if err != nil && !ax25.IsFrameError(err) {
+			break
+		}
+		switch e := err.(type) {
+		case nil:
+			got = append(got, "APRS "+p.Body)
+		case *ax25.NotAPRSError:
+			got = append(got, "not APRS "+e.Frame.Kind().String())
+		case *ax25.MalformedFrameError:
+			got = append(got, "malformed")
+		}
+	}
+	checks.Equal("stream", fmt.Sprintf("%q", got), fmt.Sprintf("%q", []string{"not APRS SABM", "not APRS UI", "APRS >hello", "malformed", "APRS >hello"}))
+
+	_, err = ax25.NewDecoder(bytes.NewReader(noise)).NextFrame()
+	_, malformed := err.(*ax25.MalformedFrameError)
+	checks.Equal("noise is malformed", malformed, true)
+
+	checks.Done()
+}
@@ -0,0 +1,137 @@
+package main
+
+// Checks KISS port numbering, TNC parameter commands and splitting a multi-port TNC's
+// stream into one decoder per port
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"io"
+	"time"
+)
+
+func packet(port int, body string) []byte {
+	b, err := ax25.EncodeAX25Command(ax25.APRSPacket{
+		Source: ax25.APRSAddress{Callsign: "N0CALL", SSID: 11},
+		Body:   body,
+		Port:   port,
+	})
+	if err != nil {
+		checks.Equal("encode", err, nil)
+	}
+	return b
+}
+
+func main() {
+	b := packet(3, ">port three")
+	checks.Equal("command byte for port 3", fmt.Sprintf("%#02x", b[1]), "0x30")
+
+	p, err := ax25.NewDecoder(bytes.NewReader(b)).Next()
+	checks.Equal("decode port 3", err, nil)
+	checks.Equal("decoded port", p.Port, 3)
+
+	_, err = ax25.EncodeAX25Command(ax25.APRSPacket{Source: ax25.APRSAddress{Callsign: "N0CALL"}, Body: ">x", Port: 16})
+	checks.Equal("port 16 refused", err != nil, true)
+
+	// Parameters, in 10 ms units
+	buf := &bytes.Buffer{}
+	txdelay, slottime, persist, duplex := 300*time.Millisecond, 100*time.Millisecond, 63, true
+	err = ax25.ConfigureTNC(buf, 1, ax25.KISSParams{
+		TXDelay:     &txdelay,
+		Persistence: &persist,
+		SlotTime:    &slottime,
+		FullDuplex:  &duplex,
+	})
+	checks.Equal("configure", err, nil)
+	checks.Equal("configure bytes", fmt.Sprintf("% x", buf.Bytes()), "c0 11 1e c0 c0 13 0a c0 c0 12 3f c0 c0 15 01 c0")
+
+	// Zero is a setting like any other
+	buf.Reset()
+	var notail time.Duration
+	persist, duplex = 0, false
+	err = ax25.ConfigureTNC(buf, 0, ax25.KISSParams{TXTail: &notail, Persistence: &persist, FullDuplex: &duplex})
+	checks.Equal("configure zeroes", err, nil)
+	checks.Equal("configure zero bytes", fmt.Sprintf("% x", buf.Bytes()), "c0 04 00 c0 c0 02 00 c0 c0 05 00 c0")
+
+	buf.Reset()
+	err = ax25.ConfigureTNC(buf, 0, ax25.KISSParams{})
+	checks.Equal("nothing to configure", fmt.Sprint(err, buf.Len()), "<nil> 0")
+
+	txdelay = 3 * time.Second
+	err = ax25.ConfigureTNC(buf, 0, ax25.KISSParams{TXDelay: &txdelay})
+	checks.Equal("TXDELAY too long", err != nil, true)
+
+	// A parameter of 0xc0 has to be escaped
+	c, _ := ax25.KISSCommand(0, ax25.KISSPersistence, 0xc0)
+	checks.Equal("escaped parameter", fmt.Sprintf("% x", c), "c0 02 db dc c0")
+
+	_, err = ax25.KISSCommand(0, ax25.KISSData)
+	checks.Equal("data is not a parameter", err != nil, true)
+
+	buf.Reset()
+	ax25.ExitKISS(buf)
+	checks.Equal("return from KISS", fmt.Sprintf("% x", buf.Bytes()), "c0 ff c0")
+
+	// A TNC with two radios, chattering about its own settings in between
+	stream := &bytes.Buffer{}
+	stream.Write(packet(0, ">zero one"))
+	stream.Write(packet(1, ">one one"))
+	stream.Write([]byte{0xc0, 0x11, 0x1e, 0xc0})
+	stream.Write(packet(2, ">nobody listens to two"))
+	stream.Write(packet(1, ">one two"))
+	stream.Write(packet(0, ">zero two"))
+
+	demux := ax25.NewKISSDemux(stream)
+	r0, _ := demux.Port(0)
+	r1, _ := demux.Port(1)
+	_, err = demux.Port(1)
+	checks.Equal("port taken twice", err != nil, true)
+
+	heard := make(chan string, 10)
+	done := make(chan bool)
+	for _, r := range []io.Reader{r0, r1} {
+		d := ax25.NewDecoder(r)
+		go func() {
+			for {
+				p, err := d.Next()
+				if err != nil {
+					done <- true
+					return
+				}
+				heard <- fmt.Sprintf("%v:%v", p.Port, p.Body)
+			}
+		}()
+	}
+
+	checks.Equal("demux ends with the stream", demux.Run() != nil, true)
+	<-done
+	<-done
+	close(heard)
+
+	per := map[int][]string{}
+	for h := range heard {
+		per[int(h[0]-'0')] = append(per[int(h[0]-'0')], h)
+	}
+	checks.Equal("port 0", per[0], "[0:>zero one 0:>zero two]")
+	checks.Equal("port 1", per[1], "[1:>one one 1:>one two]")
+
+	// A plain decoder hears every port, and skips the TNC's settings
+	stream.Reset()
+	stream.Write(packet(5, ">five"))
+	stream.Write([]byte{0xc0, 0x11, 0x1e, 0xc0})
+	stream.Write(packet(0, ">zero"))
+	d := ax25.NewDecoder(stream)
+	var all []string
+	for {
+		p, err := d.Next()
+		if err != nil {
+			break
+		}
+		all = append(all, fmt.Sprintf("%v:%v", p.Port, p.Body))
+	}
+	checks.Equal("all ports", all, "[5:>five 0:>zero]")
+
+	checks.Done()
+}
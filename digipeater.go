@@ -0,0 +1,60 @@
+// GoBalloon
+// digipeater.go - High-altitude digipeater: repeats packets heard by the TNC while we're aloft
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"log"
+	"strings"
+	"time"
+)
+
+// newDigipeater sets up the balloon's digipeater from the -digi* flags.  It repeats as
+// the balloon's callsign.
+func (a *APRSTNC) newDigipeater() *ax25.Digipeater {
+	d := ax25.NewDigipeater(balloonAddr, a.writePacket)
+	d.MaxHops = uint8(*digimaxhops)
+	d.ViscousDelay = time.Duration(*digidelay * float64(time.Second))
+	d.RateLimit = *digirate
+	d.Debug = *debug
+
+	if len(*digialiases) > 0 {
+		for _, alias := range strings.Split(*digialiases, ",") {
+			d.Aliases = append(d.Aliases, aprsis.AddressFromString(strings.TrimSpace(alias)))
+		}
+	}
+
+	return d
+}
+
+// digipeat repeats a packet we've heard, but only once we're high enough to be useful.
+// On the ground, a balloon's digipeater would just add to the traffic around the launch
+// site.
+func (a *APRSTNC) digipeat(p ax25.APRSPacket) {
+	if a.heightAboveGround() < *digialt {
+		return
+	}
+
+	err := a.digi.Handle(p)
+	if err != nil {
+		log.Printf("Error digipeating packet: %v\n", err)
+	}
+}
+
+// heightAboveGround is how high we are above the launch site, whose altitude the landing
+// predictor records before launch.  A launch site a mile up would otherwise have us
+// digipeating on the pad.  If we were started in mid-flight and never saw the ground,
+// it's our height above sea level, which is at least as high.
+func (a *APRSTNC) heightAboveGround() float64 {
+	alt := a.gps.Get().Altitude
+	if a.landing != nil {
+		if ground, ok := a.landing.Ground(); ok {
+			return alt - ground
+		}
+	}
+	return alt
+}
@@ -6,6 +6,8 @@
 package main
 
 import (
+	"github.com/chrissnell/GoBalloon/flight"
+	"github.com/chrissnell/GoBalloon/geospatial"
 	"github.com/chrissnell/GoBalloon/gps"
 	"github.com/mrmorphic/hwio"
 	"log"
@@ -13,13 +15,16 @@ import (
 	"time"
 )
 
-func FlightComputer(g *gps.GPSReading, wg *sync.WaitGroup) {
+// FlightComputer follows the flight until shutdown, learning the wind into wind and
+// predicting the landing with predictor.  The caller adds it to wg.
+func FlightComputer(g *gps.GPSReading, wind *geospatial.WindProfile, predictor *flight.LandingPredictor, wg *sync.WaitGroup) {
 
-	var maxalt float64
 	var once sync.Once
 	var timer *time.Timer
+	var detector flight.PhaseDetector
+
+	windReport := newWindReporter(wind, *windlog, *windreport)
 
-	wg.Add(1)
 	defer wg.Done()
 
 	for {
@@ -30,16 +35,37 @@ func FlightComputer(g *gps.GPSReading, wg *sync.WaitGroup) {
 		default:
 			pos := g.Get()
 			if pos.Lat != 0 && pos.Lon != 0 {
-				if pos.Altitude > maxalt {
-					maxalt = pos.Altitude
-				}
+				phase, changed := detector.Update(pos)
 
 				if *debug {
-					log.Printf("MAX ALT: %v\n", maxalt)
+					log.Printf("PHASE: %v  ALT: %.0f  CLIMB: %+.0f ft/min  MAX ALT: %.0f\n", phase, pos.Altitude, pos.Climb, detector.MaxAltitude())
+				}
+
+				if changed {
+					log.Printf("Flight phase is now %v at %.0f ft (climb %+.0f ft/min, max alt %.0f ft)\n", phase, pos.Altitude, pos.Climb, detector.MaxAltitude())
+				}
+
+				// We learn the wind from our drift while we're free-flying on the way up
+				if phase == flight.PhaseAscent || phase == flight.PhaseFloat {
+					wind.Add(pos)
+				}
+
+				if phase == flight.PhaseAscent {
+					windReport.Update(pos)
+				} else if changed && (phase == flight.PhaseFloat || phase == flight.PhaseDescent) {
+					// Written again at burst after a float, with what we learned while floating
+					windReport.Finish()
+				}
+
+				if phase == flight.PhaseDescent || phase == flight.PhaseLanded {
+					once.Do(func() {
+						wg.Add(1)
+						go SoundBuzzer(wg)
+					})
 				}
 
-				if maxalt > 17000 && pos.Altitude < 15000 {
-					once.Do(func() { SoundBuzzer(wg) })
+				if landing, ok := predictor.Update(pos, phase); ok {
+					sendLandingPrediction(landing)
 				}
 
 			}
@@ -82,13 +108,13 @@ func InitiateCutdown() {
 
 }
 
+// SoundBuzzer beeps the recovery buzzer until shutdown.  The caller adds it to wg.
 func SoundBuzzer(wg *sync.WaitGroup) {
 
 	var timer, timer2 *time.Timer
 	var pin string = "gpio2_2"
 	toggle := make(chan bool)
 
-	wg.Add(1)
 	defer wg.Done()
 
 	log.Println("Activating buzzer")
@@ -0,0 +1,218 @@
+// GoBalloon
+// ground.go - Chase vehicle / ground station mode: tracks the balloon from its beacons
+//
+// (c) 2014, Christopher Snell
+
+package flight
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"github.com/chrissnell/GoBalloon/gps"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The balloon beacons about once a minute, so we need a much longer window than the
+// payload uses on its own GPS to get enough fixes for a vertical rate
+const groundClimbWindow = 5 * time.Minute
+
+// The same packet is often heard several times: direct, through digipeaters and via
+// APRS-IS.  Repeats within this window are ignored.
+const groundDupWindow = 30 * time.Second
+
+// With a beacon a minute rather than a fix every five seconds, a phase change is
+// confirmed by a few beacons rather than the payload's dozen fixes, or we'd be announcing
+// the burst several minutes late and the landing not at all if the balloon falls silent
+var groundConfirmations = map[Phase]int{
+	PhaseAscent:  2,
+	PhaseFloat:   3,
+	PhaseDescent: 2,
+	PhaseLanded:  3,
+}
+
+// The payload puts its own filtered vertical rate in its position comments
+var climbCommentRE = regexp.MustCompile(`Climb:([+-]?\d+)ft/min`)
+
+// GroundStation follows the balloon from the ground.  It runs the same phase detection,
+// wind learning and landing prediction as the flight computer, but on the positions
+// the balloon beacons, and works out where to point from the chase vehicle's own GPS.
+type GroundStation struct {
+	balloon   ax25.APRSAddress
+	chase     *gps.GPSReading
+	climb     gps.ClimbEstimator
+	detector  PhaseDetector
+	wind      *geospatial.WindProfile
+	predictor *LandingPredictor
+	lastBody  string
+	lastHeard time.Time
+	mutex     sync.Mutex
+}
+
+// NewGroundStation gets a ground station that follows balloon, and points at it from
+// chase, the chase vehicle's GPS, if that's not nil
+func NewGroundStation(balloon ax25.APRSAddress, chase *gps.GPSReading, chute geospatial.Parachute) *GroundStation {
+	wind := geospatial.NewWindProfile(geospatial.DefaultWindBand)
+
+	return &GroundStation{
+		balloon:   balloon,
+		chase:     chase,
+		climb:     gps.ClimbEstimator{Window: groundClimbWindow},
+		detector:  PhaseDetector{Confirmations: groundConfirmations},
+		wind:      wind,
+		predictor: NewLandingPredictor(wind, chute),
+	}
+}
+
+// Phase returns the phase of the flight as far as we can tell from the ground
+func (gs *GroundStation) Phase() Phase {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	return gs.detector.Phase()
+}
+
+// Handle examines a packet heard on RF or APRS-IS and tracks it if it's from the balloon
+func (gs *GroundStation) Handle(p ax25.APRSPacket) {
+	gs.HandleAt(p, time.Now())
+}
+
+// HandleAt handles a packet heard at a given time
+func (gs *GroundStation) HandleAt(p ax25.APRSPacket, heard time.Time) {
+	if !strings.EqualFold(p.Source.Callsign, gs.balloon.Callsign) || p.Source.SSID != gs.balloon.SSID {
+		return
+	}
+
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	body := p.OriginalBody
+	if len(body) == 0 {
+		body = p.Body
+	}
+	if body == gs.lastBody && heard.Sub(gs.lastHeard) < groundDupWindow {
+		return
+	}
+	gs.lastBody = body
+	gs.lastHeard = heard
+
+	ad := aprs.ParsePacket(&p)
+
+	if ad.StandardTelemetry.Sequence != 0 || ad.StandardTelemetry.A1 != 0 {
+		log.Printf("BALLOON TELEMETRY: %+v\n", ad.StandardTelemetry)
+	}
+	if ad.CompressedTelemetry.Sequence != 0 || ad.CompressedTelemetry.A1 != 0 {
+		log.Printf("BALLOON TELEMETRY: %+v\n", ad.CompressedTelemetry)
+	}
+
+	if len(ad.Object.Name) > 0 && ad.Object.Live {
+		log.Printf("Balloon predicts landing at %.5f,%.5f (%v)%v\n", ad.Object.Position.Lat, ad.Object.Position.Lon,
+			ad.Object.Comment, gs.fromChase(ad.Object.Position))
+		return
+	}
+
+	if ad.Position.Lat == 0 && ad.Position.Lon == 0 {
+		if len(ad.Message.Text) > 0 {
+			log.Printf("BALLOON MESSAGE to %v: %v\n", ad.Message.Recipient, ad.Message.Text)
+		}
+		return
+	}
+
+	// Go by when we heard it.  Few beacons carry a timestamp, and one that arrives late
+	// through APRS-IS would throw off the vertical rate.
+	ad.Position.Time = heard
+
+	gs.update(ad.Position, ad.Comment)
+}
+
+// update tracks a new balloon position.  Called with gs.mutex held.
+func (gs *GroundStation) update(pos geospatial.Point, comment string) {
+	// Prefer the payload's own vertical rate, worked out from every GPS fix, to what we
+	// can estimate from one beacon a minute
+	rate, ok := gs.climb.Add(pos.Time, pos.Altitude)
+	if m := climbCommentRE.FindStringSubmatch(comment); m != nil {
+		rate, _ = strconv.ParseFloat(m[1], 64)
+		ok = true
+	}
+	if ok {
+		pos.Climb = rate
+	}
+
+	before := gs.detector.Phase()
+	phase, changed := gs.detector.Update(pos)
+
+	log.Printf("BALLOON: %.5f,%.5f %.0f ft %+.0f ft/min %v%v\n", pos.Lat, pos.Lon, pos.Altitude, pos.Climb, phase, gs.fromChase(pos))
+
+	if changed && before == PhasePrelaunch && phase != PhaseAscent {
+		// We've been started, or first heard the balloon, in mid-flight, so we didn't see
+		// it burst or start to float
+		groundAlert("Picked up the balloon in %v at %.0f ft, %+.0f ft/min", phase, pos.Altitude, pos.Climb)
+	} else if changed {
+		switch phase {
+		case PhaseAscent:
+			groundAlert("Balloon has launched and is climbing at %+.0f ft/min", pos.Climb)
+		case PhaseFloat:
+			groundAlert("Balloon is floating at %.0f ft", pos.Altitude)
+		case PhaseDescent:
+			groundAlert("BURST!  Balloon is descending at %+.0f ft/min from a maximum altitude of %.0f ft", pos.Climb, gs.detector.MaxAltitude())
+		case PhaseLanded:
+			groundAlert("LANDED at %.5f,%.5f, %.0f ft%v", pos.Lat, pos.Lon, pos.Altitude, gs.fromChase(pos))
+		}
+	}
+
+	if phase == PhaseAscent || phase == PhaseFloat {
+		gs.wind.Add(pos)
+	}
+
+	if landing, ok := gs.predictor.Update(pos, phase); ok {
+		if d := gs.fromChase(landing); len(d) > 0 {
+			log.Printf("Predicted landing%v\n", d)
+		}
+	}
+
+}
+
+// fromChase describes where a point is from the chase vehicle, if we have a fix
+func (gs *GroundStation) fromChase(target geospatial.Point) string {
+	if gs.chase == nil {
+		return ""
+	}
+
+	chase := gs.chase.Get()
+	if chase.Lat == 0 && chase.Lon == 0 {
+		return ""
+	}
+
+	az, el, _ := chase.LookAnglesTo(target)
+	d := chase.DistanceTo(target, geospatial.Miles)
+
+	return fmt.Sprintf(" | %.1f mi at %.0f°, elevation %.1f°", d, az, el)
+}
+
+func groundAlert(format string, args ...interface{}) {
+	log.Printf("*** ALERT: "+format+" ***\n", args...)
+}
+
+// FollowOnAPRSIS tracks the balloon through APRS-IS, for when it's out of radio range
+func (gs *GroundStation) FollowOnAPRSIS(servers, call string) {
+	log.Println("GroundStation.FollowOnAPRSIS()")
+
+	c := aprsis.NewClient(call, "", strings.Split(servers, ",")...)
+	c.SetFilter(aprsis.Filters{aprsis.BudlistFilter{Calls: []string{gs.balloon.String()}}})
+	go c.Run()
+
+	for {
+		select {
+		case p := <-c.Packets:
+			gs.Handle(p)
+		case ev := <-c.Events:
+			log.Printf("APRS-IS connection is %v (%v)\n", ev.State, ev.Server)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// GoBalloon
+// landing.go - Landing point prediction from the wind seen on the way up
+//
+// (c) 2014, Christopher Snell
+
+package flight
+
+import (
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// How often we make a fresh landing prediction while descending
+const predictionInterval = 30 * time.Second
+
+// LandingPredictor learns the wind on the way up and uses it to predict where the
+// payload will come down once it's falling
+type LandingPredictor struct {
+	wind        *geospatial.WindProfile
+	ground      float64 // Altitude of the launch site in feet
+	grounded    bool    // False if we were started in mid-flight and never saw the ground
+	descentRate float64 // Sea-level descent rate in ft/min, used until we've seen our own
+	lastBeacon  time.Time
+	mutex       sync.Mutex
+}
+
+// NewLandingPredictor gets a predictor that expects to fall at chute's terminal velocity
+// until it's seen its own descent rate
+func NewLandingPredictor(wind *geospatial.WindProfile, chute geospatial.Parachute) *LandingPredictor {
+	return &LandingPredictor{
+		wind:        wind,
+		descentRate: chute.SeaLevelDescentRate(),
+	}
+}
+
+// Update feeds the predictor a new fix.  During descent, it returns a landing prediction
+// whenever one is due to be beaconed.
+func (l *LandingPredictor) Update(pos geospatial.Point, phase Phase) (geospatial.Point, bool) {
+	switch phase {
+	case PhasePrelaunch:
+		// Wherever we're sitting before launch is our best guess at the altitude of the
+		// ground.  If we've been started in mid-flight, we aren't sitting anywhere.
+		if math.Abs(pos.Climb) < floatRate && pos.Altitude < floatAltitude {
+			l.mutex.Lock()
+			l.ground = pos.Altitude
+			l.grounded = true
+			l.mutex.Unlock()
+		}
+		return pos, false
+
+	case PhaseAscent, PhaseFloat:
+		// The flight computer keeps the wind profile up to date for us
+		return pos, false
+
+	case PhaseDescent:
+		if time.Since(l.lastBeacon) < predictionInterval {
+			return pos, false
+		}
+
+		// Once we're falling steadily, our own descent rate is a better guide than any model
+		rate := l.descentRate
+		if pos.Climb < descentRate {
+			rate = geospatial.SeaLevelDescentRateFrom(pos.Climb, pos.Altitude)
+		}
+
+		ground, _ := l.Ground()
+		landing := geospatial.PredictLanding(pos, l.wind, rate, ground)
+		l.lastBeacon = time.Now()
+
+		log.Printf("Predicted landing: %.5f,%.5f at %v (%.1f mi, bearing %v)\n", landing.Lat, landing.Lon,
+			landing.Time.Format("15:04:05"), pos.GreatCircleDistanceTo(landing), pos.BearingTo(landing))
+
+		return landing, true
+	}
+
+	return pos, false
+}
+
+// Ground returns the altitude of the ground we sat on before launch, and false if we
+// were started in mid-flight and never saw it
+func (l *LandingPredictor) Ground() (float64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.ground, l.grounded
+}
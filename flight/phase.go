@@ -0,0 +1,143 @@
+// GoBalloon
+// phase.go - Works out what the balloon is doing from its vertical rate
+//
+// (c) 2014, Christopher Snell
+
+// Package flight follows a balloon's flight: its phase, the landing prediction and, on
+// the ground, tracking the balloon from its beacons.
+package flight
+
+import (
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"math"
+)
+
+// Phase is a stage of the flight
+type Phase int
+
+const (
+	PhasePrelaunch Phase = iota
+	PhaseAscent
+	PhaseFloat
+	PhaseDescent
+	PhaseLanded
+)
+
+func (f Phase) String() string {
+	switch f {
+	case PhasePrelaunch:
+		return "prelaunch"
+	case PhaseAscent:
+		return "ascent"
+	case PhaseFloat:
+		return "float"
+	case PhaseDescent:
+		return "descent"
+	case PhaseLanded:
+		return "landed"
+	}
+	return "unknown"
+}
+
+// Vertical rate thresholds for flight phase changes, in feet per minute.  A latex balloon
+// climbs at roughly 1,000 ft/min and falls under parachute at 1,000+ ft/min, so these
+// leave plenty of margin for GPS noise and turbulence.
+const (
+	ascentRate  = 300
+	descentRate = -600
+	floatRate   = 100
+	landedRate  = 50
+
+	// Below this altitude, a balloon that stops climbing is probably still in someone's hands
+	floatAltitude = 15000
+)
+
+// PhaseDetector works out what the balloon is doing from its filtered vertical rate.
+// A change of phase is only declared once the new condition has held for several
+// consecutive fixes.
+type PhaseDetector struct {
+	Confirmations map[Phase]int // How many fixes each phase must hold for.  DefaultConfirmations if nil.
+
+	phase   Phase
+	maxalt  float64
+	pending Phase
+	count   int
+}
+
+// DefaultConfirmations is how many consecutive fixes a condition must hold before we
+// believe it, with a fix every five seconds from the payload's own GPS
+var DefaultConfirmations = map[Phase]int{
+	PhaseAscent:  3,
+	PhaseFloat:   12,
+	PhaseDescent: 3,
+	PhaseLanded:  12,
+}
+
+// Phase returns the current phase
+func (d *PhaseDetector) Phase() Phase {
+	return d.phase
+}
+
+// MaxAltitude returns the highest altitude seen so far
+func (d *PhaseDetector) MaxAltitude() float64 {
+	return d.maxalt
+}
+
+// Update examines a new position and returns the current phase and whether it just changed
+func (d *PhaseDetector) Update(pos geospatial.Point) (Phase, bool) {
+	if pos.Altitude > d.maxalt {
+		d.maxalt = pos.Altitude
+	}
+
+	next := d.phase
+
+	switch d.phase {
+	case PhasePrelaunch:
+		// We may have been started, or restarted, in mid-flight, in which case we pick up
+		// the flight wherever it is
+		if pos.Climb > ascentRate {
+			next = PhaseAscent
+		} else if pos.Climb < descentRate {
+			next = PhaseDescent
+		} else if math.Abs(pos.Climb) < floatRate && pos.Altitude > floatAltitude {
+			next = PhaseFloat
+		}
+	case PhaseAscent:
+		if pos.Climb < descentRate {
+			next = PhaseDescent
+		} else if math.Abs(pos.Climb) < floatRate && pos.Altitude > floatAltitude {
+			next = PhaseFloat
+		}
+	case PhaseFloat:
+		if pos.Climb < descentRate {
+			next = PhaseDescent
+		}
+	case PhaseDescent:
+		if math.Abs(pos.Climb) < landedRate {
+			next = PhaseLanded
+		}
+	}
+
+	if next == d.phase {
+		d.count = 0
+		return d.phase, false
+	}
+
+	if next != d.pending {
+		d.pending = next
+		d.count = 0
+	}
+	d.count++
+
+	confirmations := d.Confirmations
+	if confirmations == nil {
+		confirmations = DefaultConfirmations
+	}
+	if d.count < confirmations[next] {
+		return d.phase, false
+	}
+
+	d.phase = next
+	d.count = 0
+	return d.phase, true
+}
@@ -0,0 +1,105 @@
+// GoBalloon
+// ground-test.go - Follows a simulated flight from the ground, one beacon a minute, and
+//                  checks that launch, burst and landing are called in good time
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/flight"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"time"
+)
+
+var balloon = ax25.APRSAddress{Callsign: "NW5W", SSID: 11}
+
+var chute = geospatial.Parachute{Mass: 1.8, Diameter: 1.2, Drag: 1.5}
+
+// chase hears the balloon beacon once a minute
+type chase struct {
+	gs      *flight.GroundStation
+	pos     geospatial.Point
+	heard   time.Time
+	comment bool // Whether the balloon puts its own vertical rate in its beacons
+}
+
+func newChase(alt float64, comment bool) *chase {
+	return &chase{
+		gs:      flight.NewGroundStation(balloon, nil, chute),
+		pos:     geospatial.Point{Lat: 47.2101, Lon: -122.4818, Altitude: alt},
+		heard:   time.Date(2014, 6, 14, 15, 0, 0, 0, time.UTC),
+		comment: comment,
+	}
+}
+
+// beacon is what the balloon sends from where it is, as APRSTNC does
+func (c *chase) beacon() ax25.APRSPacket {
+	body := aprs.CreateCompressedPositionReport(c.pos, '/', 'O')
+	if c.comment {
+		body += fmt.Sprintf("Climb:%+.0fft/min", c.pos.Climb)
+	}
+	return ax25.APRSPacket{Source: balloon, Dest: ax25.APRSAddress{Callsign: "APZ001"}, Body: body}
+}
+
+// fly beacons once a minute for a number of minutes at a steady vertical rate, drifting
+// east, and returns how many beacons it took the ground station to call want, or 0 if
+// it never did
+func (c *chase) fly(climb float64, minutes int, want flight.Phase) int {
+	called := 0
+	for i := 1; i <= minutes; i++ {
+		c.heard = c.heard.Add(time.Minute)
+		c.pos.Altitude += climb
+		c.pos.Lon += 0.005
+		c.pos.Climb = climb
+
+		c.gs.HandleAt(c.beacon(), c.heard)
+		if called == 0 && c.gs.Phase() == want {
+			called = i
+		}
+	}
+	return called
+}
+
+// within checks that a phase was called, and no later than the given beacon
+func within(what string, called, beacons int) {
+	checks.True(called > 0 && called <= beacons, "%v called on beacon %v (at most %v)", what, called, beacons)
+}
+
+func main() {
+	// Straight up at 1,000 ft/min to burst at 80,300 ft, then down at 2,500 ft/min
+	c := newChase(300, true)
+	c.fly(0, 5, flight.PhaseAscent)
+	checks.Equal("on the pad", c.gs.Phase(), flight.PhasePrelaunch)
+	within("launch", c.fly(1000, 80, flight.PhaseAscent), 2)
+	within("burst", c.fly(-2500, 32, flight.PhaseDescent), 2)
+	within("landing", c.fly(0, 10, flight.PhaseLanded), 3)
+
+	// A payload that doesn't report its vertical rate leaves us to work it out from the
+	// beacons, which takes a few more.  Landing waits for the descent to pass out of the
+	// five minutes of beacons we fit the rate to.
+	c = newChase(300, false)
+	c.fly(0, 5, flight.PhaseAscent)
+	checks.Equal("on the pad without rates", c.gs.Phase(), flight.PhasePrelaunch)
+	within("launch without rates", c.fly(1000, 80, flight.PhaseAscent), 4)
+	within("burst without rates", c.fly(-2500, 32, flight.PhaseDescent), 4)
+	within("landing without rates", c.fly(0, 10, flight.PhaseLanded), 8)
+
+	// Floating at altitude for a while before it bursts
+	c = newChase(300, true)
+	c.fly(0, 5, flight.PhaseAscent)
+	c.fly(1000, 60, flight.PhaseAscent)
+	within("float", c.fly(0, 20, flight.PhaseFloat), 3)
+	within("burst after float", c.fly(-2500, 24, flight.PhaseDescent), 2)
+
+	// First heard on the way down, e.g. after the chase car's restarted
+	c = newChase(40000, true)
+	within("picked up descending", c.fly(-2500, 15, flight.PhaseDescent), 2)
+	within("landing after pickup", c.fly(0, 10, flight.PhaseLanded), 3)
+
+	checks.Done()
+}
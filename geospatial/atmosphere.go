@@ -0,0 +1,74 @@
+// GoBalloon
+// atmosphere.go - A simple model of the standard atmosphere
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"math"
+)
+
+// Sea-level values for the International Standard Atmosphere
+const (
+	SeaLevelPressure    = 101325.0 // Pascals
+	SeaLevelTemperature = 288.15   // Kelvin
+	SeaLevelDensity     = 1.225    // kg/m³
+)
+
+const (
+	gasConstant = 287.053 // Specific gas constant for dry air, J/(kg·K)
+	gravity     = 9.80665 // m/s²
+)
+
+// An atmospheric layer: base altitude (m), base temperature (K), base pressure (Pa)
+// and temperature lapse rate (K/m)
+type atmosphereLayer struct {
+	base     float64
+	temp     float64
+	pressure float64
+	lapse    float64
+}
+
+// The first four layers of the ISA, which is as high as anyone's latex balloon is going to get
+var isaLayers = []atmosphereLayer{
+	{0, 288.15, 101325, -0.0065},
+	{11000, 216.65, 22632.1, 0},
+	{20000, 216.65, 5474.89, 0.001},
+	{32000, 228.65, 868.019, 0.0028},
+}
+
+// Atmosphere returns the standard temperature (K), pressure (Pa) and density (kg/m³)
+// at the given altitude in meters
+func Atmosphere(alt float64) (temp, pressure, density float64) {
+	if alt < 0 {
+		alt = 0
+	}
+
+	l := isaLayers[0]
+	for _, v := range isaLayers {
+		if alt >= v.base {
+			l = v
+		}
+	}
+
+	h := alt - l.base
+
+	if l.lapse == 0 {
+		temp = l.temp
+		pressure = l.pressure * math.Exp(-gravity*h/(gasConstant*l.temp))
+	} else {
+		temp = l.temp + l.lapse*h
+		pressure = l.pressure * math.Pow(temp/l.temp, -gravity/(l.lapse*gasConstant))
+	}
+
+	density = pressure / (gasConstant * temp)
+
+	return temp, pressure, density
+}
+
+// AirDensity returns the standard air density (kg/m³) at the given altitude in meters
+func AirDensity(alt float64) float64 {
+	_, _, d := Atmosphere(alt)
+	return d
+}
@@ -0,0 +1,208 @@
+// GoBalloon
+// coordinates.go - Formatting and parsing of APRS degrees/decimal-minutes coordinates
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxAmbiguity is the largest APRS position ambiguity: the last four digits of the
+// position are blanked, leaving it accurate to one degree
+const MaxAmbiguity = 4
+
+// ambiguityMinutes is the size of the ambiguity box, in minutes, for each level of
+// ambiguity.  A position is reported at the center of its box.
+var ambiguityMinutes = [MaxAmbiguity + 1]float64{0, 0.1, 1, 10, 60}
+
+// ambiguityDigits are the offsets in DDMM.mm (less the degree digits that
+// longitude carries in front) that are blanked at each level of ambiguity
+var ambiguityDigits = [MaxAmbiguity + 1][]int{nil, {6}, {5, 6}, {3, 5, 6}, {2, 3, 5, 6}}
+
+// hundredthsOfMinutes rounds a coordinate's magnitude to the nearest hundredth of a
+// minute, the resolution of an APRS position.  Rounding the whole coordinate rather
+// than the minutes on their own lets 59.999' carry into the degrees instead of
+// printing as 60.00'.
+func hundredthsOfMinutes(d float64) int64 {
+	return int64(math.Floor(math.Abs(d)*6000 + 0.5))
+}
+
+// formatDDM formats the magnitude of d as DDMM.mm with degWidth degree digits
+func formatDDM(d float64, degWidth int) string {
+	h := hundredthsOfMinutes(d)
+	return fmt.Sprintf("%0*d%02d.%02d", degWidth, h/6000, h%6000/100, h%100)
+}
+
+// LatDecimalDegreesToDegreesDecimalMinutes formats the magnitude of a latitude in the
+// APRS DDMM.mm format, without a hemisphere
+func LatDecimalDegreesToDegreesDecimalMinutes(d float64) string {
+	return formatDDM(d, 2)
+}
+
+// LonDecimalDegreesToDegreesDecimalMinutes formats the magnitude of a longitude in
+// the APRS DDDMM.mm format, without a hemisphere
+func LonDecimalDegreesToDegreesDecimalMinutes(d float64) string {
+	return formatDDM(d, 3)
+}
+
+// blank replaces the digits hidden by the given level of ambiguity with spaces.  offset
+// is the number of extra degree digits in front of DDMM.mm.
+func blank(ddm string, ambiguity, offset int) string {
+	b := []byte(ddm)
+	for _, i := range ambiguityDigits[ambiguity] {
+		b[i+offset] = ' '
+	}
+	return string(b)
+}
+
+// FormatAPRSLatitude formats lat as an APRS latitude with hemisphere, e.g. "4903.50N".
+// ambiguity (0-4) blanks that many trailing digits, e.g. "4903.  N" at ambiguity 2.
+// Positions that round to the equator are reported as north.
+func FormatAPRSLatitude(lat float64, ambiguity int) (string, error) {
+	if math.IsNaN(lat) || math.Abs(lat) > 90 {
+		return "", fmt.Errorf("Latitude is > +/- 90 degrees: %v", lat)
+	}
+	if ambiguity < 0 || ambiguity > MaxAmbiguity {
+		return "", fmt.Errorf("Invalid position ambiguity: %v", ambiguity)
+	}
+
+	hem := 'N'
+	if lat < 0 && hundredthsOfMinutes(lat) != 0 {
+		hem = 'S'
+	}
+
+	return blank(LatDecimalDegreesToDegreesDecimalMinutes(lat), ambiguity, 0) + string(hem), nil
+}
+
+// FormatAPRSLongitude formats lon as an APRS longitude with hemisphere, e.g.
+// "07201.75W".  ambiguity works as it does for FormatAPRSLatitude.  Positions that round
+// to the prime meridian are reported as east, and 180° is always reported as east.
+func FormatAPRSLongitude(lon float64, ambiguity int) (string, error) {
+	if math.IsNaN(lon) || math.Abs(lon) > 180 {
+		return "", fmt.Errorf("Longitude is > +/- 180 degrees: %v", lon)
+	}
+	if ambiguity < 0 || ambiguity > MaxAmbiguity {
+		return "", fmt.Errorf("Invalid position ambiguity: %v", ambiguity)
+	}
+
+	hem := 'E'
+	if h := hundredthsOfMinutes(lon); lon < 0 && h != 0 && h != 180*6000 {
+		hem = 'W'
+	}
+
+	return blank(LonDecimalDegreesToDegreesDecimalMinutes(lon), ambiguity, 1) + string(hem), nil
+}
+
+// parseDDM parses a DDMM.mm coordinate with degWidth degree digits followed by a
+// hemisphere letter.  Digits blanked for ambiguity are taken as the center of the
+// ambiguity box.  It returns the coordinate's magnitude, the hemisphere letter and the
+// ambiguity found.  Coordinates larger than maxDeg are rejected.
+func parseDDM(s string, degWidth int, hemispheres string, maxDeg float64) (float64, byte, int, error) {
+	if len(s) != degWidth+6 {
+		return 0, 0, 0, fmt.Errorf("Wrong length for a coordinate: %q", s)
+	}
+
+	hem := s[len(s)-1]
+	if hem >= 'a' && hem <= 'z' {
+		hem -= 'a' - 'A'
+	}
+	if strings.IndexByte(hemispheres, hem) < 0 {
+		return 0, 0, 0, fmt.Errorf("Invalid hemisphere in coordinate: %q", s)
+	}
+
+	ddm := s[:len(s)-1]
+	if ddm[degWidth+2] != '.' {
+		return 0, 0, 0, fmt.Errorf("Missing decimal point in coordinate: %q", s)
+	}
+
+	// Work out the ambiguity from which digits are blank.  Only whole trailing runs of
+	// digits may be blanked.
+	offset := degWidth - 2
+	ambiguity := -1
+	for a := 0; a <= MaxAmbiguity; a++ {
+		if ddm == blank(strings.Map(spaceToZero, ddm), a, offset) {
+			ambiguity = a
+			break
+		}
+	}
+	if ambiguity < 0 {
+		return 0, 0, 0, fmt.Errorf("Invalid position ambiguity in coordinate: %q", s)
+	}
+
+	digits := strings.Map(spaceToZero, ddm)
+
+	deg, err := strconv.ParseUint(digits[:degWidth], 10, 16)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("Invalid degrees in coordinate: %q", s)
+	}
+	min, err := strconv.ParseFloat(digits[degWidth:], 64)
+	if err != nil || digits[degWidth] < '0' || digits[degWidth] > '9' {
+		return 0, 0, 0, fmt.Errorf("Invalid minutes in coordinate: %q", s)
+	}
+	if min >= 60 {
+		return 0, 0, 0, fmt.Errorf("Minutes out of range in coordinate: %q", s)
+	}
+
+	d := float64(deg) + min/60
+	if d > maxDeg {
+		return 0, 0, 0, fmt.Errorf("Coordinate is > +/- %v degrees: %q", maxDeg, s)
+	}
+
+	// The center of the box around a pole or the antimeridian is over the edge
+	d = math.Min(maxDeg, d+ambiguityMinutes[ambiguity]/120)
+
+	return d, hem, ambiguity, nil
+}
+
+func spaceToZero(r rune) rune {
+	if r == ' ' {
+		return '0'
+	}
+	return r
+}
+
+// ParseAPRSLatitude parses an APRS latitude such as "4903.50N" or the ambiguous
+// "4903.  N", returning signed decimal degrees and the position ambiguity.  Ambiguous
+// latitudes are placed at the center of the ambiguity box.
+func ParseAPRSLatitude(s string) (float64, int, error) {
+	lat, hem, ambiguity, err := parseDDM(s, 2, "NS", 90)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if hem == 'S' {
+		lat = -lat
+	}
+
+	return lat, ambiguity, nil
+}
+
+// ParseAPRSLongitude parses an APRS longitude such as "07201.75W", returning signed
+// decimal degrees.  APRS takes a position's ambiguity from its latitude, so the
+// latitude's ambiguity must be passed in; digits it covers are ignored and the
+// longitude is placed at the center of the ambiguity box.
+func ParseAPRSLongitude(s string, ambiguity int) (float64, error) {
+	if ambiguity < 0 || ambiguity > MaxAmbiguity {
+		return 0, fmt.Errorf("Invalid position ambiguity: %v", ambiguity)
+	}
+
+	if len(s) == 9 {
+		s = blank(s[:8], ambiguity, 1) + s[8:]
+	}
+
+	lon, hem, _, err := parseDDM(s, 3, "EW", 180)
+	if err != nil {
+		return 0, err
+	}
+
+	if hem == 'W' {
+		lon = -lon
+	}
+
+	return lon, nil
+}
@@ -0,0 +1,142 @@
+// GoBalloon
+// export.go - Writers for exporting a track of Points as KML, GPX and GeoJSON
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WriteKML writes track as a KML document for Google Earth.  The flight path is drawn at
+// its real altitude and extruded down to the ground so the shape of the flight is easy to
+// see.  The launch and the last fix are marked with placemarks.
+func WriteKML(w io.Writer, name string, track []Point) error {
+	if len(track) == 0 {
+		return fmt.Errorf("Cannot export an empty track")
+	}
+
+	fmt.Fprintf(w, "%s<kml xmlns=\"http://www.opengis.net/kml/2.2\">\n<Document>\n", xml.Header)
+	fmt.Fprintf(w, "<name>%s</name>\n", xmlEscape(name))
+	fmt.Fprint(w, "<Style id=\"track\"><LineStyle><color>ff0000ff</color><width>3</width></LineStyle>"+
+		"<PolyStyle><color>4c0000ff</color></PolyStyle></Style>\n")
+
+	writeKMLPlacemark(w, "Launch", track[0])
+	writeKMLPlacemark(w, "Last fix", track[len(track)-1])
+
+	fmt.Fprintf(w, "<Placemark>\n<name>%s</name>\n<styleUrl>#track</styleUrl>\n", xmlEscape(name))
+	fmt.Fprint(w, "<LineString>\n<extrude>1</extrude>\n<tessellate>1</tessellate>\n<altitudeMode>absolute</altitudeMode>\n<coordinates>\n")
+	for _, p := range track {
+		fmt.Fprintf(w, "%.6f,%.6f,%.1f\n", p.Lon, p.Lat, p.Altitude*feetToMeters)
+	}
+	_, err := fmt.Fprint(w, "</coordinates>\n</LineString>\n</Placemark>\n</Document>\n</kml>\n")
+
+	return err
+}
+
+func writeKMLPlacemark(w io.Writer, name string, p Point) {
+	fmt.Fprintf(w, "<Placemark>\n<name>%s</name>\n", xmlEscape(name))
+	if !p.Time.IsZero() {
+		fmt.Fprintf(w, "<TimeStamp><when>%s</when></TimeStamp>\n", p.Time.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "<Point><altitudeMode>absolute</altitudeMode><coordinates>%.6f,%.6f,%.1f</coordinates></Point>\n</Placemark>\n",
+		p.Lon, p.Lat, p.Altitude*feetToMeters)
+}
+
+type gpxTrackPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele"`
+	Time string  `xml:"time,omitempty"`
+}
+
+type gpxDocument struct {
+	XMLName xml.Name        `xml:"gpx"`
+	Version string          `xml:"version,attr"`
+	Creator string          `xml:"creator,attr"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Name    string          `xml:"trk>name"`
+	Points  []gpxTrackPoint `xml:"trk>trkseg>trkpt"`
+}
+
+// WriteGPX writes track as a GPX 1.1 document with a single track segment
+func WriteGPX(w io.Writer, name string, track []Point) error {
+	if len(track) == 0 {
+		return fmt.Errorf("Cannot export an empty track")
+	}
+
+	doc := gpxDocument{
+		Version: "1.1",
+		Creator: "GoBalloon",
+		XMLNS:   "http://www.topografix.com/GPX/1/1",
+		Name:    name,
+	}
+
+	for _, p := range track {
+		tp := gpxTrackPoint{Lat: p.Lat, Lon: p.Lon, Ele: p.Altitude * feetToMeters}
+		if !p.Time.IsZero() {
+			tp.Time = p.Time.UTC().Format(time.RFC3339)
+		}
+		doc.Points = append(doc.Points, tp)
+	}
+
+	io.WriteString(w, xml.Header)
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// WriteGeoJSON writes track as a GeoJSON Feature with a LineString geometry.  Positions
+// are [lon, lat, altitude in meters] and the fix times go in the "times" property.
+func WriteGeoJSON(w io.Writer, name string, track []Point) error {
+	if len(track) == 0 {
+		return fmt.Errorf("Cannot export an empty track")
+	}
+
+	coords := make([][3]float64, 0, len(track))
+	times := make([]string, 0, len(track))
+
+	for _, p := range track {
+		coords = append(coords, [3]float64{p.Lon, p.Lat, p.Altitude * feetToMeters})
+		if !p.Time.IsZero() {
+			times = append(times, p.Time.UTC().Format(time.RFC3339))
+		}
+	}
+
+	props := map[string]interface{}{"name": name}
+	if len(times) == len(track) {
+		props["times"] = times
+	}
+
+	feature := map[string]interface{}{
+		"type":       "Feature",
+		"properties": props,
+		"geometry": map[string]interface{}{
+			"type":        "LineString",
+			"coordinates": coords,
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feature)
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,187 @@
+// GoBalloon
+// geodesy.go - Great circle and ellipsoidal calculations on Points
+//
+// (c) 2014, Christopher Snell
+//
+// Spherical formulae from www.movable-type.co.uk/scripts/latlong.html
+// Vincenty formulae from www.movable-type.co.uk/scripts/latlong-vincenty.html
+
+package geospatial
+
+import (
+	"errors"
+	"math"
+)
+
+// Mean radius of the earth, in meters, used by the spherical calculations
+const EarthRadius = 6371000.0
+
+// WGS-84 ellipsoid, used by the Vincenty calculations
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+var errVincentyConvergence = errors.New("Vincenty formula failed to converge; points are nearly antipodal")
+
+// HaversineDistanceTo returns the great circle distance to p2 on a spherical earth.
+// This is accurate to about 0.5%.
+func (p1 *Point) HaversineDistanceTo(p2 Point) Distance {
+	φ1 := ToRadians(p1.Lat)
+	φ2 := ToRadians(p2.Lat)
+	Δφ := ToRadians(p2.Lat - p1.Lat)
+	Δλ := ToRadians(p2.Lon - p1.Lon)
+
+	a := math.Sin(Δφ/2)*math.Sin(Δφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(Δλ/2)*math.Sin(Δλ/2)
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+	return Distance(EarthRadius * c)
+}
+
+// DistanceTo returns the great circle distance to p2 in the units of your choosing
+func (p1 *Point) DistanceTo(p2 Point, u Unit) float64 {
+	return p1.HaversineDistanceTo(p2).In(u)
+}
+
+// VincentyDistanceTo returns the distance to p2 on the WGS-84 ellipsoid, accurate to
+// within a millimeter.  It returns an error for nearly antipodal points, where the
+// iteration does not converge.
+func (p1 *Point) VincentyDistanceTo(p2 Point) (Distance, error) {
+	L := ToRadians(p2.Lon - p1.Lon)
+	U1 := math.Atan((1 - wgs84F) * math.Tan(ToRadians(p1.Lat)))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(ToRadians(p2.Lat)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	λ := L
+	var sinσ, cosσ, σ, cosSqα, cos2σM float64
+
+	for i := 0; ; i++ {
+		if i == 200 {
+			return 0, errVincentyConvergence
+		}
+
+		sinλ, cosλ := math.Sincos(λ)
+		sinσ = math.Sqrt((cosU2*sinλ)*(cosU2*sinλ) + (cosU1*sinU2-sinU1*cosU2*cosλ)*(cosU1*sinU2-sinU1*cosU2*cosλ))
+		if sinσ == 0 {
+			// Coincident points
+			return 0, nil
+		}
+		cosσ = sinU1*sinU2 + cosU1*cosU2*cosλ
+		σ = math.Atan2(sinσ, cosσ)
+		sinα := cosU1 * cosU2 * sinλ / sinσ
+		cosSqα = 1 - sinα*sinα
+		cos2σM = 0
+		if cosSqα != 0 {
+			// cosSqα is zero for points on the equator
+			cos2σM = cosσ - 2*sinU1*sinU2/cosSqα
+		}
+		C := wgs84F / 16 * cosSqα * (4 + wgs84F*(4-3*cosSqα))
+		λʹ := λ
+		λ = L + (1-C)*wgs84F*sinα*(σ+C*sinσ*(cos2σM+C*cosσ*(-1+2*cos2σM*cos2σM)))
+
+		if math.Abs(λ-λʹ) < 1e-12 {
+			break
+		}
+	}
+
+	uSq := cosSqα * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	Δσ := B * sinσ * (cos2σM + B/4*(cosσ*(-1+2*cos2σM*cos2σM)-B/6*cos2σM*(-3+4*sinσ*sinσ)*(-3+4*cos2σM*cos2σM)))
+
+	return Distance(wgs84B * A * (σ - Δσ)), nil
+}
+
+// InitialBearingTo returns the bearing, in degrees from true north, that sets out on the
+// great circle toward p2.  On a long path the bearing changes along the way.
+func (p1 *Point) InitialBearingTo(p2 Point) float64 {
+	φ1 := ToRadians(p1.Lat)
+	φ2 := ToRadians(p2.Lat)
+	Δλ := ToRadians(p2.Lon - p1.Lon)
+
+	y := math.Sin(Δλ) * math.Cos(φ2)
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(Δλ)
+
+	return normalizeBearing(ToDegrees(math.Atan2(y, x)))
+}
+
+// FinalBearingTo returns the bearing, in degrees, on which the great circle path
+// arrives at p2
+func (p1 *Point) FinalBearingTo(p2 Point) float64 {
+	return normalizeBearing(p2.InitialBearingTo(*p1) + 180)
+}
+
+// DestinationPoint returns the point reached by travelling distance d from p1 along the
+// great circle that sets out on the given bearing (degrees).  Everything but the
+// position is copied from p1.
+func (p1 *Point) DestinationPoint(bearing float64, d Distance) Point {
+	δ := d.Meters() / EarthRadius
+	θ := ToRadians(bearing)
+	φ1 := ToRadians(p1.Lat)
+	λ1 := ToRadians(p1.Lon)
+
+	φ2 := math.Asin(math.Sin(φ1)*math.Cos(δ) + math.Cos(φ1)*math.Sin(δ)*math.Cos(θ))
+	λ2 := λ1 + math.Atan2(math.Sin(θ)*math.Sin(δ)*math.Cos(φ1), math.Cos(δ)-math.Sin(φ1)*math.Sin(φ2))
+
+	p2 := *p1
+	p2.Lat = ToDegrees(φ2)
+	p2.Lon = normalizeLongitude(ToDegrees(λ2))
+	return p2
+}
+
+// MidpointTo returns the point halfway along the great circle path to p2.  Its altitude
+// is the average of the two.
+func (p1 *Point) MidpointTo(p2 Point) Point {
+	φ1 := ToRadians(p1.Lat)
+	λ1 := ToRadians(p1.Lon)
+	φ2 := ToRadians(p2.Lat)
+	Δλ := ToRadians(p2.Lon - p1.Lon)
+
+	Bx := math.Cos(φ2) * math.Cos(Δλ)
+	By := math.Cos(φ2) * math.Sin(Δλ)
+	φ3 := math.Atan2(math.Sin(φ1)+math.Sin(φ2), math.Sqrt((math.Cos(φ1)+Bx)*(math.Cos(φ1)+Bx)+By*By))
+	λ3 := λ1 + math.Atan2(By, math.Cos(φ1)+Bx)
+
+	return Point{
+		Lat:      ToDegrees(φ3),
+		Lon:      normalizeLongitude(ToDegrees(λ3)),
+		Altitude: (p1.Altitude + p2.Altitude) / 2,
+	}
+}
+
+// CrossTrackDistanceTo returns how far p1 lies off the great circle path from start to
+// end.  The distance is negative if p1 is to the left of the path and positive if it's
+// to the right.
+func (p1 *Point) CrossTrackDistanceTo(start, end Point) Distance {
+	δ13 := start.HaversineDistanceTo(*p1).Meters() / EarthRadius
+	θ13 := ToRadians(start.InitialBearingTo(*p1))
+	θ12 := ToRadians(start.InitialBearingTo(end))
+
+	return Distance(math.Asin(math.Sin(δ13)*math.Sin(θ13-θ12)) * EarthRadius)
+}
+
+// AlongTrackDistanceTo returns how far along the great circle path from start to end
+// the point closest to p1 lies
+func (p1 *Point) AlongTrackDistanceTo(start, end Point) Distance {
+	δ13 := start.HaversineDistanceTo(*p1).Meters() / EarthRadius
+	δxt := p1.CrossTrackDistanceTo(start, end).Meters() / EarthRadius
+
+	δat := math.Acos(math.Cos(δ13) / math.Cos(δxt))
+
+	// The closest point is behind start if p1 is more than 90° off the path's bearing
+	if math.Cos(ToRadians(start.InitialBearingTo(*p1)-start.InitialBearingTo(end))) < 0 {
+		δat = -δat
+	}
+
+	return Distance(δat * EarthRadius)
+}
+
+func normalizeBearing(b float64) float64 {
+	return math.Mod(b+360, 360)
+}
+
+func normalizeLongitude(lon float64) float64 {
+	return math.Mod(lon+540, 360) - 180
+}
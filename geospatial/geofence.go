@@ -0,0 +1,446 @@
+// GoBalloon
+// geofence.go - Named polygon regions on the sphere, loaded from GeoJSON or KML
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Polygon is an area bounded by an outer ring, less any holes.  Rings are lists of
+// vertices joined by great circle arcs; closing the ring by repeating the first vertex is
+// optional.  A polygon must be smaller than a hemisphere.
+type Polygon struct {
+	Outer []Point
+	Holes [][]Point
+}
+
+// Region is a named area made up of one or more polygons, e.g. a country with islands
+type Region struct {
+	Name     string
+	Polygons []Polygon
+}
+
+// Geofence is a set of named regions
+type Geofence struct {
+	Regions []Region
+}
+
+// RegionStatus describes where a point lies relative to one region
+type RegionStatus struct {
+	Name     string
+	Inside   bool
+	Boundary Distance // Distance to the nearest edge of the region
+}
+
+// ringContains uses the winding number of the ring around p: the bearings from p to each
+// vertex in turn sweep through a full circle if p is inside the ring, and back to where
+// they started if it isn't.  Working with bearings keeps this correct on the sphere.
+func ringContains(ring []Point, p Point) bool {
+	if len(ring) < 3 {
+		return false
+	}
+
+	var sum float64
+	n := len(ring)
+
+	for i := 0; i < n; i++ {
+		a := ring[i]
+		b := ring[(i+1)%n]
+
+		if a.Lat == p.Lat && a.Lon == p.Lon {
+			// We're sitting on a vertex, which counts as inside
+			return true
+		}
+
+		Δ := p.InitialBearingTo(b) - p.InitialBearingTo(a)
+		for Δ > 180 {
+			Δ -= 360
+		}
+		for Δ <= -180 {
+			Δ += 360
+		}
+		sum += Δ
+	}
+
+	return math.Abs(sum) > 180
+}
+
+// ringDistance returns the distance from p to the nearest edge of the ring
+func ringDistance(ring []Point, p Point) Distance {
+	best := Distance(math.Inf(1))
+	n := len(ring)
+
+	for i := 0; i < n; i++ {
+		d := segmentDistance(ring[i], ring[(i+1)%n], p)
+		if d < best {
+			best = d
+		}
+	}
+
+	return best
+}
+
+// segmentDistance returns the distance from p to the great circle arc from a to b
+func segmentDistance(a, b, p Point) Distance {
+	length := a.HaversineDistanceTo(b)
+	if length == 0 {
+		return a.HaversineDistanceTo(p)
+	}
+
+	along := p.AlongTrackDistanceTo(a, b)
+	if along > 0 && along < length {
+		return Distance(math.Abs(p.CrossTrackDistanceTo(a, b).Meters()))
+	}
+
+	return Distance(math.Min(a.HaversineDistanceTo(p).Meters(), b.HaversineDistanceTo(p).Meters()))
+}
+
+// Contains reports whether p lies inside the polygon and outside all of its holes
+func (pg *Polygon) Contains(p Point) bool {
+	if !ringContains(pg.Outer, p) {
+		return false
+	}
+
+	for _, h := range pg.Holes {
+		if ringContains(h, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DistanceToBoundary returns the distance from p to the nearest edge of the polygon,
+// including the edges of its holes
+func (pg *Polygon) DistanceToBoundary(p Point) Distance {
+	d := ringDistance(pg.Outer, p)
+
+	for _, h := range pg.Holes {
+		if hd := ringDistance(h, p); hd < d {
+			d = hd
+		}
+	}
+
+	return d
+}
+
+// Contains reports whether p lies within any of the region's polygons
+func (r *Region) Contains(p Point) bool {
+	for i := range r.Polygons {
+		if r.Polygons[i].Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// DistanceToBoundary returns the distance from p to the nearest edge of the region
+func (r *Region) DistanceToBoundary(p Point) Distance {
+	d := Distance(math.Inf(1))
+
+	for i := range r.Polygons {
+		if pd := r.Polygons[i].DistanceToBoundary(p); pd < d {
+			d = pd
+		}
+	}
+
+	return d
+}
+
+// RegionsContaining returns the names of all regions that p is inside
+func (g *Geofence) RegionsContaining(p Point) []string {
+	var names []string
+
+	for i := range g.Regions {
+		if g.Regions[i].Contains(p) {
+			names = append(names, g.Regions[i].Name)
+		}
+	}
+
+	return names
+}
+
+// Status returns where p lies relative to every region in the geofence
+func (g *Geofence) Status(p Point) []RegionStatus {
+	status := make([]RegionStatus, 0, len(g.Regions))
+
+	for i := range g.Regions {
+		status = append(status, RegionStatus{
+			Name:     g.Regions[i].Name,
+			Inside:   g.Regions[i].Contains(p),
+			Boundary: g.Regions[i].DistanceToBoundary(p),
+		})
+	}
+
+	return status
+}
+
+// LoadGeofence reads regions from a GeoJSON (.geojson, .json) or KML (.kml) file
+func LoadGeofence(filename string) (*Geofence, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".kml":
+		return LoadKML(f)
+	case ".geojson", ".json":
+		return LoadGeoJSON(f)
+	}
+
+	return nil, fmt.Errorf("Don't know how to load a geofence from %v.  Use GeoJSON or KML.", filename)
+}
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []geoJSONGeometry `json:"geometries"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+}
+
+type geoJSONDocument struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+	geoJSONGeometry
+}
+
+// LoadGeoJSON reads regions from GeoJSON.  Each Polygon or MultiPolygon feature becomes a
+// region named by its "name" property.  A bare geometry becomes a single unnamed region.
+func LoadGeoJSON(r io.Reader) (*Geofence, error) {
+	var doc geoJSONDocument
+
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse GeoJSON: %v", err)
+	}
+
+	g := &Geofence{}
+
+	switch doc.Type {
+	case "FeatureCollection":
+		for i, f := range doc.Features {
+			if f.Geometry == nil {
+				continue
+			}
+			polys, err := f.Geometry.polygons()
+			if err != nil {
+				return nil, fmt.Errorf("GeoJSON feature %v: %v", i, err)
+			}
+			if len(polys) == 0 {
+				continue
+			}
+			g.Regions = append(g.Regions, Region{Name: featureName(f.Properties, i), Polygons: polys})
+		}
+	case "Feature":
+		return nil, fmt.Errorf("Bare GeoJSON features aren't supported; wrap them in a FeatureCollection")
+	default:
+		polys, err := doc.geoJSONGeometry.polygons()
+		if err != nil {
+			return nil, err
+		}
+		g.Regions = append(g.Regions, Region{Name: "region 0", Polygons: polys})
+	}
+
+	return g, nil
+}
+
+// featureName finds a name for a feature, trying the usual property names
+func featureName(props map[string]interface{}, i int) string {
+	for _, k := range []string{"name", "Name", "NAME", "title"} {
+		if v, ok := props[k].(string); ok && len(v) > 0 {
+			return v
+		}
+	}
+	return fmt.Sprintf("region %v", i)
+}
+
+func (geom *geoJSONGeometry) polygons() ([]Polygon, error) {
+	switch geom.Type {
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(geom.Coordinates, &rings); err != nil {
+			return nil, fmt.Errorf("Invalid Polygon coordinates: %v", err)
+		}
+		p, err := polygonFromGeoJSON(rings)
+		if err != nil {
+			return nil, err
+		}
+		return []Polygon{p}, nil
+
+	case "MultiPolygon":
+		var multi [][][][]float64
+		if err := json.Unmarshal(geom.Coordinates, &multi); err != nil {
+			return nil, fmt.Errorf("Invalid MultiPolygon coordinates: %v", err)
+		}
+		var polys []Polygon
+		for _, rings := range multi {
+			p, err := polygonFromGeoJSON(rings)
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, p)
+		}
+		return polys, nil
+
+	case "GeometryCollection":
+		var polys []Polygon
+		for i := range geom.Geometries {
+			p, err := geom.Geometries[i].polygons()
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, p...)
+		}
+		return polys, nil
+	}
+
+	// Points and lines don't enclose anything
+	return nil, nil
+}
+
+func polygonFromGeoJSON(rings [][][]float64) (Polygon, error) {
+	var p Polygon
+
+	for i, ring := range rings {
+		var pts []Point
+		for _, c := range ring {
+			// GeoJSON positions are longitude first
+			if len(c) < 2 {
+				return p, fmt.Errorf("Invalid GeoJSON position: %v", c)
+			}
+			pts = append(pts, Point{Lon: c[0], Lat: c[1]})
+		}
+		if i == 0 {
+			p.Outer = pts
+		} else {
+			p.Holes = append(p.Holes, pts)
+		}
+	}
+
+	return p, nil
+}
+
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+type kmlPlacemark struct {
+	Name     string       `xml:"name"`
+	Polygons []kmlPolygon `xml:"Polygon"`
+	Multi    []kmlPolygon `xml:"MultiGeometry>Polygon"`
+}
+
+type kmlFolder struct {
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+	Folders    []kmlFolder    `xml:"Folder"`
+}
+
+type kmlDocument struct {
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+	Folders    []kmlFolder    `xml:"Folder"`
+	Document   *kmlFolder     `xml:"Document"`
+}
+
+// LoadKML reads regions from KML.  Each Placemark with a Polygon or MultiGeometry
+// becomes a region named by the Placemark's name.
+func LoadKML(r io.Reader) (*Geofence, error) {
+	var doc kmlDocument
+
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse KML: %v", err)
+	}
+
+	root := kmlFolder{Placemarks: doc.Placemarks, Folders: doc.Folders}
+	if doc.Document != nil {
+		root.Folders = append(root.Folders, *doc.Document)
+	}
+
+	g := &Geofence{}
+	err = g.addKMLFolder(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *Geofence) addKMLFolder(f kmlFolder) error {
+	for _, pm := range f.Placemarks {
+		var polys []Polygon
+
+		for _, kp := range append(pm.Polygons, pm.Multi...) {
+			outer, err := parseKMLCoordinates(kp.Outer)
+			if err != nil {
+				return fmt.Errorf("Placemark %q: %v", pm.Name, err)
+			}
+			p := Polygon{Outer: outer}
+			for _, in := range kp.Inner {
+				hole, err := parseKMLCoordinates(in)
+				if err != nil {
+					return fmt.Errorf("Placemark %q: %v", pm.Name, err)
+				}
+				p.Holes = append(p.Holes, hole)
+			}
+			polys = append(polys, p)
+		}
+
+		if len(polys) > 0 {
+			name := strings.TrimSpace(pm.Name)
+			if len(name) == 0 {
+				name = fmt.Sprintf("region %v", len(g.Regions))
+			}
+			g.Regions = append(g.Regions, Region{Name: name, Polygons: polys})
+		}
+	}
+
+	for _, sub := range f.Folders {
+		if err := g.addKMLFolder(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseKMLCoordinates parses a KML coordinate list: whitespace-separated lon,lat[,alt] tuples
+func parseKMLCoordinates(s string) ([]Point, error) {
+	var pts []Point
+
+	for _, tuple := range strings.Fields(s) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("Invalid KML coordinate %q", tuple)
+		}
+		lon, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid KML longitude %q", parts[0])
+		}
+		lat, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid KML latitude %q", parts[1])
+		}
+		pts = append(pts, Point{Lat: lat, Lon: lon})
+	}
+
+	return pts, nil
+}
@@ -6,7 +6,6 @@
 package geospatial
 
 import (
-	"fmt"
 	"math"
 	"time"
 )
@@ -15,10 +14,12 @@ type Point struct {
 	Lat            float64
 	Lon            float64
 	Altitude       float64
+	Climb          float64 // Vertical rate in feet per minute; negative when descending
 	Speed          float32
 	Heading        uint16
 	RadioRange     float32
 	MessageCapable bool
+	Ambiguity      int // APRS position ambiguity: how many trailing digits are blanked (0-4)
 	Time           time.Time
 }
 
@@ -39,46 +40,13 @@ func ToDegrees(d float64) float64 {
 	return d * RadToDeg
 }
 
+// GreatCircleDistanceTo returns the great circle distance to p2 in miles.  Use
+// HaversineDistanceTo or DistanceTo to get other units.
 func (p1 *Point) GreatCircleDistanceTo(p2 Point) (d float64) {
-	// Formula from www.movable-type.co.uk/scripts/latlong.html
-	R := float64(6371)
-	φ1 := ToRadians(p1.Lat)
-	φ2 := ToRadians(p2.Lat)
-	Δφ := ToRadians(p2.Lat - p1.Lat)
-	Δλ := ToRadians(p2.Lon - p1.Lon)
-
-	a := math.Sin(Δφ/2)*math.Sin(Δφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(Δλ/2)*math.Sin(Δλ/2)
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	// Return our GC distance in miles
-	return R * c * 0.621371
+	return p1.HaversineDistanceTo(p2).Miles()
 }
 
+// BearingTo returns the initial bearing to p2, truncated to whole degrees
 func (p1 *Point) BearingTo(p2 Point) uint16 {
-	// Formula from www.movable-type.co.uk/scripts/latlong.html
-	φ1 := ToRadians(p1.Lat)
-	φ2 := ToRadians(p2.Lat)
-	Δλ := ToRadians(p2.Lon - p1.Lon)
-
-	y := math.Sin(Δλ) * math.Cos(φ2)
-	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(Δλ)
-	θ := math.Atan2(y, x)
-
-	return ((uint16(ToDegrees(θ) + 360)) % 360)
-}
-
-// APRS latitude format:  DDMM.mm
-func LatDecimalDegreesToDegreesDecimalMinutes(d float64) string {
-	deg := math.Floor(d)
-	min := (d - deg) * 60
-	ddm := fmt.Sprintf("%02d%02.2f", int(deg), min)
-	return ddm
-}
-
-// APRS longitude format: DDDMM.mm
-func LonDecimalDegreesToDegreesDecimalMinutes(d float64) string {
-	deg := math.Floor(d)
-	min := (d - deg) * 60
-	ddm := fmt.Sprintf("%03d%02.2f", int(deg), min)
-	return ddm
+	return uint16(p1.InitialBearingTo(p2)) % 360
 }
@@ -0,0 +1,70 @@
+// GoBalloon
+// lookangles.go - Antenna pointing and line-of-sight calculations between two Points
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"math"
+)
+
+// Radio waves bend slightly around the earth, so the radio horizon is further away than
+// the optical one.  The usual allowance is to pretend the earth is 4/3 its real size.
+const effectiveEarthRadiusFactor = 4.0 / 3.0
+
+// ecef returns p's earth-centered, earth-fixed coordinates in meters on the WGS-84 ellipsoid
+func (p *Point) ecef() (x, y, z float64) {
+	φ := ToRadians(p.Lat)
+	λ := ToRadians(p.Lon)
+	h := p.Altitude * feetToMeters
+
+	sinφ, cosφ := math.Sincos(φ)
+	sinλ, cosλ := math.Sincos(λ)
+
+	e2 := wgs84F * (2 - wgs84F)
+	N := wgs84A / math.Sqrt(1-e2*sinφ*sinφ)
+
+	x = (N + h) * cosφ * cosλ
+	y = (N + h) * cosφ * sinλ
+	z = (N*(1-e2) + h) * sinφ
+
+	return x, y, z
+}
+
+// LookAnglesTo returns the azimuth (degrees from true north) and elevation (degrees above
+// the horizon) that an antenna at p must point to see target, along with the straight-line
+// slant range between them.  Both altitudes are taken into account and the earth's
+// curvature is handled exactly, but atmospheric refraction is not.
+func (p *Point) LookAnglesTo(target Point) (azimuth, elevation float64, slant Distance) {
+	x1, y1, z1 := p.ecef()
+	x2, y2, z2 := target.ecef()
+	dx, dy, dz := x2-x1, y2-y1, z2-z1
+
+	sinφ, cosφ := math.Sincos(ToRadians(p.Lat))
+	sinλ, cosλ := math.Sincos(ToRadians(p.Lon))
+
+	// Rotate the difference vector into p's local east, north, up frame
+	east := -sinλ*dx + cosλ*dy
+	north := -sinφ*cosλ*dx - sinφ*sinλ*dy + cosφ*dz
+	up := cosφ*cosλ*dx + cosφ*sinλ*dy + sinφ*dz
+
+	azimuth = normalizeBearing(ToDegrees(math.Atan2(east, north)))
+	elevation = ToDegrees(math.Atan2(up, math.Hypot(east, north)))
+	slant = Distance(math.Sqrt(dx*dx + dy*dy + dz*dz))
+
+	return azimuth, elevation, slant
+}
+
+// RadioHorizon returns the distance to the radio horizon from p's altitude, measured
+// along the ground.  Negative altitudes are treated as sea level.
+func (p *Point) RadioHorizon() Distance {
+	h := math.Max(0, p.Altitude*feetToMeters)
+	return Distance(math.Sqrt(2 * effectiveEarthRadiusFactor * EarthRadius * h))
+}
+
+// HasLineOfSightTo reports whether a radio at p can see one at target over a smooth
+// earth.  This ignores terrain, so a true result is only a best case.
+func (p *Point) HasLineOfSightTo(target Point) bool {
+	return p.HaversineDistanceTo(target) <= p.RadioHorizon()+target.RadioHorizon()
+}
@@ -0,0 +1,119 @@
+// GoBalloon
+// maidenhead.go - Maidenhead grid locator conversions
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Size of each Maidenhead grid subdivision in degrees of longitude.  The latitude
+// sizes are half of these.  Pairs go field (A-R), square (0-9), subsquare (a-x),
+// extended square (0-9).
+var maidenheadLonSize = []float64{20, 2, 5.0 / 60, 0.5 / 60}
+
+// MaidenheadLocator returns the Maidenhead grid locator of the given position with 4,
+// 6 or 8 characters, e.g. CN87ts
+func MaidenheadLocator(lat, lon float64, length int) (string, error) {
+	if length != 4 && length != 6 && length != 8 {
+		return "", fmt.Errorf("Maidenhead locators must be 4, 6 or 8 characters, not %v", length)
+	}
+
+	if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return "", fmt.Errorf("Position out of range: %v,%v", lat, lon)
+	}
+
+	// Measure from the south pole and antimeridian.  The north pole and the
+	// antimeridian itself belong in the last square rather than off the grid.
+	x := math.Min(lon+180, 360-1e-9)
+	y := math.Min(lat+90, 180-1e-9)
+
+	var b strings.Builder
+
+	for i := 0; i < length/2; i++ {
+		lonSize := maidenheadLonSize[i]
+		latSize := lonSize / 2
+
+		xi := int(x / lonSize)
+		yi := int(y / latSize)
+		x -= float64(xi) * lonSize
+		y -= float64(yi) * latSize
+
+		switch i {
+		case 0:
+			b.WriteByte(byte('A' + xi))
+			b.WriteByte(byte('A' + yi))
+		case 2:
+			b.WriteByte(byte('a' + xi))
+			b.WriteByte(byte('a' + yi))
+		default:
+			b.WriteByte(byte('0' + xi))
+			b.WriteByte(byte('0' + yi))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// ParseMaidenheadLocator returns the center of the grid square described by a 2, 4, 6 or
+// 8 character Maidenhead locator.  Case is ignored.
+func ParseMaidenheadLocator(loc string) (Point, error) {
+	p := Point{}
+	loc = strings.TrimSpace(loc)
+
+	if len(loc) == 0 || len(loc)%2 != 0 || len(loc) > 8 {
+		return p, fmt.Errorf("Invalid Maidenhead locator: %q", loc)
+	}
+
+	var x, y, lonSize float64
+
+	for i := 0; i < len(loc)/2; i++ {
+		lonSize = maidenheadLonSize[i]
+		latSize := lonSize / 2
+
+		cx := loc[2*i]
+		cy := loc[2*i+1]
+
+		var xi, yi int
+		switch i {
+		case 0:
+			xi = int(upper(cx) - 'A')
+			yi = int(upper(cy) - 'A')
+			if xi < 0 || xi >= 18 || yi < 0 || yi >= 18 {
+				return p, fmt.Errorf("Invalid Maidenhead field in %q", loc)
+			}
+		case 2:
+			xi = int(upper(cx) - 'A')
+			yi = int(upper(cy) - 'A')
+			if xi < 0 || xi >= 24 || yi < 0 || yi >= 24 {
+				return p, fmt.Errorf("Invalid Maidenhead subsquare in %q", loc)
+			}
+		default:
+			xi = int(cx) - '0'
+			yi = int(cy) - '0'
+			if xi < 0 || xi > 9 || yi < 0 || yi > 9 {
+				return p, fmt.Errorf("Invalid Maidenhead square in %q", loc)
+			}
+		}
+
+		x += float64(xi) * lonSize
+		y += float64(yi) * latSize
+	}
+
+	// Move to the center of the smallest square we were given
+	p.Lon = x + lonSize/2 - 180
+	p.Lat = y + lonSize/4 - 90
+
+	return p, nil
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
@@ -0,0 +1,80 @@
+// GoBalloon
+// predict.go - Landing point prediction for a payload descending under parachute
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"math"
+	"time"
+)
+
+const feetToMeters = 0.3048
+
+// Parachute describes a payload train falling under canopy
+type Parachute struct {
+	Mass     float64 // Total descending mass in kg (payload, chute and balloon remnants)
+	Diameter float64 // Canopy diameter in meters
+	Drag     float64 // Drag coefficient.  About 1.5 for a typical HAB parasheet.
+}
+
+// SeaLevelDescentRate returns the terminal velocity at sea level, in feet per minute.
+// At terminal velocity, drag balances weight:  m·g = ½·ρ·v²·Cd·A
+func (c Parachute) SeaLevelDescentRate() float64 {
+	area := math.Pi * math.Pow(c.Diameter/2, 2)
+	v := math.Sqrt(2 * c.Mass * gravity / (SeaLevelDensity * c.Drag * area))
+	return v / feetToMeters * 60
+}
+
+// DescentRateAt scales a sea-level descent rate (ft/min) to the given altitude (ft).
+// Terminal velocity goes as 1/√ρ, so the payload falls much faster in thin air.
+func DescentRateAt(seaLevelRate, alt float64) float64 {
+	return seaLevelRate * math.Sqrt(SeaLevelDensity/AirDensity(alt*feetToMeters))
+}
+
+// SeaLevelDescentRateFrom works backwards from a descent rate (ft/min) observed at some
+// altitude (ft) to the equivalent rate at sea level
+func SeaLevelDescentRateFrom(observedRate, alt float64) float64 {
+	return math.Abs(observedRate) * math.Sqrt(AirDensity(alt*feetToMeters)/SeaLevelDensity)
+}
+
+// How far we fall between wind lookups when integrating a descent
+const predictionStep = 5 * time.Second
+
+// PredictLanding estimates where a payload descending from p will land.  seaLevelRate is
+// the descent rate at sea level in feet per minute, ground is the altitude of the landing
+// area in feet and wind is the profile observed on the way up.  The returned Point's
+// Time is the predicted time of landing.
+func PredictLanding(p Point, wind *WindProfile, seaLevelRate, ground float64) Point {
+	landing := p
+	landing.Speed = 0
+	landing.Heading = 0
+	landing.Climb = 0
+
+	if seaLevelRate <= 0 {
+		return landing
+	}
+
+	dt := predictionStep.Seconds()
+	t := p.Time
+
+	for landing.Altitude > ground {
+		rate := DescentRateAt(seaLevelRate, landing.Altitude)
+
+		if wind != nil {
+			if north, east, ok := wind.Velocity(landing.Altitude); ok {
+				bearing := ToDegrees(math.Atan2(east, north))
+				landing = landing.DestinationPoint(bearing, Distance(math.Hypot(north, east)*dt))
+			}
+		}
+
+		landing.Altitude -= rate * dt / 60
+		t = t.Add(predictionStep)
+	}
+
+	landing.Altitude = ground
+	landing.Time = t
+
+	return landing
+}
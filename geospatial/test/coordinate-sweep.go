@@ -0,0 +1,139 @@
+// GoBalloon
+// coordinate-sweep.go - Checks APRS coordinate formatting and parsing over the whole globe
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"flag"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// One hundredth of a minute, the resolution of an APRS position, in degrees
+const resolution = 0.01 / 60
+
+var boxMinutes = []float64{0, 0.1, 1, 10, 60}
+
+// check formats a coordinate at every level of ambiguity and makes sure that:
+//
+//   - the string has the right length and shape, with minutes below 60
+//   - it parses back to within half an ambiguity box (plus rounding) of where it started
+//   - formatting the parsed value gives back exactly the same string
+//   - the hemisphere matches the sign, with zero reported as N or E
+func check(lat, lon float64) {
+	for a := 0; a <= geospatial.MaxAmbiguity; a++ {
+		tolerance := boxMinutes[a]/120 + resolution/2 + 1e-9
+
+		slat, err := geospatial.FormatAPRSLatitude(lat, a)
+		if err != nil {
+			checks.Failf("FormatAPRSLatitude(%v, %v): %v", lat, a, err)
+			continue
+		}
+		slon, err := geospatial.FormatAPRSLongitude(lon, a)
+		if err != nil {
+			checks.Failf("FormatAPRSLongitude(%v, %v): %v", lon, a, err)
+			continue
+		}
+
+		if len(slat) != 8 || slat[4] != '.' || len(slon) != 9 || slon[5] != '.' {
+			checks.Failf("Bad shape for %v,%v at ambiguity %v: %q %q", lat, lon, a, slat, slon)
+			continue
+		}
+		if m := strings.Replace(slat[2:4], " ", "0", -1); m >= "60" {
+			checks.Failf("Latitude minutes out of range: %v -> %q", lat, slat)
+		}
+		if m := strings.Replace(slon[3:5], " ", "0", -1); m >= "60" {
+			checks.Failf("Longitude minutes out of range: %v -> %q", lon, slon)
+		}
+
+		plat, amb, err := geospatial.ParseAPRSLatitude(slat)
+		if err != nil {
+			checks.Failf("ParseAPRSLatitude(%q): %v", slat, err)
+			continue
+		}
+		if amb != a {
+			checks.Failf("ParseAPRSLatitude(%q) found ambiguity %v, want %v", slat, amb, a)
+		}
+		plon, err := geospatial.ParseAPRSLongitude(slon, amb)
+		if err != nil {
+			checks.Failf("ParseAPRSLongitude(%q): %v", slon, err)
+			continue
+		}
+
+		if math.Abs(plat-lat) > tolerance {
+			checks.Failf("Latitude %v -> %q -> %v is off by %v", lat, slat, plat, plat-lat)
+		}
+		// At ±180 the two hemispheres are the same meridian
+		if d := math.Abs(plon - lon); d > tolerance && math.Abs(d-360) > tolerance {
+			checks.Failf("Longitude %v -> %q -> %v is off by %v", lon, slon, plon, plon-lon)
+		}
+
+		rlat, _ := geospatial.FormatAPRSLatitude(plat, a)
+		rlon, _ := geospatial.FormatAPRSLongitude(plon, a)
+		if rlat != slat || rlon != slon {
+			checks.Failf("Round trip of %v,%v at ambiguity %v: %q %q -> %q %q", lat, lon, a, slat, slon, rlat, rlon)
+		}
+
+		if (lat < -resolution/2 && slat[7] != 'S') || (lat > -resolution/2 && slat[7] != 'N') {
+			if math.Abs(lat) > resolution {
+				checks.Failf("Wrong hemisphere for latitude %v: %q", lat, slat)
+			}
+		}
+		if math.Abs(lon) < resolution/2 && slon[8] != 'E' {
+			checks.Failf("Longitude %v should be east: %q", lon, slon)
+		}
+		if lon < -resolution && lon > -180+resolution && slon[8] != 'W' {
+			checks.Failf("Wrong hemisphere for longitude %v: %q", lon, slon)
+		}
+	}
+}
+
+func main() {
+	n := flag.Int("n", 1000000, "Number of random positions to check")
+	seed := flag.Int64("seed", 1, "Random seed")
+	flag.Parse()
+
+	checks.MaxReported = 20
+
+	r := rand.New(rand.NewSource(*seed))
+
+	// Every whole degree and every minute boundary nudged either side of it, which is
+	// where rounding to 60.00 and hemisphere mistakes happen
+	nudges := []float64{0, 1e-12, -1e-12, resolution / 2, -resolution / 2, resolution * 0.4999, -resolution * 0.4999,
+		59.999 / 60, 59.995 / 60, 59.994 / 60, 0.5 / 60}
+	for deg := -180; deg <= 180; deg++ {
+		for _, nudge := range nudges {
+			for _, m := range []float64{0, 1, 9, 10, 30, 59} {
+				d := float64(deg) + nudge + m/60
+				if math.Abs(d) > 180 {
+					continue
+				}
+				check(math.Max(-90, math.Min(90, d/2)), d)
+				check(math.Max(-90, math.Min(90, d)), d)
+			}
+		}
+	}
+
+	for i := 0; i < *n; i++ {
+		check(r.Float64()*180-90, r.Float64()*360-180)
+	}
+
+	// Strings that must be rejected
+	for _, s := range []string{"", "4903.50", "4903.50X", "9100.00N", "4960.00N", "49 3.50N", "4903,50N", "4903.5 0N", "-903.50N", "+903.50N"} {
+		if _, _, err := geospatial.ParseAPRSLatitude(s); err == nil {
+			checks.Failf("ParseAPRSLatitude(%q) should have failed", s)
+		}
+	}
+	for _, s := range []string{"", "07201.75", "07201.75N", "18100.00W", "07260.00W", "072 1.75W", "-7201.75W"} {
+		if _, err := geospatial.ParseAPRSLongitude(s, 0); err == nil {
+			checks.Failf("ParseAPRSLongitude(%q) should have failed", s)
+		}
+	}
+
+	checks.Done()
+}
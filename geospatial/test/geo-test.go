@@ -3,21 +3,74 @@ package main
 import (
 	"fmt"
 	"github.com/chrissnell/GoBalloon/geospatial"
+	"strings"
 )
 
 func main() {
 	p := geospatial.NewPoint()
 	p.Lat = 24.910
 	p.Lon = -114.301
-	p.Alt = 2000
+	p.Altitude = 2000
 	fmt.Printf("point: %#v\n", p)
 
 	p2 := geospatial.NewPoint()
 	p2.Lat = 25.109
 	p2.Lon = -112.121
-	p2.Alt = 89
+	p2.Altitude = 89
 	fmt.Printf("point: %#v\n", p2)
 
-	d := p.DistanceTo(p2)
-	fmt.Printf("Distance from p to p2: %v\n", d)
+	fmt.Printf("Distance from p to p2: %.3f mi / %.3f km / %.3f nmi\n",
+		p.GreatCircleDistanceTo(*p2), p.DistanceTo(*p2, geospatial.Kilometers), p.DistanceTo(*p2, geospatial.NauticalMiles))
+
+	v, err := p.VincentyDistanceTo(*p2)
+	fmt.Printf("Vincenty distance from p to p2: %v (err: %v)\n", v, err)
+
+	fmt.Printf("Bearing from p to p2: initial %.2f°, final %.2f°\n", p.InitialBearingTo(*p2), p.FinalBearingTo(*p2))
+
+	mid := p.MidpointTo(*p2)
+	fmt.Printf("Midpoint: %.5f,%.5f\n", mid.Lat, mid.Lon)
+
+	dest := p.DestinationPoint(p.InitialBearingTo(*p2), p.HaversineDistanceTo(*p2))
+	fmt.Printf("Destination from p along the path to p2: %.5f,%.5f (want %.5f,%.5f)\n", dest.Lat, dest.Lon, p2.Lat, p2.Lon)
+
+	fmt.Printf("Cross-track distance of midpoint: %v\n", mid.CrossTrackDistanceTo(*p, *p2))
+	fmt.Printf("Along-track distance of midpoint: %v\n", mid.AlongTrackDistanceTo(*p, *p2))
+
+	// Flinders Peak to Buninyong, the example from Vincenty's paper: 54972.271 m
+	flinders := geospatial.Point{Lat: -37.95103341666667, Lon: 144.42486788888888}
+	buninyong := geospatial.Point{Lat: -37.65282113888889, Lon: 143.92649552777778}
+	v, err = flinders.VincentyDistanceTo(buninyong)
+	fmt.Printf("Flinders Peak to Buninyong: %.3f m (want 54972.271 m, err: %v)\n", v.Meters(), err)
+
+	// A balloon at 90,000 ft, 100 miles east of a ground station at 500 ft
+	ground := geospatial.Point{Lat: 47.2101, Lon: -122.4818, Altitude: 500}
+	balloon := ground.DestinationPoint(90, geospatial.NewDistance(100, geospatial.Miles))
+	balloon.Altitude = 90000
+	az, el, slant := ground.LookAnglesTo(balloon)
+	fmt.Printf("Look angles to balloon: az %.2f° el %.2f° slant %.1f mi\n", az, el, slant.Miles())
+	fmt.Printf("Radio horizons: ground %.1f mi, balloon %.1f mi, line of sight: %v\n",
+		ground.RadioHorizon().Miles(), balloon.RadioHorizon().Miles(), ground.HasLineOfSightTo(balloon))
+
+	// A square around the launch site with a hole cut out of the middle, plus a lake
+	fence, err := geospatial.LoadGeoJSON(strings.NewReader(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"name": "Launch area"}, "geometry": {"type": "Polygon", "coordinates": [
+			[[-123.0, 47.0], [-122.0, 47.0], [-122.0, 47.5], [-123.0, 47.5], [-123.0, 47.0]],
+			[[-122.6, 47.2], [-122.4, 47.2], [-122.4, 47.3], [-122.6, 47.3], [-122.6, 47.2]]]}},
+		{"type": "Feature", "properties": {"name": "Lake"}, "geometry": {"type": "MultiPolygon", "coordinates": [
+			[[[-122.9, 47.05], [-122.8, 47.05], [-122.8, 47.1], [-122.9, 47.1]]]]}}]}`))
+	if err != nil {
+		fmt.Println("Geofence error:", err)
+		return
+	}
+	for _, q := range []geospatial.Point{{Lat: 47.1, Lon: -122.2}, {Lat: 47.25, Lon: -122.5}, {Lat: 47.07, Lon: -122.85}, {Lat: 48, Lon: -122.5}} {
+		fmt.Printf("%.2f,%.2f is in %v\n", q.Lat, q.Lon, fence.RegionsContaining(q))
+		for _, s := range fence.Status(q) {
+			fmt.Printf("    %v: inside %v, %.2f mi from the boundary\n", s.Name, s.Inside, s.Boundary.Miles())
+		}
+	}
+
+	kml, err := geospatial.LoadKML(strings.NewReader(`<kml><Document><Folder><Placemark><name>Restricted</name>
+		<Polygon><outerBoundaryIs><LinearRing><coordinates>-122.1,47.1,0 -122.0,47.1,0 -122.0,47.2,0 -122.1,47.2,0</coordinates></LinearRing></outerBoundaryIs></Polygon>
+		</Placemark></Folder></Document></kml>`))
+	fmt.Printf("KML regions: %v (err: %v), 47.15,-122.05 is in %v\n", len(kml.Regions), err, kml.RegionsContaining(geospatial.Point{Lat: 47.15, Lon: -122.05}))
 }
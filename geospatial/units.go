@@ -0,0 +1,80 @@
+// GoBalloon
+// units.go - Distance units
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"fmt"
+)
+
+// Unit is a unit of distance, expressed as the number of meters in one of it
+type Unit float64
+
+const (
+	Meters        Unit = 1
+	Kilometers    Unit = 1000
+	Feet          Unit = 0.3048
+	Miles         Unit = 1609.344
+	NauticalMiles Unit = 1852
+)
+
+// String returns the unit's usual abbreviation
+func (u Unit) String() string {
+	switch u {
+	case Meters:
+		return "m"
+	case Kilometers:
+		return "km"
+	case Feet:
+		return "ft"
+	case Miles:
+		return "mi"
+	case NauticalMiles:
+		return "nmi"
+	}
+	return fmt.Sprintf("%gm", float64(u))
+}
+
+// Distance is a length in meters.  Use In() or one of the named methods to get it
+// out in the units you want, rather than assuming what a float64 means.
+type Distance float64
+
+// NewDistance returns a Distance of v in the given units, e.g. NewDistance(5, Miles)
+func NewDistance(v float64, u Unit) Distance {
+	return Distance(v * float64(u))
+}
+
+// In returns the distance in the given units
+func (d Distance) In(u Unit) float64 {
+	return float64(d) / float64(u)
+}
+
+func (d Distance) Meters() float64 {
+	return float64(d)
+}
+
+func (d Distance) Kilometers() float64 {
+	return d.In(Kilometers)
+}
+
+func (d Distance) Feet() float64 {
+	return d.In(Feet)
+}
+
+func (d Distance) Miles() float64 {
+	return d.In(Miles)
+}
+
+func (d Distance) NauticalMiles() float64 {
+	return d.In(NauticalMiles)
+}
+
+// String returns the distance in meters or kilometers, whichever reads better
+func (d Distance) String() string {
+	if d < 1000 && d > -1000 {
+		return fmt.Sprintf("%.1f m", float64(d))
+	}
+	return fmt.Sprintf("%.3f km", d.Kilometers())
+}
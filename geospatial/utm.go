@@ -0,0 +1,317 @@
+// GoBalloon
+// utm.go - Universal Transverse Mercator and MGRS conversions
+//
+// (c) 2014, Christopher Snell
+//
+// Transverse Mercator formulae are Karney's extension of Krüger's series, which are
+// accurate to within a few nanometers.  See www.movable-type.co.uk/scripts/latlong-utm-mgrs.html
+
+package geospatial
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// UTM is a position in Universal Transverse Mercator coordinates, in meters
+type UTM struct {
+	Zone     int
+	Band     byte // MGRS latitude band, C-X
+	North    bool // Northern hemisphere
+	Easting  float64
+	Northing float64
+}
+
+const (
+	utmScale         = 0.9996
+	utmFalseEasting  = 500000.0
+	utmFalseNorthing = 10000000.0
+)
+
+// MGRS latitude bands are 8° tall, starting at 80°S.  Band X is stretched to 84°N.
+const mgrsLatBands = "CDEFGHJKLMNPQRSTUVWXX"
+
+var (
+	mgrsE100kLetters = []string{"ABCDEFGH", "JKLMNPQR", "STUVWXYZ"}
+	mgrsN100kLetters = []string{"ABCDEFGHJKLMNPQRSTUV", "FGHJKLMNPQRSTUVABCDE"}
+)
+
+// Krüger series coefficients, computed once for the WGS-84 ellipsoid
+var (
+	utmE     = math.Sqrt(wgs84F * (2 - wgs84F))
+	utmN     = wgs84F / (2 - wgs84F)
+	utmA     = utmRectifyingRadius()
+	utmAlpha = utmAlphaSeries()
+	utmBeta  = utmBetaSeries()
+)
+
+func utmRectifyingRadius() float64 {
+	n2 := utmN * utmN
+	return wgs84A / (1 + utmN) * (1 + n2/4 + n2*n2/64 + n2*n2*n2/256)
+}
+
+func utmAlphaSeries() []float64 {
+	n := utmN
+	n2, n3, n4, n5, n6 := n*n, n*n*n, n*n*n*n, n*n*n*n*n, n*n*n*n*n*n
+	return []float64{0,
+		1.0/2*n - 2.0/3*n2 + 5.0/16*n3 + 41.0/180*n4 - 127.0/288*n5 + 7891.0/37800*n6,
+		13.0/48*n2 - 3.0/5*n3 + 557.0/1440*n4 + 281.0/630*n5 - 1983433.0/1935360*n6,
+		61.0/240*n3 - 103.0/140*n4 + 15061.0/26880*n5 + 167603.0/181440*n6,
+		49561.0/161280*n4 - 179.0/168*n5 + 6601661.0/7257600*n6,
+		34729.0/80640*n5 - 3418889.0/1995840*n6,
+		212378941.0 / 319334400 * n6,
+	}
+}
+
+func utmBetaSeries() []float64 {
+	n := utmN
+	n2, n3, n4, n5, n6 := n*n, n*n*n, n*n*n*n, n*n*n*n*n, n*n*n*n*n*n
+	return []float64{0,
+		1.0/2*n - 2.0/3*n2 + 37.0/96*n3 - 1.0/360*n4 - 81.0/512*n5 + 96199.0/604800*n6,
+		1.0/48*n2 + 1.0/15*n3 - 437.0/1440*n4 + 46.0/105*n5 - 1118711.0/3870720*n6,
+		17.0/480*n3 - 37.0/840*n4 - 209.0/4480*n5 + 5569.0/90720*n6,
+		4397.0/161280*n4 - 11.0/504*n5 - 830251.0/7257600*n6,
+		4583.0/161280*n5 - 108847.0/3991680*n6,
+		20648693.0 / 638668800 * n6,
+	}
+}
+
+// LatLonToUTM converts a position to UTM, including the Norway and Svalbard zone exceptions.
+// UTM isn't defined north of 84°N or south of 80°S.
+func LatLonToUTM(lat, lon float64) (UTM, error) {
+	if lat < -80 || lat > 84 {
+		return UTM{}, fmt.Errorf("Latitude %v is outside the UTM limits of 80°S to 84°N", lat)
+	}
+	if math.Abs(lon) > 180 {
+		return UTM{}, fmt.Errorf("Longitude %v is out of range", lon)
+	}
+
+	zone := int(math.Floor((lon+180)/6)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	band := mgrsLatBands[int(math.Floor(lat/8+10))]
+
+	// Norway's southwest coast is all in 32V
+	if zone == 31 && band == 'V' && lon >= 3 {
+		zone++
+	}
+
+	// Svalbard uses 9°, 12° and 9° wide zones 31X, 33X, 35X and 37X
+	if band == 'X' {
+		switch {
+		case zone == 32 && lon < 9:
+			zone--
+		case zone == 32:
+			zone++
+		case zone == 34 && lon < 21:
+			zone--
+		case zone == 34:
+			zone++
+		case zone == 36 && lon < 33:
+			zone--
+		case zone == 36:
+			zone++
+		}
+	}
+
+	λ0 := ToRadians(float64((zone-1)*6 - 180 + 3))
+	φ := ToRadians(lat)
+	λ := ToRadians(lon) - λ0
+
+	sinλ, cosλ := math.Sincos(λ)
+
+	τ := math.Tan(φ)
+	σ := math.Sinh(utmE * math.Atanh(utmE*τ/math.Sqrt(1+τ*τ)))
+	τʹ := τ*math.Sqrt(1+σ*σ) - σ*math.Sqrt(1+τ*τ)
+
+	ξʹ := math.Atan2(τʹ, cosλ)
+	ηʹ := math.Asinh(sinλ / math.Sqrt(τʹ*τʹ+cosλ*cosλ))
+
+	ξ := ξʹ
+	η := ηʹ
+	for j := 1; j <= 6; j++ {
+		ξ += utmAlpha[j] * math.Sin(2*float64(j)*ξʹ) * math.Cosh(2*float64(j)*ηʹ)
+		η += utmAlpha[j] * math.Cos(2*float64(j)*ξʹ) * math.Sinh(2*float64(j)*ηʹ)
+	}
+
+	x := utmScale*utmA*η + utmFalseEasting
+	y := utmScale * utmA * ξ
+	if y < 0 {
+		y += utmFalseNorthing
+	}
+
+	return UTM{Zone: zone, Band: band, North: lat >= 0, Easting: x, Northing: y}, nil
+}
+
+// LatLon converts UTM coordinates back to latitude and longitude
+func (u UTM) LatLon() (lat, lon float64) {
+	x := u.Easting - utmFalseEasting
+	y := u.Northing
+	if !u.North {
+		y -= utmFalseNorthing
+	}
+
+	η := x / (utmScale * utmA)
+	ξ := y / (utmScale * utmA)
+
+	ξʹ := ξ
+	ηʹ := η
+	for j := 1; j <= 6; j++ {
+		ξʹ -= utmBeta[j] * math.Sin(2*float64(j)*ξ) * math.Cosh(2*float64(j)*η)
+		ηʹ -= utmBeta[j] * math.Cos(2*float64(j)*ξ) * math.Sinh(2*float64(j)*η)
+	}
+
+	sinhηʹ := math.Sinh(ηʹ)
+	sinξʹ, cosξʹ := math.Sincos(ξʹ)
+
+	τʹ := sinξʹ / math.Sqrt(sinhηʹ*sinhηʹ+cosξʹ*cosξʹ)
+
+	// Solve for τ with Newton-Raphson.  This converges in two or three iterations.
+	e2 := utmE * utmE
+	τi := τʹ
+	for i := 0; i < 10; i++ {
+		σi := math.Sinh(utmE * math.Atanh(utmE*τi/math.Sqrt(1+τi*τi)))
+		τiʹ := τi*math.Sqrt(1+σi*σi) - σi*math.Sqrt(1+τi*τi)
+		δτi := (τʹ - τiʹ) / math.Sqrt(1+τiʹ*τiʹ) * (1 + (1-e2)*τi*τi) / ((1 - e2) * math.Sqrt(1+τi*τi))
+		τi += δτi
+		if math.Abs(δτi) < 1e-12 {
+			break
+		}
+	}
+
+	λ0 := ToRadians(float64((u.Zone-1)*6 - 180 + 3))
+
+	lat = ToDegrees(math.Atan(τi))
+	lon = ToDegrees(math.Atan2(sinhηʹ, cosξʹ) + λ0)
+
+	return lat, lon
+}
+
+// String returns the UTM coordinates in the usual zone+band, easting, northing form,
+// e.g. "10T 548323 5238713"
+func (u UTM) String() string {
+	return fmt.Sprintf("%d%c %.0f %.0f", u.Zone, u.Band, math.Floor(u.Easting), math.Floor(u.Northing))
+}
+
+var utmRegex = regexp.MustCompile(`^(\d{1,2})\s*([C-HJ-NP-Xc-hj-np-x])\s+(\d+(?:\.\d*)?)\s*m?E?\s+(\d+(?:\.\d*)?)\s*m?N?$`)
+
+// ParseUTM parses UTM coordinates such as "10T 548323 5238713".  The letter after the
+// zone may be an MGRS latitude band or simply N or S for the hemisphere.  Since N and S
+// are also latitude bands, they are treated as bands.
+func ParseUTM(s string) (UTM, error) {
+	m := utmRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return UTM{}, fmt.Errorf("Invalid UTM coordinates: %q", s)
+	}
+
+	u := UTM{}
+	u.Zone, _ = strconv.Atoi(m[1])
+	if u.Zone < 1 || u.Zone > 60 {
+		return UTM{}, fmt.Errorf("Invalid UTM zone: %v", u.Zone)
+	}
+	u.Band = upper(m[2][0])
+	u.North = u.Band >= 'N'
+	u.Easting, _ = strconv.ParseFloat(m[3], 64)
+	u.Northing, _ = strconv.ParseFloat(m[4], 64)
+
+	return u, nil
+}
+
+// LatLonToMGRS converts a position to a Military Grid Reference System reference with the
+// given number of digits (1-5) in each of the easting and northing, e.g. 5 digits gives
+// 1 m precision: "10T ET 48323 38713"
+func LatLonToMGRS(lat, lon float64, digits int) (string, error) {
+	if digits < 1 || digits > 5 {
+		return "", fmt.Errorf("MGRS precision must be 1-5 digits, not %v", digits)
+	}
+
+	u, err := LatLonToUTM(lat, lon)
+	if err != nil {
+		return "", err
+	}
+
+	col := int(math.Floor(u.Easting / 100000))
+	row := int(math.Floor(u.Northing/100000)) % 20
+
+	e100k := mgrsE100kLetters[(u.Zone-1)%3][col-1]
+	n100k := mgrsN100kLetters[(u.Zone-1)%2][row]
+
+	div := math.Pow(10, float64(5-digits))
+	easting := int(math.Floor(math.Mod(u.Easting, 100000) / div))
+	northing := int(math.Floor(math.Mod(u.Northing, 100000) / div))
+
+	return fmt.Sprintf("%02d%c %c%c %0*d %0*d", u.Zone, u.Band, e100k, n100k, digits, easting, digits, northing), nil
+}
+
+var mgrsRegex = regexp.MustCompile(`^(\d{1,2})([C-HJ-NP-X])\s*([A-HJ-NP-Z])([A-HJ-NP-V])\s*(\d*)\s*(\d*)$`)
+
+// ParseMGRS converts an MGRS reference such as "10T ET 48323 38713" or "10TET4832338713"
+// to UTM.  The result is the southwest corner of the referenced square.
+func ParseMGRS(s string) (UTM, error) {
+	m := mgrsRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(s)))
+	if m == nil {
+		return UTM{}, fmt.Errorf("Invalid MGRS reference: %q", s)
+	}
+
+	zone, _ := strconv.Atoi(m[1])
+	if zone < 1 || zone > 60 {
+		return UTM{}, fmt.Errorf("Invalid MGRS zone: %v", zone)
+	}
+	band := m[2][0]
+
+	e, n := m[5], m[6]
+	if n == "" {
+		// The digits were run together, so they're split evenly between easting and northing
+		if len(e)%2 != 0 {
+			return UTM{}, fmt.Errorf("MGRS reference has an odd number of digits: %q", s)
+		}
+		e, n = e[:len(e)/2], e[len(e)/2:]
+	}
+	if len(e) != len(n) || len(e) > 5 {
+		return UTM{}, fmt.Errorf("MGRS easting and northing must have the same number of digits (up to 5): %q", s)
+	}
+
+	col := strings.IndexByte(mgrsE100kLetters[(zone-1)%3], m[3][0])
+	row := strings.IndexByte(mgrsN100kLetters[(zone-1)%2], m[4][0])
+	if col < 0 || row < 0 {
+		return UTM{}, fmt.Errorf("Invalid MGRS 100 km square %v%v for zone %v", m[3], m[4], zone)
+	}
+
+	scale := math.Pow(10, float64(5-len(e)))
+	easting, northing := 0.0, 0.0
+	if len(e) > 0 {
+		ev, _ := strconv.Atoi(e)
+		nv, _ := strconv.Atoi(n)
+		easting = float64(ev) * scale
+		northing = float64(nv) * scale
+	}
+
+	e100k := float64(col+1) * 100000
+	n100k := float64(row) * 100000
+
+	// The row letters repeat every 2,000 km, so we use the latitude band to work out
+	// which cycle we're in
+	bandLat := float64((strings.IndexByte(mgrsLatBands, band) - 10) * 8)
+	bandUTM, err := LatLonToUTM(bandLat, float64((zone-1)*6-180+3))
+	if err != nil {
+		return UTM{}, err
+	}
+	nBand := math.Floor(bandUTM.Northing/100000) * 100000
+
+	n2M := 0.0
+	for n2M+n100k+northing < nBand {
+		n2M += 2000000
+	}
+
+	return UTM{
+		Zone:     zone,
+		Band:     band,
+		North:    band >= 'N',
+		Easting:  e100k + easting,
+		Northing: n2M + n100k + northing,
+	}, nil
+}
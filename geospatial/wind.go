@@ -0,0 +1,242 @@
+// GoBalloon
+// wind.go - Wind profile estimation from balloon drift
+//
+// (c) 2014, Christopher Snell
+
+package geospatial
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefaultWindBand is the thickness, in feet, of each layer in a WindProfile
+const DefaultWindBand = 1000
+
+const mpsToMPH = 2.236936
+
+// WindProfile accumulates the horizontal drift of a balloon between successive GPS fixes
+// and bins it by altitude.  A free balloon moves with the air around it, so its drift
+// at each altitude is the wind at that altitude.
+type WindProfile struct {
+	Band   float64 // Layer thickness in feet
+	mu     sync.Mutex
+	layers map[int]*windAccumulator
+	last   *Point
+}
+
+// WindLayer is the average wind observed within one altitude band
+type WindLayer struct {
+	Floor     float64 // Bottom of the layer, in feet
+	Ceiling   float64 // Top of the layer, in feet
+	Speed     float64 // Wind speed in mph
+	Direction float64 // Direction the wind is blowing from, in degrees
+	Samples   int     // Number of GPS fix pairs that went into this layer
+}
+
+// String returns a compact summary of the layer suitable for an APRS message,
+// e.g. "10-15kft 275@32mph"
+func (l WindLayer) String() string {
+	return fmt.Sprintf("%v-%vkft %03.0f@%.0fmph", formatKFeet(l.Floor), formatKFeet(l.Ceiling), l.Direction, l.Speed)
+}
+
+func formatKFeet(ft float64) string {
+	k := ft / 1000
+	if k == math.Trunc(k) {
+		return fmt.Sprintf("%.0f", k)
+	}
+	return fmt.Sprintf("%.1f", k)
+}
+
+// Running sums of the wind vector observed within one layer, in meters/sec
+type windAccumulator struct {
+	north   float64
+	east    float64
+	samples int
+}
+
+// NewWindProfile returns an empty profile with layers band feet thick
+func NewWindProfile(band float64) *WindProfile {
+	if band <= 0 {
+		band = DefaultWindBand
+	}
+	return &WindProfile{Band: band, layers: make(map[int]*windAccumulator)}
+}
+
+// Add records a new fix.  The drift since the previous fix is credited to the layer
+// at the mean altitude of the two.
+func (w *WindProfile) Add(p Point) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.layers == nil {
+		w.layers = make(map[int]*windAccumulator)
+	}
+	if w.Band <= 0 {
+		w.Band = DefaultWindBand
+	}
+
+	prev := w.last
+	cur := p
+	w.last = &cur
+
+	if prev == nil {
+		return
+	}
+
+	dt := p.Time.Sub(prev.Time).Seconds()
+	if dt <= 0 || dt > 300 {
+		// Fixes that are out of order or too far apart don't tell us anything useful
+		return
+	}
+
+	d := prev.HaversineDistanceTo(p).Meters()
+	b := ToRadians(prev.InitialBearingTo(p))
+	v := d / dt
+
+	l := w.layerIndex((prev.Altitude + p.Altitude) / 2)
+	a, ok := w.layers[l]
+	if !ok {
+		a = &windAccumulator{}
+		w.layers[l] = a
+	}
+	a.north += v * math.Cos(b)
+	a.east += v * math.Sin(b)
+	a.samples++
+}
+
+// WindAt returns the wind at the given altitude (feet) as a speed in meters/sec and the
+// direction it blows *from* in degrees.  Layers we never flew through take the wind
+// from the nearest layer we did.  ok is false if the profile is empty.
+func (w *WindProfile) WindAt(alt float64) (speed, direction float64, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.layers) == 0 {
+		return 0, 0, false
+	}
+
+	want := w.layerIndex(alt)
+	best := 0
+	bestDist := math.MaxInt32
+	for l := range w.layers {
+		dist := l - want
+		if dist < 0 {
+			dist = -dist
+		}
+		if dist < bestDist || (dist == bestDist && l < best) {
+			best = l
+			bestDist = dist
+		}
+	}
+
+	north, east := w.layers[best].mean()
+	speed, direction = windFromVector(north, east)
+	return speed, direction, true
+}
+
+// Velocity returns the air's motion at the given altitude (feet) as north and east
+// components in meters/sec.  This is the velocity a free balloon would drift at.
+func (w *WindProfile) Velocity(alt float64) (north, east float64, ok bool) {
+	speed, direction, ok := w.WindAt(alt)
+	if !ok {
+		return 0, 0, false
+	}
+	toward := ToRadians(direction + 180)
+	return speed * math.Cos(toward), speed * math.Sin(toward), true
+}
+
+// Layers returns every layer we've observed, lowest first
+func (w *WindProfile) Layers() []WindLayer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.layersLocked(w.Band)
+}
+
+// Summary re-bins the profile into coarser layers, band feet thick, by averaging the
+// wind vectors of the finer layers within each.  This is handy for sending the profile
+// over a slow radio link.
+func (w *WindProfile) Summary(band float64) []WindLayer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if band < w.Band {
+		band = w.Band
+	}
+
+	return w.layersLocked(band)
+}
+
+func (w *WindProfile) layersLocked(band float64) []WindLayer {
+	coarse := make(map[int]*windAccumulator)
+
+	for l, a := range w.layers {
+		c := int(math.Floor(float64(l) * w.Band / band))
+		ca, ok := coarse[c]
+		if !ok {
+			ca = &windAccumulator{}
+			coarse[c] = ca
+		}
+		ca.north += a.north
+		ca.east += a.east
+		ca.samples += a.samples
+	}
+
+	var idx []int
+	for c := range coarse {
+		idx = append(idx, c)
+	}
+	sort.Ints(idx)
+
+	layers := make([]WindLayer, 0, len(idx))
+	for _, c := range idx {
+		north, east := coarse[c].mean()
+		speed, direction := windFromVector(north, east)
+		layers = append(layers, WindLayer{
+			Floor:     float64(c) * band,
+			Ceiling:   float64(c+1) * band,
+			Speed:     speed * mpsToMPH,
+			Direction: direction,
+			Samples:   coarse[c].samples,
+		})
+	}
+
+	return layers
+}
+
+// WriteCSV writes the profile as CSV with the columns floor_ft,ceiling_ft,speed_mph,direction_deg,samples
+func (w *WindProfile) WriteCSV(out io.Writer) error {
+	_, err := fmt.Fprintln(out, "floor_ft,ceiling_ft,speed_mph,direction_deg,samples")
+	if err != nil {
+		return err
+	}
+
+	for _, l := range w.Layers() {
+		_, err = fmt.Fprintf(out, "%.0f,%.0f,%.1f,%.0f,%d\n", l.Floor, l.Ceiling, l.Speed, l.Direction, l.Samples)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *WindProfile) layerIndex(alt float64) int {
+	return int(math.Floor(alt / w.Band))
+}
+
+func (a *windAccumulator) mean() (north, east float64) {
+	return a.north / float64(a.samples), a.east / float64(a.samples)
+}
+
+// windFromVector converts the direction of air motion into a meteorological wind:
+// speed and the direction the wind is coming *from*
+func windFromVector(north, east float64) (speed, direction float64) {
+	speed = math.Hypot(north, east)
+	direction = math.Mod(ToDegrees(math.Atan2(east, north))+180+360, 360)
+	return speed, direction
+}
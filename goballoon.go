@@ -7,13 +7,18 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
 	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/flight"
 	"github.com/chrissnell/GoBalloon/geospatial"
 	"github.com/chrissnell/GoBalloon/gps"
+	"github.com/chrissnell/GoBalloon/tnc"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 )
@@ -22,6 +27,7 @@ var (
 	shutdownFlight = make(chan bool)
 	aprsMessage    = make(chan string)
 	aprsPosition   = make(chan geospatial.Point)
+	aprsObject     = make(chan aprs.Object)
 	remotegps      *string
 	remotetnc      *string
 	localtncport   *string
@@ -30,6 +36,40 @@ var (
 	chasercall     *string
 	chaserssid     *string
 	beaconint      *string
+	chutemass      *float64
+	chutediameter  *float64
+	chutedrag      *float64
+	windlog        *string
+	windreport     *float64
+	gpsreplay      *string
+	gpsspeed       *float64
+	gpssim         *bool
+	simlaunch      *string
+	simascent      *float64
+	simburst       *float64
+	simdescent     *float64
+	simwindspeed   *float64
+	simwinddir     *float64
+	igateserver    *string
+	igatecall      *string
+	igatepass      *string
+	groundmode     *bool
+	groundis       *string
+	digipeat       *bool
+	digialt        *float64
+	digimaxhops    *int
+	digidelay      *float64
+	digirate       *int
+	digialiases    *string
+	tnclinks       *string
+	baud           *int
+	kissport       *int
+	txdelay        *int
+	persist        *int
+	slottime       *int
+	txtail         *int
+	fullduplex     *optionalBool
+	kissexit       *bool
 	debug          *bool
 	balloonAddr    ax25.APRSAddress
 )
@@ -54,20 +94,69 @@ func main() {
 	g.Remotegps = flag.String("remotegps", "10.50.0.21:2947", "Remote gpsd server")
 	a.Remotetnc = flag.String("remotetnc", "10.50.0.25:6700", "Remote TNC server")
 	a.Localtncport = flag.String("localtncport", "", "Local serial port for TNC, e.g. /dev/ttyUSB0")
+	a.AGWPE = flag.String("agwpe", "", fmt.Sprintf("AGWPE server of a soundcard modem such as Direwolf or SoundModem, e.g. localhost:%v", ax25.DefaultAGWPEPort))
 	ballooncall = flag.String("ballooncall", "", "Balloon Callsign")
 	balloonssid = flag.String("balloonssid", "", "Balloon SSID")
 	chasercall = flag.String("chasercall", "", "Chaser Callsign")
 	chaserssid = flag.String("chaserssid", "", "Chaser SSID")
 	a.Beaconint = flag.String("beaconint", "60", "APRS position beacon interval (secs)  Default: 60")
+	chutemass = flag.Float64("chutemass", 1.8, "Total mass falling under the parachute (kg): payload, chute and what's left of the balloon")
+	chutediameter = flag.Float64("chutediameter", 1.2, "Parachute canopy diameter (m)")
+	chutedrag = flag.Float64("chutedrag", 1.5, "Parachute drag coefficient")
+	windlog = flag.String("windlog", "", "File to log the observed wind profile to (CSV)")
+	windreport = flag.Float64("windreport", 5000, "Message the wind profile to the chaser in layers this many feet thick (0 to disable)")
+	gpsreplay = flag.String("gpsreplay", "", "Replay a recorded flight (gpsd JSON, NMEA, CSV or GPX file) instead of using gpsd")
+	gpssim = flag.Bool("gpssim", false, "Fly a simulated balloon instead of using gpsd")
+	gpsspeed = flag.Float64("gpsspeed", 1, "Speed multiplier for -gpsreplay and -gpssim")
+	simlaunch = flag.String("simlaunch", "47.2101,-122.4818,100", "Simulated launch point: lat,lon,altitude (m)")
+	simascent = flag.Float64("simascent", 5, "Simulated ascent rate (m/s)")
+	simburst = flag.Float64("simburst", 30000, "Simulated burst altitude (m)")
+	simdescent = flag.Float64("simdescent", 5, "Simulated sea-level descent rate (m/s)")
+	simwindspeed = flag.Float64("simwindspeed", 4, "Simulated surface wind speed (m/s)")
+	simwinddir = flag.Float64("simwinddir", 270, "Simulated surface wind direction (degrees from)")
+	igateserver = flag.String("igate", "", "Gate packets heard by the TNC to APRS-IS.  A comma-separated list of servers to fail over between, e.g. rotate.aprs2.net:14580,noam.aprs2.net:14580")
+	igatecall = flag.String("igatecall", "", "Callsign to log in to APRS-IS with (defaults to the chaser callsign)")
+	igatepass = flag.String("igatepass", "", "APRS-IS passcode (computed from -igatecall if not given)")
+	groundmode = flag.Bool("ground", false, "Run as a chase vehicle / ground station that tracks the balloon instead of flying it")
+	groundis = flag.String("groundis", "", "In -ground mode, also follow the balloon on APRS-IS.  A comma-separated list of servers, e.g. rotate.aprs2.net:14580")
+	digipeat = flag.Bool("digi", false, "Digipeat packets heard by the TNC while aloft")
+	digialt = flag.Float64("digialt", 3000, "Only digipeat this high above the launch site (ft)")
+	digimaxhops = flag.Int("digimaxhops", ax25.DefaultDigiMaxHops, "Largest WIDEn-N hop count to digipeat")
+	digidelay = flag.Float64("digidelay", 0, "Viscous delay (secs): hold packets this long and only repeat them if no other digipeater does")
+	digirate = flag.Int("digirate", ax25.DefaultDigiRateLimit, "Maximum packets to digipeat per minute (0 for no limit)")
+	digialiases = flag.String("digialiases", "", "Comma-separated aliases to digipeat for in addition to our callsign and WIDEn-N, e.g. BLN,RELAY")
+	tnclinks = flag.String("tnc", "", "TNC links to use at once, comma-separated, each kind:address[#port][?params][@tx].  kind is serial, tcp or agwpe, port is the radio's KISS or AGWPE port (default -kissport), params are KISS settings for that radio like txdelay=300&persist=63 (defaults from -txdelay etc.) and tx is primary (the default), backup or rx, e.g. serial:/dev/ttyUSB0,serial:/dev/ttyUSB0#1@backup,tcp:10.50.0.26:8001@rx.  Links on the same TNC share one connection to it.  Overrides -remotetnc, -localtncport and -agwpe.")
+	baud = flag.Int("baud", tnc.DefaultSerialBaud, "Speed of serial TNCs")
+	kissport = flag.Int("kissport", 0, "KISS or AGWPE port (0-15) of the radio to use on a multi-port TNC, for links that don't give one")
+	txdelay = flag.Int("txdelay", -1, "Set the TNC's TXDELAY (ms) when connecting (-1 to leave it alone)")
+	persist = flag.Int("persist", -1, "Set the TNC's p-persistence (0-255) when connecting (-1 to leave it alone)")
+	slottime = flag.Int("slottime", -1, "Set the TNC's slot time (ms) when connecting (-1 to leave it alone)")
+	txtail = flag.Int("txtail", -1, "Set the TNC's TXtail (ms) when connecting (-1 to leave it alone)")
+	fullduplex = &optionalBool{}
+	flag.Var(fullduplex, "fullduplex", "Put the TNC in full duplex mode (or half duplex with -fullduplex=false) when connecting")
+	kissexit = flag.Bool("kissexit", false, "Take the TNC out of KISS mode when shutting down")
 	debug = flag.Bool("debug", false, "Enable debugging information")
 
 	flag.Parse()
 
 	g.Debug = debug
 
+	if len(*gpsreplay) > 0 {
+		r, err := gps.NewReplay(*gpsreplay, *gpsspeed)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		r.Debug = *debug
+		g.Replay = r
+	} else if *gpssim {
+		g.Simulator = newFlightSimulator()
+	}
+
 	log.Println("Starting up.")
 
-	if (len(*a.Remotetnc) == 0) && (len(*a.Localtncport) == 0) {
+	haveTNC := len(*tnclinks) > 0 || len(*a.Remotetnc) > 0 || len(*a.Localtncport) > 0 || len(*a.AGWPE) > 0
+
+	if !haveTNC && !(*groundmode && len(*groundis) > 0) {
 		log.Fatalln("Must specify a local or remote TNC.  Use -h for help.")
 	}
 
@@ -79,14 +168,60 @@ func main() {
 		log.Fatalln("Must provide a chaser callsign.  Use -h for help.")
 	}
 
+	if *chutemass <= 0 || *chutediameter <= 0 || *chutedrag <= 0 {
+		log.Fatalln("Parachute mass, diameter and drag coefficient must be greater than zero.  Use -h for help.")
+	}
+
 	balloonAddr.Callsign = *ballooncall
 	ssidInt, _ := strconv.Atoi(*balloonssid)
 	balloonAddr.SSID = uint8(ssidInt)
 
+	chaserLogin := *chasercall
+	if len(*chaserssid) > 0 {
+		chaserLogin = fmt.Sprintf("%v-%v", chaserLogin, *chaserssid)
+	}
+
+	if len(*igateserver) > 0 {
+		call := *igatecall
+		if len(call) == 0 {
+			call = chaserLogin
+		}
+		a.igate = newIGateLink(*igateserver, call, *igatepass, *debug)
+		go a.igate.Run()
+	}
+
 	sc := make(chan os.Signal, 2)
 	signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT)
 
-	go FlightComputer(&g.Reading, &wg)
+	if *groundmode {
+		// The GPS is the chase vehicle's, so we can point at the balloon
+		go g.StartGPS()
+		a.gps = &g.Reading
+		a.ground = flight.NewGroundStation(balloonAddr, &g.Reading, parachute())
+		if len(*groundis) > 0 {
+			go a.ground.FollowOnAPRSIS(*groundis, chaserLogin)
+		}
+		if haveTNC {
+			go a.StartAPRS()
+		}
+		<-sc
+		log.Println("Shutting down.")
+		a.exitKISS()
+		return
+	}
+
+	// The landing predictor also keeps the altitude of the launch site, which the
+	// digipeater needs to know when we're aloft
+	wind := geospatial.NewWindProfile(geospatial.DefaultWindBand)
+	a.landing = flight.NewLandingPredictor(wind, parachute())
+
+	if *digipeat {
+		a.digi = a.newDigipeater()
+	}
+	a.commands = a.newCommands(InitiateCutdown)
+
+	wg.Add(1)
+	go FlightComputer(&g.Reading, wind, a.landing, &wg)
 	go CameraRun()
 	go g.StartGPS()
 	a.gps = &g.Reading
@@ -96,5 +231,43 @@ func main() {
 	close(shutdownFlight)
 	log.Println("Shutting down.")
 	wg.Wait()
+	a.exitKISS()
 	log.Println("Shutdown complete.")
 }
+
+// newFlightSimulator builds a GPS simulator from the -sim* flags
+func newFlightSimulator() *gps.Simulator {
+	var launch geospatial.Point
+	var err error
+
+	parts := strings.Split(*simlaunch, ",")
+	if len(parts) != 3 {
+		log.Fatalln("Simulated launch point must be in the form lat,lon,altitude")
+	}
+
+	launch.Lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		log.Fatalf("Invalid simulated launch latitude: %v\n", err)
+	}
+	launch.Lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		log.Fatalf("Invalid simulated launch longitude: %v\n", err)
+	}
+	launch.Altitude, err = strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+	if err != nil {
+		log.Fatalf("Invalid simulated launch altitude: %v\n", err)
+	}
+	// Points carry altitude in feet
+	launch.Altitude = launch.Altitude * 3.28084
+
+	s := gps.NewSimulator(launch)
+	s.AscentRate = *simascent
+	s.BurstAltitude = *simburst
+	s.DescentRate = *simdescent
+	s.WindSpeed = *simwindspeed
+	s.WindDirection = *simwinddir
+	s.Speed = *gpsspeed
+	s.Debug = *debug
+
+	return s
+}
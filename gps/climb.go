@@ -0,0 +1,93 @@
+// GoBalloon
+// climb.go - Vertical rate estimation from a stream of GPS fixes
+//
+// (c) 2014, Christopher Snell
+
+package gps
+
+import (
+	"time"
+)
+
+// DefaultClimbWindow is how much GPS history is used to estimate the vertical rate.
+// GPS altitude is noisy to a few tens of feet from fix to fix; thirty seconds of fixes
+// is enough to smooth that out without hiding a burst for too long.
+const DefaultClimbWindow = 30 * time.Second
+
+type altitudeSample struct {
+	t   time.Time
+	alt float64
+}
+
+// ClimbEstimator computes a filtered vertical rate by fitting a least-squares line to
+// the altitudes seen over a sliding window of time
+type ClimbEstimator struct {
+	Window  time.Duration
+	samples []altitudeSample
+}
+
+// Add records an altitude (feet) taken at time t and returns the current vertical rate in
+// feet per minute.  ok is false until the window holds enough fixes to trust the estimate.
+func (c *ClimbEstimator) Add(t time.Time, alt float64) (rate float64, ok bool) {
+	window := c.Window
+	if window == 0 {
+		window = DefaultClimbWindow
+	}
+
+	// Fixes that arrive out of order, or a clock that jumps backwards, invalidate our history
+	if n := len(c.samples); n > 0 && !t.After(c.samples[n-1].t) {
+		c.samples = c.samples[:0]
+	}
+
+	c.samples = append(c.samples, altitudeSample{t, alt})
+
+	// Age out samples that have fallen out of the window
+	cutoff := t.Add(-window)
+	i := 0
+	for i < len(c.samples) && c.samples[i].t.Before(cutoff) {
+		i++
+	}
+	c.samples = c.samples[i:]
+
+	return c.Rate()
+}
+
+// Rate returns the vertical rate in feet per minute over the current window
+func (c *ClimbEstimator) Rate() (rate float64, ok bool) {
+	n := len(c.samples)
+	if n < 3 {
+		return 0, false
+	}
+
+	span := c.samples[n-1].t.Sub(c.samples[0].t)
+	if span < 2*time.Second {
+		return 0, false
+	}
+
+	// Ordinary least squares on (seconds since the first sample, altitude)
+	var sumT, sumA, sumTT, sumTA float64
+	t0 := c.samples[0].t
+	for _, s := range c.samples {
+		x := s.t.Sub(t0).Seconds()
+		sumT += x
+		sumA += s.alt
+		sumTT += x * x
+		sumTA += x * s.alt
+	}
+
+	fn := float64(n)
+	denom := fn*sumTT - sumT*sumT
+	if denom == 0 {
+		return 0, false
+	}
+
+	slope := (fn*sumTA - sumT*sumA) / denom
+
+	// Our slope is in feet per second
+	return slope * 60, true
+}
+
+// Reset discards all history
+func (c *ClimbEstimator) Reset() {
+	c.samples = c.samples[:0]
+}
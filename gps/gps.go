@@ -22,11 +22,15 @@ type GPS struct {
 	reader          *bufio.Reader
 	Reading         GPSReading
 	Remotegps       *string
+	Replay          *Replay
+	Simulator       *Simulator
 	connecting      bool
 	connectingMutex sync.Mutex
 	ready           bool
 	readyMutex      sync.Mutex
 	msg             chan string
+	stop            chan struct{}
+	stopMutex       sync.Mutex
 	Debug           *bool
 }
 
@@ -56,13 +60,19 @@ type TPVSentence struct {
 }
 
 type GPSReading struct {
-	mu  sync.Mutex
-	pos geospatial.Point
+	mu    sync.Mutex
+	pos   geospatial.Point
+	climb ClimbEstimator
 }
 
+// Set saves a new position.  The position's Climb is replaced with the filtered vertical
+// rate once enough fixes have been seen; until then, whatever the source reported is kept.
 func (gr *GPSReading) Set(pos geospatial.Point) {
 	gr.mu.Lock()
 	defer gr.mu.Unlock()
+	if rate, ok := gr.climb.Add(pos.Time, pos.Altitude); ok {
+		pos.Climb = rate
+	}
 	gr.pos = pos
 }
 
@@ -84,11 +94,63 @@ func (g *GPS) Ready(r bool) {
 	g.ready = r
 }
 
+// Stop hangs up on gpsd and stops trying to reconnect.  A replay or simulation runs on
+// to the end.
+func (g *GPS) Stop() {
+	stop := g.done()
+
+	g.stopMutex.Lock()
+	select {
+	case <-stop:
+	default:
+		close(stop)
+	}
+	g.stopMutex.Unlock()
+
+	g.Ready(false)
+
+	g.connectingMutex.Lock()
+	if g.conn != nil {
+		g.conn.Close()
+	}
+	g.connectingMutex.Unlock()
+}
+
+// done returns a channel that's closed when Stop is called
+func (g *GPS) done() chan struct{} {
+	g.stopMutex.Lock()
+	defer g.stopMutex.Unlock()
+	if g.stop == nil {
+		g.stop = make(chan struct{})
+	}
+	return g.stop
+}
+
+func (g *GPS) stopped() bool {
+	select {
+	case <-g.done():
+		return true
+	default:
+		return false
+	}
+}
+
 func (g *GPS) StartGPS() {
 	log.Println("GPS.StartGPS()")
 
 	g.msg = make(chan string)
 
+	// A recorded or simulated flight takes the place of gpsd for ground testing
+	if g.Replay != nil {
+		go g.Replay.Run(&g.Reading)
+		return
+	}
+
+	if g.Simulator != nil {
+		go g.Simulator.Run(&g.Reading)
+		return
+	}
+
 	// Set up a new connection to the GPS
 	g.connectToNetworkGPS()
 
@@ -116,14 +178,39 @@ func (g *GPS) connectToNetworkGPS() {
 		g.connecting = true
 		g.connectingMutex.Unlock()
 
+		// Hang up on gpsd if we're reconnecting so the old connection doesn't linger
+		if g.conn != nil {
+			g.conn.Close()
+		}
+
 		log.Println("Connecting to remote GPS ", *g.Remotegps)
 
 		for {
-			g.conn, err = net.Dial("tcp", *g.Remotegps)
+			var conn net.Conn
+			conn, err = net.Dial("tcp", *g.Remotegps)
+
+			// Don't leave a connection open behind Stop
+			g.connectingMutex.Lock()
+			if g.stopped() {
+				if err == nil {
+					conn.Close()
+				}
+				g.connecting = false
+				g.connectingMutex.Unlock()
+				return
+			}
+			if err == nil {
+				g.conn = conn
+			}
+			g.connectingMutex.Unlock()
+
 			if err != nil {
 				log.Printf("Could not connect to %v.  Error: %v", *g.Remotegps, err)
 				log.Println("Sleeping 5 seconds and trying again")
-				time.Sleep(5 * time.Second)
+				select {
+				case <-time.After(5 * time.Second):
+				case <-g.done():
+				}
 			} else {
 				log.Printf("Connection to GPS %v successful", g.conn.RemoteAddr())
 				g.conn.SetReadDeadline(time.Now().Add(time.Second * 15))
@@ -159,8 +246,15 @@ func (g *GPS) incomingJSONHandler() {
 	log.Println("GPS.incomingJSONHandler()")
 
 	for {
+		if g.stopped() {
+			return
+		}
+
 		if g.IsReady() {
 			line, err := g.reader.ReadString('\n')
+			if err != nil && g.stopped() {
+				return
+			}
 			if err != nil {
 				g.Ready(false)
 				log.Printf("Error retrieving JSON message from GPS: %v", err)
@@ -174,7 +268,11 @@ func (g *GPS) incomingJSONHandler() {
 
 			// If we made it this far, we've successfully read a line so we send it over
 			// the msg channel to be decoded elsewhere
-			g.msg <- line
+			select {
+			case g.msg <- line:
+			case <-g.done():
+				return
+			}
 		}
 	}
 }
@@ -185,10 +283,13 @@ func (g *GPS) processJSONSentences() {
 
 	for {
 		select {
+		case <-g.done():
+			return
+
 		case m := <-g.msg:
 			err := json.Unmarshal([]byte(m), &classify)
 			if err != nil {
-				log.Println("ERROR: Could not unmarshal sentence %v", err)
+				log.Printf("ERROR: Could not unmarshal sentence %v\n", err)
 				continue
 			}
 
@@ -208,7 +309,8 @@ func (g *GPS) processJSONSentences() {
 				}
 
 				// Build our Point, converting altitude from meters to feet and speed from meters/sec to mph
-				pos := geospatial.Point{Lon: tpv.Lon, Lat: tpv.Lat, Altitude: tpv.Alt * 3.28084, Speed: tpv.Speed * 2.236936, Heading: uint16(tpv.Track), Time: time.Now()}
+				pos := pointFromTPV(tpv)
+				pos.Time = time.Now()
 
 				if pos.Lat != 0 {
 					if *g.Debug {
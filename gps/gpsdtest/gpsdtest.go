@@ -0,0 +1,351 @@
+// GoBalloon
+// gpsdtest.go - An in-process gpsd emulator for exercising the GPS client without hardware
+//
+// (c) 2014, Christopher Snell
+
+package gpsdtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type stepKind int
+
+const (
+	stepLine stepKind = iota
+	stepPause
+	stepDisconnect
+	stepSlow
+)
+
+// Step is one action in a Server's script
+type Step struct {
+	kind  stepKind
+	line  string
+	delay time.Duration
+}
+
+// Line sends a raw line to the client, exactly as given
+func Line(s string) Step {
+	return Step{kind: stepLine, line: s}
+}
+
+// Malformed sends a line that isn't valid gpsd JSON.  It's identical to Line but
+// makes scripts easier to read.
+func Malformed(s string) Step {
+	return Step{kind: stepLine, line: s}
+}
+
+// TPV sends a 3D-fix TPV sentence.  Altitude is in meters and speed in meters/sec, as gpsd reports them.
+func TPV(lat, lon, alt float64, speed float32, track float64) Step {
+	tpv := map[string]interface{}{
+		"class":  "TPV",
+		"device": "/dev/ttyGPS",
+		"mode":   3,
+		"time":   time.Now().UTC().Format(time.RFC3339Nano),
+		"lat":    lat,
+		"lon":    lon,
+		"alt":    alt,
+		"speed":  speed,
+		"track":  track,
+	}
+	b, _ := json.Marshal(tpv)
+	return Line(string(b))
+}
+
+// SKY sends a SKY sentence reporting the given number of satellites, all of them used in the fix
+func SKY(satellites int) Step {
+	type sat struct {
+		PRN  int     `json:"PRN"`
+		El   int     `json:"el"`
+		Az   int     `json:"az"`
+		SS   float64 `json:"ss"`
+		Used bool    `json:"used"`
+	}
+	sky := struct {
+		Class      string `json:"class"`
+		Device     string `json:"device"`
+		Satellites []sat  `json:"satellites"`
+	}{Class: "SKY", Device: "/dev/ttyGPS"}
+
+	for i := 0; i < satellites; i++ {
+		sky.Satellites = append(sky.Satellites, sat{PRN: i + 1, El: 45, Az: (i * 37) % 360, SS: 40, Used: true})
+	}
+	b, _ := json.Marshal(sky)
+	return Line(string(b))
+}
+
+// Pause waits before moving on to the next step.  A pause longer than the client's read
+// deadline looks like a stalled gpsd.
+func Pause(d time.Duration) Step {
+	return Step{kind: stepPause, delay: d}
+}
+
+// Disconnect drops the client's connection.  The script picks up where it left off when
+// the client reconnects and re-sends its WATCH.
+func Disconnect() Step {
+	return Step{kind: stepDisconnect}
+}
+
+// Slow sends a line one byte at a time, waiting perByte between bytes
+func Slow(s string, perByte time.Duration) Step {
+	return Step{kind: stepSlow, line: s, delay: perByte}
+}
+
+// Server is a fake gpsd.  It listens on a loopback port, answers ?WATCH the way gpsd does
+// and then plays its script to whichever client is watching.
+type Server struct {
+	ln          net.Listener
+	script      []Step
+	mu          sync.Mutex
+	next        int
+	connections int
+	watches     int
+	done        chan struct{}
+	closing     chan struct{}
+	wg          sync.WaitGroup
+	Debug       bool
+}
+
+// gpsd commands look like ?WATCH={"enable":true}; but clients don't always send the
+// terminating semicolon or newline
+var commandRegex = regexp.MustCompile(`\?(\w+)(=(\{[^}]*\}))?;?`)
+
+// NewServer starts a fake gpsd on a random loopback port that will play the given script
+func NewServer(script ...Step) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Could not start fake gpsd: %v", err)
+	}
+
+	s := &Server{
+		ln:      ln,
+		script:  script,
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+
+	if len(script) == 0 {
+		close(s.done)
+	}
+
+	s.wg.Add(1)
+	go s.acceptConnections()
+
+	return s, nil
+}
+
+// Addr returns the host:port the server is listening on
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Connections returns the number of client connections accepted so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Watches returns the number of ?WATCH commands that enabled streaming
+func (s *Server) Watches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watches
+}
+
+// Done is closed once the last step of the script has been started
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close shuts down the listener and any client connections
+func (s *Server) Close() error {
+	close(s.closing)
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptConnections() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.connections++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	// Make sure a blocked read or write gets kicked loose when we're shut down
+	go func() {
+		<-s.closing
+		conn.Close()
+	}()
+
+	s.logf("Client connected from %v", conn.RemoteAddr())
+
+	fmt.Fprintf(conn, "{\"class\":\"VERSION\",\"release\":\"3.11\",\"rev\":\"3.11\",\"proto_major\":3,\"proto_minor\":11}\n")
+
+	r := bufio.NewReader(conn)
+	if !s.waitForWatch(conn, r) {
+		return
+	}
+
+	// Keep draining the client so that we notice when it hangs up on us
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, r)
+		close(gone)
+	}()
+
+	fmt.Fprintf(conn, "{\"class\":\"DEVICES\",\"devices\":[{\"class\":\"DEVICE\",\"path\":\"/dev/ttyGPS\",\"driver\":\"u-blox\",\"activated\":\"%s\",\"native\":1,\"bps\":9600}]}\n",
+		time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(conn, "{\"class\":\"WATCH\",\"enable\":true,\"json\":true,\"nmea\":false,\"raw\":0,\"scaled\":false,\"timing\":false}\n")
+
+	for {
+		select {
+		case <-gone:
+			s.logf("Client %v hung up", conn.RemoteAddr())
+			return
+		default:
+		}
+
+		step, ok := s.nextStep()
+		if !ok {
+			// Script's over.  Hold the connection open, like an idle gpsd would.
+			select {
+			case <-gone:
+			case <-s.closing:
+			}
+			return
+		}
+
+		switch step.kind {
+		case stepLine:
+			s.logf("Sending: %v", step.line)
+			if _, err := fmt.Fprintf(conn, "%s\n", step.line); err != nil {
+				return
+			}
+
+		case stepSlow:
+			s.logf("Sending slowly: %v", step.line)
+			for _, b := range []byte(step.line + "\n") {
+				if _, err := conn.Write([]byte{b}); err != nil {
+					return
+				}
+				time.Sleep(step.delay)
+			}
+
+		case stepPause:
+			select {
+			case <-time.After(step.delay):
+			case <-gone:
+				return
+			case <-s.closing:
+				return
+			}
+
+		case stepDisconnect:
+			s.logf("Disconnecting client %v", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// waitForWatch reads commands from the client until it enables watching.  It returns false
+// if the client goes away first.
+func (s *Server) waitForWatch(conn net.Conn, r *bufio.Reader) bool {
+	var buf []byte
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		buf = append(buf, b)
+
+		m := commandRegex.FindSubmatch(buf)
+		if m == nil {
+			continue
+		}
+
+		// A WATCH with an argument isn't complete until we've seen its closing brace
+		if b != '}' && b != ';' && b != '\n' {
+			continue
+		}
+
+		buf = buf[:0]
+
+		s.logf("Received command: %s", m[0])
+
+		switch string(m[1]) {
+		case "WATCH":
+			var args struct {
+				Enable *bool `json:"enable"`
+			}
+			if len(m[3]) > 0 {
+				if err := json.Unmarshal(m[3], &args); err != nil {
+					fmt.Fprintf(conn, "{\"class\":\"ERROR\",\"message\":\"Invalid WATCH: %v\"}\n", err)
+					continue
+				}
+			}
+			if args.Enable == nil || *args.Enable {
+				s.mu.Lock()
+				s.watches++
+				s.mu.Unlock()
+				return true
+			}
+		case "VERSION":
+			fmt.Fprintf(conn, "{\"class\":\"VERSION\",\"release\":\"3.11\",\"rev\":\"3.11\",\"proto_major\":3,\"proto_minor\":11}\n")
+		default:
+			fmt.Fprintf(conn, "{\"class\":\"ERROR\",\"message\":\"Unrecognized request '%s'\"}\n", m[1])
+		}
+	}
+}
+
+// nextStep hands out the next step of the script, closing Done when there are no more
+func (s *Server) nextStep() (Step, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next >= len(s.script) {
+		return Step{}, false
+	}
+
+	step := s.script[s.next]
+	s.next++
+
+	if s.next == len(s.script) {
+		close(s.done)
+	}
+
+	return step, true
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Debug {
+		log.Printf("gpsdtest: "+format+"\n", v...)
+	}
+}
@@ -0,0 +1,72 @@
+// GoBalloon
+// replay.go - Plays back a recorded flight in place of a live gpsd
+//
+// (c) 2014, Christopher Snell
+
+package gps
+
+import (
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"log"
+	"time"
+)
+
+// Replay feeds the fixes from a recorded flight into a GPSReading, keeping the
+// original spacing between fixes.  A Speed of 10 plays the flight back ten times
+// faster than it was flown.
+type Replay struct {
+	Track []geospatial.Point
+	Speed float64
+	Debug bool
+}
+
+// NewReplay loads a recorded flight for playback.  See LoadTrack for the supported formats.
+func NewReplay(filename string, speed float64) (*Replay, error) {
+	track, err := openTrack(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if speed <= 0 {
+		speed = 1
+	}
+
+	return &Replay{Track: track, Speed: speed}, nil
+}
+
+// Run plays the track into r and returns after the last fix has been set.  Fix times
+// are shifted so that the first fix happens now; time between fixes is preserved
+// in the timestamps even when playback is accelerated.
+func (rp *Replay) Run(r *GPSReading) {
+	log.Printf("Replaying %v GPS fixes at %vx speed\n", len(rp.Track), rp.Speed)
+
+	start := time.Now()
+	first := rp.Track[0].Time
+
+	for i, p := range rp.Track {
+		if i > 0 {
+			// Tracks without timestamps are played back at one fix per second
+			gap := time.Second
+			if !p.Time.IsZero() && !rp.Track[i-1].Time.IsZero() {
+				gap = p.Time.Sub(rp.Track[i-1].Time)
+			}
+			if gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / rp.Speed))
+			}
+		}
+
+		if p.Time.IsZero() || first.IsZero() {
+			p.Time = start.Add(time.Duration(i) * time.Second)
+		} else {
+			p.Time = start.Add(p.Time.Sub(first))
+		}
+
+		if rp.Debug {
+			log.Printf("Replaying position: %v\n", p)
+		}
+
+		r.Set(p)
+	}
+
+	log.Println("GPS replay complete")
+}
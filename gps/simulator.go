@@ -0,0 +1,126 @@
+// GoBalloon
+// simulator.go - Generates a synthetic balloon flight for testing on the ground
+//
+// (c) 2014, Christopher Snell
+
+package gps
+
+import (
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"log"
+	"math"
+	"time"
+)
+
+// Simulator flies a synthetic balloon: a constant-rate ascent to burst followed by a
+// descent under parachute, drifting with the wind the whole way.  Rates are in
+// meters/sec and altitudes in meters.  The launch point's Altitude is in feet, like
+// every other Point.
+type Simulator struct {
+	Launch        geospatial.Point
+	AscentRate    float64
+	BurstAltitude float64
+	DescentRate   float64       // Descent rate at sea level.  The balloon falls faster in thinner air.
+	WindSpeed     float64       // Surface wind speed, in meters/sec
+	WindDirection float64       // Direction the surface wind is blowing from, in degrees
+	Interval      time.Duration // Simulated time between fixes
+	Speed         float64       // Playback speed multiplier
+	Debug         bool
+}
+
+// NewSimulator returns a Simulator with the typical numbers for a 600g latex balloon
+func NewSimulator(launch geospatial.Point) *Simulator {
+	return &Simulator{
+		Launch:        launch,
+		AscentRate:    5,
+		BurstAltitude: 30000,
+		DescentRate:   5,
+		WindSpeed:     4,
+		WindDirection: 270,
+		Interval:      time.Second,
+		Speed:         1,
+	}
+}
+
+// WindAt returns the simulated wind speed (m/s) and direction (degrees from) at the given
+// altitude in meters.  Speed builds to three times the surface wind at the tropopause
+// and tails off in the stratosphere, while the direction veers slowly with height.
+func (s *Simulator) WindAt(alt float64) (speed, direction float64) {
+	const tropopause = 11000
+
+	if alt < tropopause {
+		speed = s.WindSpeed * (1 + 2*alt/tropopause)
+	} else {
+		speed = s.WindSpeed * (1 + 2*math.Exp(-(alt-tropopause)/6000))
+	}
+
+	direction = math.Mod(s.WindDirection+alt/1000, 360)
+
+	return speed, direction
+}
+
+// DescentRateAt returns the parachute descent rate (m/s) at the given altitude in meters
+func (s *Simulator) DescentRateAt(alt float64) float64 {
+	return s.DescentRate * math.Sqrt(geospatial.SeaLevelDensity/geospatial.AirDensity(alt))
+}
+
+// Run flies the simulated balloon, setting a new fix in r every Interval/Speed, until it
+// lands.  The landed position continues to be reported so the rest of the controller
+// sees a payload sitting on the ground.
+func (s *Simulator) Run(r *GPSReading) {
+	log.Printf("Simulating flight from %.4f,%.4f: ascent %v m/s, burst %v m, descent %v m/s\n",
+		s.Launch.Lat, s.Launch.Lon, s.AscentRate, s.BurstAltitude, s.DescentRate)
+
+	ground := s.Launch.Altitude / metersToFeet
+	alt := ground
+	pos := s.Launch
+	burst := false
+	now := time.Now()
+	dt := s.Interval.Seconds()
+
+	for {
+		var vz float64
+
+		switch {
+		case !burst && alt < s.BurstAltitude:
+			vz = s.AscentRate
+		case !burst:
+			log.Printf("Simulated balloon burst at %.0f m\n", alt)
+			burst = true
+			fallthrough
+		case alt > ground:
+			vz = -s.DescentRateAt(alt)
+		}
+
+		// We only drift while we're off the ground.  The wind blows *from* wdir, so we
+		// travel toward the reciprocal heading.
+		var wspeed, heading float64
+		if vz != 0 {
+			var wdir float64
+			wspeed, wdir = s.WindAt(alt)
+			heading = math.Mod(wdir+180, 360)
+		}
+
+		pos = pos.DestinationPoint(heading, geospatial.Distance(wspeed*dt))
+		alt = math.Max(ground, alt+vz*dt)
+		now = now.Add(s.Interval)
+
+		p := geospatial.Point{
+			Lat:      pos.Lat,
+			Lon:      pos.Lon,
+			Altitude: alt * metersToFeet,
+			Climb:    vz * metersToFeet * 60,
+			Speed:    float32(wspeed * mpsToMPH),
+			Heading:  uint16(heading),
+			Time:     now,
+		}
+
+		if s.Debug {
+			log.Printf("Simulated position: %+v\n", p)
+		}
+
+		r.Set(p)
+
+		time.Sleep(time.Duration(float64(s.Interval) / s.Speed))
+	}
+}
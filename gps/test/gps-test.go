@@ -0,0 +1,163 @@
+// GoBalloon
+// gps-test.go - Runs the gpsd client against a fake gpsd and checks how it copes with
+//               disconnects, slow writers and garbage on the wire.
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/gps"
+	"github.com/chrissnell/GoBalloon/gps/gpsdtest"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+var debug = flag.Bool("debug", false, "Enable debugging information")
+
+type scenario struct {
+	name        string
+	script      []gpsdtest.Step
+	wantLat     float64
+	wantLon     float64
+	wantAlt     float64 // feet
+	connections int
+}
+
+func main() {
+	flag.Parse()
+
+	scenarios := []scenario{
+		{
+			name:        "Streams TPV after WATCH",
+			script:      []gpsdtest.Step{gpsdtest.SKY(8), gpsdtest.TPV(47.2101, -122.4818, 100, 0, 0)},
+			wantLat:     47.2101,
+			wantLon:     -122.4818,
+			wantAlt:     328.084,
+			connections: 1,
+		},
+		{
+			name: "Skips malformed lines",
+			script: []gpsdtest.Step{
+				gpsdtest.Malformed("{\"class\":\"TPV\",\"lat\":"),
+				gpsdtest.Malformed("$GPGGA,this,is,not,json"),
+				gpsdtest.Line("{\"class\":\"TPV\",\"mode\":3,\"lat\":\"north\"}"),
+				gpsdtest.TPV(45.5, -122.6, 1000, 3, 90),
+			},
+			wantLat:     45.5,
+			wantLon:     -122.6,
+			wantAlt:     3280.84,
+			connections: 1,
+		},
+		{
+			name: "Reconnects after a disconnect",
+			script: []gpsdtest.Step{
+				gpsdtest.TPV(40, -105, 1600, 0, 0),
+				gpsdtest.Disconnect(),
+				gpsdtest.TPV(40.1, -104.9, 2000, 5, 45),
+			},
+			wantLat:     40.1,
+			wantLon:     -104.9,
+			wantAlt:     6561.68,
+			connections: 2,
+		},
+		{
+			name: "Survives a slow writer",
+			script: []gpsdtest.Step{
+				gpsdtest.Slow("{\"class\":\"TPV\",\"mode\":3,\"lat\":35.1,\"lon\":-106.6,\"alt\":1500}", 10*time.Millisecond),
+			},
+			wantLat:     35.1,
+			wantLon:     -106.6,
+			wantAlt:     4921.26,
+			connections: 1,
+		},
+		{
+			name: "Reconnects after gpsd stalls past the read deadline",
+			script: []gpsdtest.Step{
+				gpsdtest.TPV(30, -97, 150, 0, 0),
+				gpsdtest.Pause(20 * time.Second),
+				gpsdtest.TPV(30.2, -97.2, 300, 0, 0),
+			},
+			wantLat:     30.2,
+			wantLon:     -97.2,
+			wantAlt:     984.252,
+			connections: 2,
+		},
+	}
+
+	failed := 0
+	for _, sc := range scenarios {
+		err := run(sc)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL: %v: %v\n", sc.name, err)
+		} else {
+			fmt.Printf("PASS: %v\n", sc.name)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(sc scenario) error {
+	s, err := gpsdtest.NewServer(sc.script...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.Debug = *debug
+
+	addr := s.Addr()
+	g := new(gps.GPS)
+	g.Remotegps = &addr
+	g.Debug = debug
+
+	go g.StartGPS()
+
+	// Hang up when we're done, so this client doesn't keep redialing the next scenario's
+	// closed server
+	defer g.Stop()
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Minute):
+		return fmt.Errorf("script did not finish")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p := g.Reading.Get()
+		if closeTo(p.Lat, sc.wantLat) && closeTo(p.Lon, sc.wantLon) && closeTo(p.Altitude, sc.wantAlt) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("got position %v,%v @ %v ft, want %v,%v @ %v ft",
+				p.Lat, p.Lon, p.Altitude, sc.wantLat, sc.wantLon, sc.wantAlt)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if s.Connections() != sc.connections {
+		return fmt.Errorf("got %v connections, want %v", s.Connections(), sc.connections)
+	}
+
+	if s.Watches() != sc.connections {
+		return fmt.Errorf("got %v WATCH commands over %v connections", s.Watches(), s.Connections())
+	}
+
+	if *debug {
+		log.Printf("%v: final position %+v\n", sc.name, g.Reading.Get())
+	}
+
+	return nil
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
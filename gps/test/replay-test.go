@@ -0,0 +1,70 @@
+// GoBalloon
+// replay-test.go - Loads recorded NMEA flights and plays them back at high speed
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"github.com/chrissnell/GoBalloon/gps"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"strings"
+	"time"
+)
+
+// A log that starts with GGA, as most receivers do, and crosses midnight before the
+// first RMC arrives
+const ggaFirst = `$GPGGA,235958,4712.61,N,12228.91,W,1,08,0.9,100.0,M,,,,
+$GPGGA,235959,4712.61,N,12228.91,W,1,08,0.9,105.0,M,,,,
+$GPRMC,000000,A,4712.61,N,12228.91,W,5.0,90.0,140614,,
+$GPGGA,000000,4712.61,N,12228.91,W,1,08,0.9,110.0,M,,,,
+$GPGGA,000001,4712.61,N,12228.91,W,1,08,0.9,115.0,M,,,,
+`
+
+// A log with no RMC at all, which never gets a date
+const ggaOnly = `$GPGGA,120000,4712.61,N,12228.91,W,1,08,0.9,100.0,M,,,,
+$GPGGA,120001,4712.61,N,12228.91,W,1,08,0.9,105.0,M,,,,
+`
+
+func main() {
+	track, err := gps.ParseNMEATrack(strings.NewReader(ggaFirst))
+	checks.Equal("parse GGA first", err, nil)
+	checks.Equal("GGA first fixes", len(track), 4)
+
+	var times []string
+	for _, p := range track {
+		times = append(times, p.Time.Format("2006-01-02 15:04:05"))
+	}
+	checks.Equal("GGA first times", strings.Join(times, ", "),
+		"2014-06-13 23:59:58, 2014-06-13 23:59:59, 2014-06-14 00:00:00, 2014-06-14 00:00:01")
+	checks.True(replays(track), "GGA first replays")
+
+	track, err = gps.ParseNMEATrack(strings.NewReader(ggaOnly))
+	checks.Equal("parse GGA only", err, nil)
+	checks.Equal("GGA only fixes", len(track), 2)
+	if len(track) == 2 {
+		checks.Equal("GGA only has no times", track[0].Time.IsZero() && track[1].Time.IsZero(), true)
+	}
+	checks.True(replays(track), "GGA only replays")
+
+	checks.Done()
+}
+
+// replays plays a track back at 100x and reports whether it finished in good time and
+// left the last fix's altitude in the reading
+func replays(track []geospatial.Point) bool {
+	var r gps.GPSReading
+	done := make(chan struct{})
+	go func() {
+		(&gps.Replay{Track: track, Speed: 100}).Run(&r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		return false
+	}
+	return r.Get().Altitude == track[len(track)-1].Altitude
+}
@@ -0,0 +1,408 @@
+// GoBalloon
+// track.go - Loaders for recorded GPS tracks (gpsd JSON, NMEA, CSV and GPX)
+//
+// (c) 2014, Christopher Snell
+
+package gps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metersToFeet = 3.28084
+	mpsToMPH     = 2.236936
+	knotsToMPH   = 1.150779
+)
+
+// pointFromTPV builds a Point from a gpsd TPV sentence, converting altitude from
+// meters to feet, climb from meters/sec to feet/min and speed from meters/sec to mph
+func pointFromTPV(tpv *TPVSentence) geospatial.Point {
+	return geospatial.Point{
+		Lon:      tpv.Lon,
+		Lat:      tpv.Lat,
+		Altitude: tpv.Alt * metersToFeet,
+		Climb:    tpv.Climb * metersToFeet * 60,
+		Speed:    tpv.Speed * mpsToMPH,
+		Heading:  uint16(tpv.Track),
+		Time:     tpv.Time,
+	}
+}
+
+// LoadTrack reads a recorded flight from a file and returns its fixes in order.
+// The format is detected from the file contents:
+//
+//	gpsd JSON   one gpsd sentence per line, as logged by gpspipe -w
+//	NMEA        $GPGGA and $GPRMC sentences, as logged by gpspipe -r
+//	GPX         a GPX 1.0/1.1 document with one or more <trkseg>
+//	CSV         time,lat,lon,alt[,speed,heading] with altitude in meters,
+//	            speed in meters/sec and time as RFC3339 or Unix seconds
+func LoadTrack(filename string) ([]geospatial.Point, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("Track file %v is empty", filename)
+	}
+
+	switch {
+	case trimmed[0] == '{':
+		return ParseGPSDTrack(bytes.NewReader(data))
+	case trimmed[0] == '$' || trimmed[0] == '!':
+		return ParseNMEATrack(bytes.NewReader(data))
+	case trimmed[0] == '<' || strings.ToLower(filepath.Ext(filename)) == ".gpx":
+		return ParseGPXTrack(bytes.NewReader(data))
+	default:
+		return ParseCSVTrack(bytes.NewReader(data))
+	}
+}
+
+// ParseGPSDTrack reads TPV sentences from a gpsd JSON log.  Other sentence classes are skipped.
+func ParseGPSDTrack(r io.Reader) ([]geospatial.Point, error) {
+	var track []geospatial.Point
+	var classify GPSDSentence
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(line, &classify); err != nil || classify.Class != "TPV" {
+			continue
+		}
+
+		tpv := TPVSentence{}
+		if err := json.Unmarshal(line, &tpv); err != nil {
+			continue
+		}
+
+		// Mode 2 is a 2D fix and mode 3 is a 3D fix.  Anything less has no position.
+		if tpv.Mode < 2 {
+			continue
+		}
+
+		track = append(track, pointFromTPV(&tpv))
+	}
+
+	return track, s.Err()
+}
+
+// ParseNMEATrack reads GGA and RMC sentences from an NMEA log.  GGA sentences provide the
+// fixes and RMC sentences provide the date, speed and heading.  Logs without GGA sentences
+// are built from RMC alone.  GGA fixes from before the first RMC are dated once it
+// arrives; fixes that never get a date are left without a time.
+func ParseNMEATrack(r io.Reader) ([]geospatial.Point, error) {
+	var gga, rmc []geospatial.Point
+	var date time.Time
+	var speed float32
+	var heading uint16
+
+	// Time of day of each GGA fix we've had no date for, by its index in gga
+	undated := make(map[int]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f, ok := splitNMEA(s.Text())
+		if !ok || len(f[0]) < 5 {
+			continue
+		}
+
+		switch f[0][len(f[0])-3:] {
+		case "GGA":
+			// $GPGGA,hhmmss.ss,llll.ll,a,yyyyy.yy,a,x,xx,x.x,x.x,M,...
+			if len(f) < 10 || f[6] == "0" || f[6] == "" {
+				continue
+			}
+			lat, lon, err := nmeaLatLon(f[2], f[3], f[4], f[5])
+			if err != nil {
+				continue
+			}
+			alt, _ := strconv.ParseFloat(f[9], 64)
+			gga = append(gga, geospatial.Point{
+				Lat:      lat,
+				Lon:      lon,
+				Altitude: alt * metersToFeet,
+				Speed:    speed,
+				Heading:  heading,
+				Time:     nmeaTime(date, f[1]),
+			})
+			if date.IsZero() {
+				undated[len(gga)-1] = f[1]
+			}
+
+		case "RMC":
+			// $GPRMC,hhmmss.ss,A,llll.ll,a,yyyyy.yy,a,x.x,x.x,ddmmyy,...
+			if len(f) < 10 || f[2] != "A" {
+				continue
+			}
+			if d, err := time.Parse("020106", f[9]); err == nil {
+				date = d
+				datePending(gga, undated, date, nmeaTime(date, f[1]))
+			}
+			kts, _ := strconv.ParseFloat(f[7], 64)
+			track, _ := strconv.ParseFloat(f[8], 64)
+			speed = float32(kts * knotsToMPH)
+			heading = uint16(track)
+
+			lat, lon, err := nmeaLatLon(f[3], f[4], f[5], f[6])
+			if err != nil {
+				continue
+			}
+			rmc = append(rmc, geospatial.Point{
+				Lat:     lat,
+				Lon:     lon,
+				Speed:   speed,
+				Heading: heading,
+				Time:    nmeaTime(date, f[1]),
+			})
+		}
+	}
+
+	if len(gga) > 0 {
+		return gga, s.Err()
+	}
+	return rmc, s.Err()
+}
+
+// splitNMEA validates the checksum of an NMEA sentence, if it has one, and splits it into fields
+func splitNMEA(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < 6 || (line[0] != '$' && line[0] != '!') {
+		return nil, false
+	}
+	line = line[1:]
+
+	if i := strings.IndexByte(line, '*'); i >= 0 {
+		sum, err := strconv.ParseUint(line[i+1:], 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		var calc byte
+		for _, c := range []byte(line[:i]) {
+			calc ^= c
+		}
+		if byte(sum) != calc {
+			return nil, false
+		}
+		line = line[:i]
+	}
+
+	return strings.Split(line, ","), true
+}
+
+// nmeaLatLon converts NMEA ddmm.mmmm/dddmm.mmmm coordinates to signed decimal degrees
+func nmeaLatLon(lat, ns, lon, ew string) (float64, float64, error) {
+	if len(lat) < 4 || len(lon) < 5 {
+		return 0, 0, fmt.Errorf("Invalid NMEA coordinates: %v,%v", lat, lon)
+	}
+
+	latDeg, err := strconv.ParseFloat(lat[:2], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	latMin, err := strconv.ParseFloat(lat[2:], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lonDeg, err := strconv.ParseFloat(lon[:3], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lonMin, err := strconv.ParseFloat(lon[3:], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	la := latDeg + latMin/60
+	lo := lonDeg + lonMin/60
+
+	if ns == "S" {
+		la = -la
+	}
+	if ew == "W" {
+		lo = -lo
+	}
+
+	return la, lo, nil
+}
+
+// datePending dates the GGA fixes we had no date for, now that an RMC sentence sent at
+// rmcTime has given us one.  Fixes later in the day than rmcTime were from before midnight.
+func datePending(gga []geospatial.Point, undated map[int]string, date, rmcTime time.Time) {
+	for i, hms := range undated {
+		t := nmeaTime(date, hms)
+		if t.After(rmcTime) {
+			t = t.AddDate(0, 0, -1)
+		}
+		gga[i].Time = t
+		delete(undated, i)
+	}
+}
+
+// nmeaTime combines the most recently seen RMC date with an NMEA hhmmss.ss time of day.
+// Before any RMC it returns the zero time, so that undated fixes have no time at all.
+func nmeaTime(date time.Time, hms string) time.Time {
+	if date.IsZero() {
+		return time.Time{}
+	}
+	if len(hms) < 6 {
+		return date
+	}
+	h, _ := strconv.Atoi(hms[0:2])
+	m, _ := strconv.Atoi(hms[2:4])
+	sec, _ := strconv.ParseFloat(hms[4:], 64)
+	whole, frac := math.Modf(sec)
+
+	return time.Date(date.Year(), date.Month(), date.Day(), h, m, int(whole), int(frac*1e9), time.UTC)
+}
+
+type gpxDocument struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat       float64   `xml:"lat,attr"`
+	Lon       float64   `xml:"lon,attr"`
+	Elevation float64   `xml:"ele"`
+	Time      time.Time `xml:"time"`
+	Course    float64   `xml:"course"`
+	Speed     float64   `xml:"speed"`
+}
+
+// ParseGPXTrack reads the track points from a GPX document.  Elevation is in meters and
+// the optional GPX 1.0 speed element is in meters/sec.
+func ParseGPXTrack(r io.Reader) ([]geospatial.Point, error) {
+	var doc gpxDocument
+	var track []geospatial.Point
+
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse GPX: %v", err)
+	}
+
+	for _, t := range doc.Tracks {
+		for _, seg := range t.Segments {
+			for _, p := range seg.Points {
+				track = append(track, geospatial.Point{
+					Lat:      p.Lat,
+					Lon:      p.Lon,
+					Altitude: p.Elevation * metersToFeet,
+					Speed:    float32(p.Speed * mpsToMPH),
+					Heading:  uint16(p.Course),
+					Time:     p.Time,
+				})
+			}
+		}
+	}
+
+	return track, nil
+}
+
+// ParseCSVTrack reads a track with the columns time,lat,lon,alt[,speed,heading].  A header
+// row is skipped if present.
+func ParseCSVTrack(r io.Reader) ([]geospatial.Point, error) {
+	var track []geospatial.Point
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return track, err
+		}
+
+		if len(rec) < 4 {
+			return track, fmt.Errorf("CSV line %v: expected at least 4 columns but found %v", line, len(rec))
+		}
+
+		lat, err := strconv.ParseFloat(rec[1], 64)
+		if err != nil {
+			if line == 1 {
+				// This is our header
+				continue
+			}
+			return track, fmt.Errorf("CSV line %v: invalid latitude %q", line, rec[1])
+		}
+		lon, err := strconv.ParseFloat(rec[2], 64)
+		if err != nil {
+			return track, fmt.Errorf("CSV line %v: invalid longitude %q", line, rec[2])
+		}
+		alt, err := strconv.ParseFloat(rec[3], 64)
+		if err != nil {
+			return track, fmt.Errorf("CSV line %v: invalid altitude %q", line, rec[3])
+		}
+		t, err := parseCSVTime(rec[0])
+		if err != nil {
+			return track, fmt.Errorf("CSV line %v: %v", line, err)
+		}
+
+		p := geospatial.Point{Lat: lat, Lon: lon, Altitude: alt * metersToFeet, Time: t}
+
+		if len(rec) >= 6 {
+			speed, _ := strconv.ParseFloat(rec[4], 64)
+			heading, _ := strconv.ParseFloat(rec[5], 64)
+			p.Speed = float32(speed * mpsToMPH)
+			p.Heading = uint16(heading)
+		}
+
+		track = append(track, p)
+	}
+
+	return track, nil
+}
+
+func parseCSVTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	whole, frac := math.Modf(secs)
+
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+}
+
+// openTrack loads a track for playback, refusing tracks that have no fixes in them
+func openTrack(filename string) ([]geospatial.Point, error) {
+	track, err := LoadTrack(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load track %v: %v", filename, err)
+	}
+
+	if len(track) == 0 {
+		return nil, fmt.Errorf("Track %v contains no fixes", filename)
+	}
+
+	return track, nil
+}
@@ -0,0 +1,59 @@
+// GoBalloon
+// igate.go - Ground-station IGate: forwards packets heard by the TNC to APRS-IS
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"log"
+	"strings"
+)
+
+type igateLink struct {
+	client *aprsis.Client
+	gate   *aprsis.IGate
+}
+
+// newIGateLink sets up an IGate over a managed APRS-IS connection.  servers is a
+// comma-separated list of servers to fail over between.
+func newIGateLink(servers, call, pass string, debug bool) *igateLink {
+	c := aprsis.NewClient(call, pass, strings.Split(servers, ",")...)
+	c.Transmit = true
+	c.Debug = debug
+
+	g := aprsis.NewIGate(c)
+	g.Debug = debug
+
+	return &igateLink{client: c, gate: g}
+}
+
+// Run keeps us connected to APRS-IS.  Anything the server sends us is read and thrown
+// away so that it doesn't back up and get us disconnected.
+func (l *igateLink) Run() {
+	log.Println("igateLink.Run()")
+
+	go l.client.Run()
+
+	for {
+		select {
+		case <-l.client.Packets:
+		case ev := <-l.client.Events:
+			log.Printf("APRS-IS IGate connection is %v (%v)\n", ev.State, ev.Server)
+			if ev.State == aprsis.StateClosed {
+				return
+			}
+		}
+	}
+}
+
+// Gate forwards a packet heard on RF to APRS-IS.  Packets heard while we're not connected
+// are dropped.
+func (l *igateLink) Gate(p ax25.APRSPacket) {
+	_, err := l.gate.Gate(p)
+	if err != nil && err != aprsis.ErrNotConnected {
+		log.Printf("Error gating packet to APRS-IS: %v\n", err)
+	}
+}
@@ -0,0 +1,70 @@
+// GoBalloon
+// checks.go - Pass/fail bookkeeping for the self-checking test programs
+//
+// (c) 2014, Christopher Snell
+
+// Package checks keeps score for the programs in the test and tests directories that
+// check their own results.  Each check prints an ok or FAIL line, and Done prints PASS
+// or exits non-zero.
+package checks
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// MaxReported is how many failures are printed before the rest are only counted, so that
+// a sweep that goes wrong everywhere doesn't bury everything else.  0 prints them all.
+var MaxReported = 0
+
+var (
+	failures int
+	mutex    sync.Mutex
+)
+
+// Equal checks that got prints the same as want
+func Equal(what string, got, want interface{}) bool {
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		Failf("%v: got %v, want %v", what, got, want)
+		return false
+	}
+	fmt.Printf("ok   %v: %v\n", what, got)
+	return true
+}
+
+// True checks that ok is true, describing the check with a format string
+func True(ok bool, format string, args ...interface{}) bool {
+	if !ok {
+		Failf(format, args...)
+		return false
+	}
+	fmt.Printf("ok   "+format+"\n", args...)
+	return true
+}
+
+// Failf records a failure
+func Failf(format string, args ...interface{}) {
+	mutex.Lock()
+	failures++
+	n := failures
+	mutex.Unlock()
+
+	if MaxReported == 0 || n <= MaxReported {
+		fmt.Printf("FAIL "+format+"\n", args...)
+	}
+}
+
+// Done prints PASS if every check passed.  Otherwise it prints how many failed and exits
+// with a non-zero status.
+func Done() {
+	mutex.Lock()
+	n := failures
+	mutex.Unlock()
+
+	if n > 0 {
+		fmt.Printf("FAIL: %v failure(s)\n", n)
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
@@ -0,0 +1,49 @@
+// GoBalloon
+// landing.go - Beacons the landing point predicted during descent
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"log"
+	"time"
+)
+
+// parachute describes the payload's descent from the -chute* flags
+func parachute() geospatial.Parachute {
+	return geospatial.Parachute{Mass: *chutemass, Diameter: *chutediameter, Drag: *chutedrag}
+}
+
+// landingObjectName names our prediction object after the balloon, e.g. NW5W-LZ
+func landingObjectName() string {
+	name := fmt.Sprintf("%s-LZ", *ballooncall)
+	if len(name) > 9 {
+		name = name[:9]
+	}
+	return name
+}
+
+// sendLandingPrediction beacons a predicted landing point as an APRS object.  We never
+// block the flight computer waiting on the TNC; if the radio is busy, the prediction is
+// dropped and a fresh one will go out next time.
+func sendLandingPrediction(landing geospatial.Point) {
+	o := aprs.Object{
+		Name:     landingObjectName(),
+		Live:     true,
+		Position: landing,
+		Comment:  fmt.Sprintf("Predicted landing %vZ", landing.Time.UTC().Format("15:04")),
+	}
+
+	// The object's timestamp is when we made the prediction, not when we expect to land
+	o.Position.Time = time.Now()
+
+	select {
+	case aprsObject <- o:
+	default:
+		log.Println("APRS transmitter busy.  Dropping landing prediction.")
+	}
+}
@@ -0,0 +1,250 @@
+// GoBalloon
+// links.go - Links to our TNCs, as given on the command line
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/tnc"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newLinks builds our TNC links from the -tnc flag, or from -agwpe, -localtncport or
+// -remotetnc if it wasn't given
+func (a *APRSTNC) newLinks() (*tnc.Links, error) {
+	specs := *tnclinks
+	if len(specs) == 0 {
+		switch {
+		case len(*a.AGWPE) > 0:
+			specs = "agwpe:" + *a.AGWPE
+		case len(*a.Localtncport) > 0:
+			specs = "serial:" + *a.Localtncport
+		case len(*a.Remotetnc) > 0:
+			specs = "tcp:" + *a.Remotetnc
+		default:
+			return nil, errors.New("Must provide -tnc, -remotetnc, -localtncport or -agwpe flag.")
+		}
+	}
+
+	var links []*tnc.Link
+	a.devices = make(map[string]*tnc.KISSDevice)
+	ports := make(map[string]bool)
+	for _, spec := range strings.Split(specs, ",") {
+		k, port, err := a.parseLink(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		if ports[port] {
+			return nil, fmt.Errorf("TNC link %q is given more than once", port)
+		}
+		ports[port] = true
+		links = append(links, k)
+	}
+
+	l := tnc.NewLinks(links...)
+	l.Debug = *debug
+	return l, nil
+}
+
+// parseLink parses a link of the form kind:address[#port][?params][@tx], e.g.
+// serial:/dev/ttyUSB0#1?txdelay=300@backup, and returns it along with the TNC port it
+// uses.  Links to the same serial or TCP KISS TNC share one connection to it.
+func (a *APRSTNC) parseLink(spec string) (*tnc.Link, string, error) {
+	k := &tnc.Link{TX: tnc.TXPrimary}
+	link := spec
+
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		p, err := tnc.ParseTXPolicy(spec[i+1:])
+		if err != nil {
+			return nil, "", err
+		}
+		k.TX = p
+		spec = spec[:i]
+	}
+
+	var query string
+	if i := strings.Index(spec, "?"); i >= 0 {
+		query = spec[i+1:]
+		spec = spec[:i]
+	}
+
+	port := *kissport
+	if i := strings.LastIndex(spec, "#"); i >= 0 {
+		n, err := strconv.Atoi(spec[i+1:])
+		if err != nil || n < 0 || n >= ax25.KISSPorts {
+			return nil, "", fmt.Errorf("TNC link %q: port must be 0-%v", link, ax25.KISSPorts-1)
+		}
+		port = n
+		spec = spec[:i]
+	}
+
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, "", fmt.Errorf("TNC link %q must be kind:address, e.g. tcp:10.50.0.25:6700", link)
+	}
+
+	switch parts[0] {
+	case "serial", "tcp":
+		params, err := linkParams(query)
+		if err != nil {
+			return nil, "", fmt.Errorf("TNC link %q: %v", link, err)
+		}
+
+		d, ok := a.devices[spec]
+		if !ok {
+			if parts[0] == "serial" {
+				d = tnc.NewSerialKISSDevice(parts[1], *baud)
+			} else {
+				d = tnc.NewTCPKISSDevice(parts[1])
+			}
+			a.devices[spec] = d
+		}
+		t := d.Port(port)
+		t.Params = params
+		k.Transport = t
+	case "agwpe":
+		if len(query) > 0 {
+			return nil, "", fmt.Errorf("TNC link %q: AGWPE links don't take KISS parameters", link)
+		}
+		t := tnc.NewAGWPE(parts[1])
+		t.Port = port
+		t.Callsigns = []ax25.APRSAddress{a.callsign()}
+		t.Debug = *debug
+		k.Transport = t
+	default:
+		return nil, "", fmt.Errorf("Unknown TNC link kind %q; must be serial, tcp or agwpe", parts[0])
+	}
+
+	return k, fmt.Sprintf("%v#%v", spec, port), nil
+}
+
+// linkParams parses a link's KISS parameters, e.g. txdelay=300&persist=63.  Any it
+// doesn't give come from the -txdelay, -persist, -slottime, -txtail and -fullduplex flags.
+func linkParams(query string) (ax25.KISSParams, error) {
+	params := ax25.KISSParams{
+		TXDelay:  kissTime(*txdelay),
+		SlotTime: kissTime(*slottime),
+		TXTail:   kissTime(*txtail),
+	}
+	if *persist >= 0 {
+		p := *persist
+		params.Persistence = &p
+	}
+	if fullduplex.set {
+		fd := fullduplex.value
+		params.FullDuplex = &fd
+	}
+
+	if len(query) == 0 {
+		return params, nil
+	}
+
+	for _, kv := range strings.Split(query, "&") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return params, fmt.Errorf("KISS parameter %q must be name=value", kv)
+		}
+
+		switch parts[0] {
+		case "txdelay", "slottime", "txtail":
+			ms, err := strconv.Atoi(parts[1])
+			if err != nil || ms < 0 {
+				return params, fmt.Errorf("KISS parameter %v must be a time in ms", parts[0])
+			}
+			d := kissTime(ms)
+			switch parts[0] {
+			case "txdelay":
+				params.TXDelay = d
+			case "slottime":
+				params.SlotTime = d
+			default:
+				params.TXTail = d
+			}
+		case "persist":
+			p, err := strconv.Atoi(parts[1])
+			if err != nil || p < 0 || p > 255 {
+				return params, errors.New("KISS parameter persist must be 0-255")
+			}
+			params.Persistence = &p
+		case "fullduplex":
+			fd, err := strconv.ParseBool(parts[1])
+			if err != nil {
+				return params, errors.New("KISS parameter fullduplex must be true or false")
+			}
+			params.FullDuplex = &fd
+		default:
+			return params, fmt.Errorf("Unknown KISS parameter %q; must be txdelay, persist, slottime, txtail or fullduplex", parts[0])
+		}
+	}
+
+	return params, nil
+}
+
+// kissTime converts a KISS time flag in milliseconds, which is negative if it wasn't given
+func kissTime(ms int) *time.Duration {
+	if ms < 0 {
+		return nil
+	}
+	d := time.Duration(ms) * time.Millisecond
+	return &d
+}
+
+// optionalBool is a boolean flag that knows whether it was given at all
+type optionalBool struct {
+	set, value bool
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.set, b.value = true, v
+	return nil
+}
+
+func (b *optionalBool) String() string {
+	if b == nil || !b.set {
+		return ""
+	}
+	return strconv.FormatBool(b.value)
+}
+
+// IsBoolFlag lets the flag be given as plain -name, like a flag.Bool
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// callsign returns the station we're running as: the chase vehicle in ground mode and
+// the balloon otherwise
+func (a *APRSTNC) callsign() ax25.APRSAddress {
+	if a.ground == nil {
+		return balloonAddr
+	}
+	chaser := ax25.APRSAddress{Callsign: *chasercall}
+	ssid, _ := strconv.Atoi(*chaserssid)
+	chaser.SSID = uint8(ssid)
+	return chaser
+}
+
+// exitKISS takes our KISS TNCs out of KISS mode on the way out, if we've been asked to.
+// A multi-port TNC only needs telling once, whichever of its radios we were using.
+func (a *APRSTNC) exitKISS() {
+	if !*kissexit {
+		return
+	}
+
+	for _, d := range a.devices {
+		err := d.ExitKISS()
+		if err != nil {
+			log.Printf("Error taking %v out of KISS mode: %v", d, err)
+		}
+	}
+}
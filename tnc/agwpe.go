@@ -0,0 +1,154 @@
+// GoBalloon
+// agwpe.go - Links to soundcard modems over the AGWPE TCP API
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"github.com/chrissnell/GoBalloon/ax25"
+	"net"
+	"sync"
+	"time"
+)
+
+// AGWPE is a link to a soundcard modem such as Direwolf or SoundModem
+type AGWPE struct {
+	health
+
+	Port        int                // AGWPE port of the radio to use
+	Callsigns   []ax25.APRSAddress // Registered with the server whenever the link opens
+	ReadTimeout time.Duration      // 0 to wait forever
+	Debug       bool
+
+	addr   string
+	conn   net.Conn
+	client *ax25.AGWPEClient
+	mutex  sync.Mutex // Guards conn and client
+}
+
+// NewAGWPE gets a link to an AGWPE server, e.g. localhost:8000
+func NewAGWPE(addr string) *AGWPE {
+	return &AGWPE{
+		addr:        addr,
+		ReadTimeout: DefaultReadTimeout,
+	}
+}
+
+func (a *AGWPE) String() string {
+	return "agwpe:" + a.addr
+}
+
+// Open connects to the server, asks for the frames it hears and registers our callsigns
+func (a *AGWPE) Open() error {
+	conn, err := net.Dial("tcp", a.addr)
+	if err != nil {
+		a.failed(err)
+		return err
+	}
+
+	client := ax25.NewAGWPEClient(conn)
+	client.Debug = a.Debug
+
+	// Raw frames keep the H bits that the digipeater and ground station look at
+	err = client.MonitorRaw()
+	for _, call := range a.Callsigns {
+		if err != nil {
+			break
+		}
+		err = client.Register(call)
+	}
+	if err != nil {
+		client.Close()
+		a.failed(err)
+		return err
+	}
+
+	a.mutex.Lock()
+	a.conn, a.client = conn, client
+	a.mutex.Unlock()
+
+	a.opened()
+	return nil
+}
+
+// ReadFrame returns the next frame heard on our port
+func (a *AGWPE) ReadFrame() (ax25.Frame, error) {
+	a.mutex.Lock()
+	conn, client := a.conn, a.client
+	a.mutex.Unlock()
+
+	if client == nil {
+		return ax25.Frame{}, ErrNotOpen
+	}
+
+	for {
+		if a.ReadTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(a.ReadTimeout))
+		}
+
+		f, err := client.NextFrame()
+		if err != nil && !ax25.IsFrameError(err) {
+			a.failed(err)
+			return ax25.Frame{}, err
+		}
+
+		if f.Port != a.Port {
+			// Heard on one of the modem's other radios
+			continue
+		}
+
+		if err == nil {
+			a.read()
+		}
+		return f, err
+	}
+}
+
+// WriteFrame transmits a frame on our port.  APRS goes out as unproto frames and anything
+// else raw.
+func (a *AGWPE) WriteFrame(f ax25.Frame) error {
+	a.mutex.Lock()
+	client := a.client
+	a.mutex.Unlock()
+
+	if client == nil {
+		return ErrNotOpen
+	}
+
+	// A bad frame isn't the link's fault
+	f.Port = a.Port
+	if _, err := f.Encode(); err != nil {
+		return err
+	}
+
+	var err error
+	if p, perr := f.APRSPacket(); perr == nil {
+		err = client.Send(p)
+	} else {
+		err = client.SendFrame(f)
+	}
+
+	if err != nil {
+		a.failed(err)
+		return err
+	}
+
+	a.wrote()
+	return nil
+}
+
+// Close disconnects from the server
+func (a *AGWPE) Close() error {
+	a.mutex.Lock()
+	client := a.client
+	a.conn, a.client = nil, nil
+	a.mutex.Unlock()
+
+	a.closed()
+
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
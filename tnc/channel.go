@@ -0,0 +1,334 @@
+// GoBalloon
+// channel.go - A simulated RF channel shared by several KISS TNCs, for testing without radios
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"errors"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultChannelBaud is the speed of the 1200 baud AFSK used for APRS on 2 m
+const DefaultChannelBaud = 1200
+
+// DefaultChannelSlotTime is how long a station waits between checks for a clear channel
+const DefaultChannelSlotTime = 10 * time.Millisecond
+
+// channelQueue is how many frames a station's TNC holds for its host before it starts
+// dropping them
+const channelQueue = 64
+
+// ErrChannelClosed is returned when attaching to a channel that's been closed
+var ErrChannelClosed = errors.New("Channel closed")
+
+// Channel is a simulated radio channel.  Each station on it is a KISS TNC at the end of a
+// pipe, and hears what the other stations transmit, subject to loss, delay and collisions.
+//
+// A frame is on the air for as long as it would take to send at Baud, and two frames on
+// the air at once collide and nobody hears either.  With CarrierSense, a station waits
+// for the channel to be clear, plus a random number of slots, before transmitting.
+// Frames are heard Delay after they finish, and each station misses each frame with
+// probability Loss.  Hears, if set, says which stations are in range of each other.
+type Channel struct {
+	Loss         float64
+	Delay        time.Duration
+	Baud         int // 0 for frames that take no time to send, and so never collide
+	CarrierSense bool
+	SlotTime     time.Duration
+	Hears        func(from, to string) bool
+	Debug        bool
+
+	rand      *rand.Rand
+	stations  []*station
+	onAir     []*transmission
+	busyUntil time.Time
+	stats     ChannelStats
+	closed    bool
+	mutex     sync.Mutex
+}
+
+// ChannelStats counts what's happened on a channel
+type ChannelStats struct {
+	Sent     int // Frames transmitted
+	Heard    int // Copies of frames heard by stations
+	Lost     int // Copies missed because of Loss
+	Collided int // Frames nobody heard because they collided with another
+}
+
+type transmission struct {
+	from     *station
+	kiss     []byte
+	start    time.Time
+	end      time.Time
+	collided bool
+}
+
+// station is a TNC on the channel.  The host writes KISS frames to transmit into one pipe
+// and reads the KISS frames the TNC hears from the other.
+type station struct {
+	name     string
+	channel  *Channel
+	fromHost *io.PipeReader
+	toTNC    *io.PipeWriter
+	fromTNC  *io.PipeReader
+	toHost   *io.PipeWriter
+	heard    chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewChannel gets a channel with no loss, delay or range limits.  Loss and the waits for a
+// clear channel are random, from a source seeded with seed so that runs can be repeated.
+func NewChannel(seed int64) *Channel {
+	return &Channel{
+		Baud:     DefaultChannelBaud,
+		SlotTime: DefaultChannelSlotTime,
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Attach puts a new station on the channel and returns the host's end of its TNC.
+// Closing it takes the station off the channel.
+func (c *Channel) Attach(name string) (io.ReadWriteCloser, error) {
+	s := &station{
+		name:    name,
+		channel: c,
+		heard:   make(chan []byte, channelQueue),
+		done:    make(chan struct{}),
+	}
+	s.fromHost, s.toTNC = io.Pipe()
+	s.fromTNC, s.toHost = io.Pipe()
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrChannelClosed
+	}
+	c.stations = append(c.stations, s)
+	c.mutex.Unlock()
+
+	go s.transmitter()
+	go s.receiver()
+
+	return stationConn{s}, nil
+}
+
+// KISS gets a link to a new station on the channel each time it's opened
+func (c *Channel) KISS(name string) *KISS {
+	return NewKISS("channel:"+name, func() (io.ReadWriteCloser, error) {
+		return c.Attach(name)
+	})
+}
+
+// Stats returns what's happened on the channel so far
+func (c *Channel) Stats() ChannelStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// Close takes every station off the channel
+func (c *Channel) Close() {
+	c.mutex.Lock()
+	c.closed = true
+	stations := c.stations
+	c.mutex.Unlock()
+
+	for _, s := range stations {
+		s.close()
+	}
+}
+
+// transmit puts a frame on the air and arranges for the other stations to hear it
+func (c *Channel) transmit(from *station, f ax25.Frame) {
+	b, err := f.Encode()
+	if err != nil {
+		c.debugf("%v sent a frame we can't encode: %v", from.name, err)
+		return
+	}
+
+	c.mutex.Lock()
+	now := time.Now()
+	for c.CarrierSense && now.Before(c.busyUntil) {
+		// Wait for the channel to clear, then a few slots more so that everyone who was
+		// waiting doesn't key up at once
+		wait := c.busyUntil.Sub(now) + time.Duration(c.rand.Intn(4)+1)*c.SlotTime
+		c.mutex.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-from.done:
+			return
+		}
+
+		c.mutex.Lock()
+		now = time.Now()
+	}
+
+	tx := &transmission{
+		from:  from,
+		kiss:  b,
+		start: now,
+		end:   now.Add(c.airTime(len(b))),
+	}
+
+	onAir := c.onAir[:0]
+	for _, o := range c.onAir {
+		if !o.end.After(now) {
+			continue
+		}
+		onAir = append(onAir, o)
+		if tx.end.After(tx.start) {
+			o.collided = true
+			tx.collided = true
+		}
+	}
+	c.onAir = append(onAir, tx)
+	if tx.end.After(c.busyUntil) {
+		c.busyUntil = tx.end
+	}
+	c.stats.Sent++
+	c.mutex.Unlock()
+
+	c.debugf("%v transmits %v", from.name, f.Source)
+
+	time.AfterFunc(tx.end.Sub(now)+c.Delay, func() { c.deliver(tx) })
+}
+
+// deliver gives a frame that's finished transmitting to every station that can hear it
+func (c *Channel) deliver(tx *transmission) {
+	c.mutex.Lock()
+	if tx.collided {
+		c.stats.Collided++
+		c.mutex.Unlock()
+		c.debugf("%v's frame collided", tx.from.name)
+		return
+	}
+
+	var to []*station
+	for _, s := range c.stations {
+		if s == tx.from {
+			continue
+		}
+		if c.Hears != nil && !c.Hears(tx.from.name, s.name) {
+			continue
+		}
+		if c.rand.Float64() < c.Loss {
+			c.stats.Lost++
+			c.debugf("%v missed %v's frame", s.name, tx.from.name)
+			continue
+		}
+		c.stats.Heard++
+		to = append(to, s)
+	}
+	c.mutex.Unlock()
+
+	for _, s := range to {
+		s.hear(tx.kiss)
+	}
+}
+
+// airTime is how long it takes to send a KISS frame of n bytes over the air, counting the
+// start and end flags in place of the FENDs and ignoring bit stuffing
+func (c *Channel) airTime(n int) time.Duration {
+	if c.Baud <= 0 {
+		return 0
+	}
+	return time.Duration(n*8) * time.Second / time.Duration(c.Baud)
+}
+
+func (c *Channel) remove(s *station) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, o := range c.stations {
+		if o == s {
+			c.stations = append(c.stations[:i], c.stations[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Channel) debugf(format string, args ...interface{}) {
+	if c.Debug {
+		log.Printf("Channel: "+format+"\n", args...)
+	}
+}
+
+// transmitter puts everything the host sends on the air.  KISS commands other than data
+// are accepted and ignored, as the simulated radio has no TXDELAY or persistence to set.
+func (s *station) transmitter() {
+	d := ax25.NewDecoder(s.fromHost)
+	for {
+		f, err := d.NextFrame()
+		if ax25.IsFrameError(err) {
+			s.channel.debugf("%v sent a bad frame: %v", s.name, err)
+			continue
+		}
+		if err != nil {
+			s.close()
+			return
+		}
+		s.channel.transmit(s, f)
+	}
+}
+
+// receiver passes what the station hears to the host
+func (s *station) receiver() {
+	for {
+		select {
+		case b := <-s.heard:
+			if _, err := s.toHost.Write(b); err != nil {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// hear queues a frame for the host, or drops it if the host isn't keeping up
+func (s *station) hear(b []byte) {
+	select {
+	case s.heard <- b:
+	default:
+		s.channel.debugf("%v's TNC dropped a frame", s.name)
+	}
+}
+
+func (s *station) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.channel.remove(s)
+		s.fromHost.Close()
+		s.toTNC.Close()
+		s.fromTNC.Close()
+		s.toHost.Close()
+	})
+}
+
+// stationConn is the host's end of a station's TNC
+type stationConn struct {
+	s *station
+}
+
+func (c stationConn) Read(b []byte) (int, error) {
+	return c.s.fromTNC.Read(b)
+}
+
+func (c stationConn) Write(b []byte) (int, error) {
+	return c.s.toTNC.Write(b)
+}
+
+func (c stationConn) Close() error {
+	c.s.close()
+	return nil
+}
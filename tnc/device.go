@@ -0,0 +1,199 @@
+// GoBalloon
+// device.go - A multi-port KISS TNC shared by a link for each of its radios
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/tarm/goserial"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// KISSDevice is a connection to a KISS TNC with a radio on each of several KISS ports,
+// shared by a link for each port.  The first link to open dials the TNC and the last to
+// close hangs up.  What the TNC hears is split between the links by port, so a frame
+// heard on one radio goes to that radio's link only.
+type KISSDevice struct {
+	ReadTimeout time.Duration // Network connections only.  0 to wait forever.
+
+	name  string
+	dial  func() (io.ReadWriteCloser, error)
+	conn  *deviceConn // The current connection, or nil if we're not connected
+	mutex sync.Mutex
+}
+
+// deviceConn is one connection to the TNC, and the ports open on it
+type deviceConn struct {
+	conn       io.ReadWriteCloser
+	demux      *ax25.KISSDemux
+	ports      map[int]bool
+	writeMutex sync.Mutex
+}
+
+// NewSerialKISSDevice gets a multi-port TNC on a serial port, e.g. /dev/ttyUSB0
+func NewSerialKISSDevice(device string, baud int) *KISSDevice {
+	return &KISSDevice{
+		name: "serial:" + device,
+		dial: func() (io.ReadWriteCloser, error) {
+			return serial.OpenPort(&serial.Config{Name: device, Baud: baud})
+		},
+	}
+}
+
+// NewTCPKISSDevice gets a multi-port network TNC, e.g. Direwolf with two radios
+func NewTCPKISSDevice(addr string) *KISSDevice {
+	return &KISSDevice{
+		name:        "tcp:" + addr,
+		ReadTimeout: DefaultReadTimeout,
+		dial: func() (io.ReadWriteCloser, error) {
+			return net.Dial("tcp", addr)
+		},
+	}
+}
+
+// NewKISSDevice gets a multi-port TNC over any connection
+func NewKISSDevice(name string, dial func() (io.ReadWriteCloser, error)) *KISSDevice {
+	return &KISSDevice{name: name, dial: dial}
+}
+
+func (d *KISSDevice) String() string {
+	return d.name
+}
+
+// Port gets a link to the radio on a KISS port of the TNC.  Set its Params to configure
+// that radio whenever the link opens.
+func (d *KISSDevice) Port(port int) *KISS {
+	name := d.name
+	if port != 0 {
+		name = fmt.Sprintf("%v#%v", d.name, port)
+	}
+
+	k := NewKISS(name, func() (io.ReadWriteCloser, error) {
+		return d.open(port)
+	})
+	k.Port = port
+	return k
+}
+
+// ExitKISS takes the TNC out of KISS mode, for when we're done with all of its radios
+func (d *KISSDevice) ExitKISS() error {
+	d.mutex.Lock()
+	c := d.conn
+	d.mutex.Unlock()
+
+	if c == nil {
+		return ErrNotOpen
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return ax25.ExitKISS(c.conn)
+}
+
+// open gets a port's share of the connection to the TNC, dialing it if need be
+func (d *KISSDevice) open(port int) (io.ReadWriteCloser, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.conn == nil {
+		conn, err := d.dial()
+		if err != nil {
+			return nil, err
+		}
+
+		// A network TNC that's gone quiet on every port has probably gone away
+		var r io.Reader = conn
+		if nc, ok := conn.(net.Conn); ok && d.ReadTimeout > 0 {
+			r = deadlineReader{nc, d.ReadTimeout}
+		}
+
+		d.conn = &deviceConn{
+			conn:  conn,
+			demux: ax25.NewKISSDemux(r),
+			ports: make(map[int]bool),
+		}
+		go d.run(d.conn)
+	}
+
+	r, err := d.conn.demux.Port(port)
+	if err != nil {
+		return nil, err
+	}
+	d.conn.ports[port] = true
+
+	return &devicePort{device: d, c: d.conn, port: port, r: r}, nil
+}
+
+// run hands out what the TNC hears until the connection fails.  Each port's link finds
+// out when its next read fails, and the first to reopen dials the TNC again.
+func (d *KISSDevice) run(c *deviceConn) {
+	c.demux.Run()
+
+	d.mutex.Lock()
+	if d.conn == c {
+		d.conn = nil
+	}
+	d.mutex.Unlock()
+
+	c.conn.Close()
+}
+
+// release gives up a port's share of a connection, and hangs up if it was the last
+func (d *KISSDevice) release(c *deviceConn, port int) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !c.ports[port] {
+		return nil
+	}
+	delete(c.ports, port)
+	c.demux.Release(port)
+
+	if len(c.ports) > 0 || d.conn != c {
+		// Still in use, or run has already hung up
+		return nil
+	}
+
+	d.conn = nil
+	return c.conn.Close()
+}
+
+// devicePort is a port's share of a connection: it reads the frames heard on its port,
+// and writes to the TNC
+type devicePort struct {
+	device *KISSDevice
+	c      *deviceConn
+	port   int
+	r      io.Reader
+}
+
+func (p *devicePort) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *devicePort) Write(b []byte) (int, error) {
+	p.c.writeMutex.Lock()
+	defer p.c.writeMutex.Unlock()
+	return p.c.conn.Write(b)
+}
+
+func (p *devicePort) Close() error {
+	return p.device.release(p.c, p.port)
+}
+
+// deadlineReader extends a network connection's read deadline before each read
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(b []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(b)
+}
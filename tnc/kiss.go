@@ -0,0 +1,187 @@
+// GoBalloon
+// kiss.go - Links to KISS TNCs over a serial port or TCP
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/tarm/goserial"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultSerialBaud is the speed of most serial TNCs
+const DefaultSerialBaud = 4800
+
+// DefaultReadTimeout is how long a network link can go without hearing anything before we
+// decide the connection is dead.  It's long enough for a quiet channel.
+const DefaultReadTimeout = 3 * time.Minute
+
+// KISS is a link to a KISS TNC
+type KISS struct {
+	health
+
+	Port        int             // KISS port of the radio to use on a multi-port TNC
+	Params      ax25.KISSParams // Sent to the TNC whenever the link opens
+	ReadTimeout time.Duration   // Network connections only.  0 to wait forever.
+
+	name       string
+	dial       func() (io.ReadWriteCloser, error)
+	conn       io.ReadWriteCloser
+	decoder    *ax25.Decoder
+	mutex      sync.Mutex // Guards conn and decoder
+	writeMutex sync.Mutex
+}
+
+// NewSerialKISS gets a link to a TNC on a serial port, e.g. /dev/ttyUSB0
+func NewSerialKISS(device string, baud int) *KISS {
+	return &KISS{
+		name: "serial:" + device,
+		dial: func() (io.ReadWriteCloser, error) {
+			return serial.OpenPort(&serial.Config{Name: device, Baud: baud})
+		},
+	}
+}
+
+// NewTCPKISS gets a link to a network TNC, e.g. tnc-server, Direwolf or an ESP32 modem
+func NewTCPKISS(addr string) *KISS {
+	return &KISS{
+		name:        "tcp:" + addr,
+		ReadTimeout: DefaultReadTimeout,
+		dial: func() (io.ReadWriteCloser, error) {
+			return net.Dial("tcp", addr)
+		},
+	}
+}
+
+// NewKISS gets a link to a TNC over any connection, e.g. a pipe to a simulated radio
+func NewKISS(name string, dial func() (io.ReadWriteCloser, error)) *KISS {
+	return &KISS{name: name, dial: dial}
+}
+
+func (k *KISS) String() string {
+	return k.name
+}
+
+// Open connects to the TNC and sends it our parameters
+func (k *KISS) Open() error {
+	conn, err := k.dial()
+	if err != nil {
+		k.failed(err)
+		return err
+	}
+
+	if k.Params != (ax25.KISSParams{}) {
+		err = ax25.ConfigureTNC(conn, k.Port, k.Params)
+		if err != nil {
+			conn.Close()
+			k.failed(err)
+			return err
+		}
+	}
+
+	k.mutex.Lock()
+	k.conn = conn
+	k.decoder = ax25.NewDecoder(conn)
+	k.mutex.Unlock()
+
+	k.opened()
+	return nil
+}
+
+// ReadFrame returns the next frame heard on our port
+func (k *KISS) ReadFrame() (ax25.Frame, error) {
+	k.mutex.Lock()
+	conn, decoder := k.conn, k.decoder
+	k.mutex.Unlock()
+
+	if conn == nil {
+		return ax25.Frame{}, ErrNotOpen
+	}
+
+	for {
+		// Only network connections time out; a serial port just waits for the TNC
+		if nc, ok := conn.(net.Conn); ok && k.ReadTimeout > 0 {
+			nc.SetReadDeadline(time.Now().Add(k.ReadTimeout))
+		}
+
+		f, err := decoder.NextFrame()
+		if err != nil && !ax25.IsFrameError(err) {
+			k.failed(err)
+			return ax25.Frame{}, err
+		}
+
+		if f.Port != k.Port {
+			// Heard on one of the TNC's other radios
+			continue
+		}
+
+		if err == nil {
+			k.read()
+		}
+		return f, err
+	}
+}
+
+// WriteFrame sends a frame to the TNC to transmit on our port
+func (k *KISS) WriteFrame(f ax25.Frame) error {
+	f.Port = k.Port
+	b, err := f.Encode()
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	conn := k.conn
+	k.mutex.Unlock()
+
+	if conn == nil {
+		return ErrNotOpen
+	}
+
+	k.writeMutex.Lock()
+	_, err = conn.Write(b)
+	k.writeMutex.Unlock()
+
+	if err != nil {
+		k.failed(err)
+		return err
+	}
+
+	k.wrote()
+	return nil
+}
+
+// ExitKISS takes the TNC out of KISS mode, for when we're done with it for good
+func (k *KISS) ExitKISS() error {
+	k.mutex.Lock()
+	conn := k.conn
+	k.mutex.Unlock()
+
+	if conn == nil {
+		return ErrNotOpen
+	}
+
+	k.writeMutex.Lock()
+	defer k.writeMutex.Unlock()
+	return ax25.ExitKISS(conn)
+}
+
+// Close disconnects from the TNC
+func (k *KISS) Close() error {
+	k.mutex.Lock()
+	conn := k.conn
+	k.conn = nil
+	k.mutex.Unlock()
+
+	k.closed()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
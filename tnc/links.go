@@ -0,0 +1,299 @@
+// GoBalloon
+// links.go - Several TNC links at once, e.g. a primary 2 m radio and a backup 70 cm radio
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetryInterval is how long we wait before reopening a link that's failed
+const DefaultRetryInterval = 5 * time.Second
+
+// DefaultLinkDupWindow is how long after hearing a frame on one link we ignore copies of it
+// heard on the others, e.g. from a cross-band digipeater
+const DefaultLinkDupWindow = 10 * time.Second
+
+// ErrLinksClosed is returned by Next once the links have been closed
+var ErrLinksClosed = errors.New("TNC links closed")
+
+// ErrNoTransmitter is returned by Send when every link is receive-only
+var ErrNoTransmitter = errors.New("No TNC link can transmit")
+
+// TXPolicy says what a link transmits
+type TXPolicy int
+
+const (
+	TXPrimary     TXPolicy = iota // Transmit everything
+	TXBackup                      // Transmit only when no primary link is up
+	TXReceiveOnly                 // Never transmit
+)
+
+var txPolicyNames = []string{"primary", "backup", "rx"}
+
+func (p TXPolicy) String() string {
+	if int(p) < len(txPolicyNames) {
+		return txPolicyNames[p]
+	}
+	return fmt.Sprintf("TXPolicy(%d)", int(p))
+}
+
+// ParseTXPolicy parses a policy's name: primary, backup or rx
+func ParseTXPolicy(s string) (TXPolicy, error) {
+	for i, n := range txPolicyNames {
+		if strings.EqualFold(s, n) {
+			return TXPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown TX policy %q; must be one of %v", s, strings.Join(txPolicyNames, ", "))
+}
+
+// Link is a transport and what we transmit on it
+type Link struct {
+	Transport Transport
+	TX        TXPolicy
+}
+
+// Received is a frame heard on one of the links
+type Received struct {
+	Frame ax25.Frame
+	Link  *Link
+}
+
+// Links runs several links to TNCs at once.  Everything heard on any of them comes out
+// of Next, and Send transmits according to each link's TXPolicy.  A frame heard on more
+// than one link within DupWindow comes out once; copies heard again on the same link, e.g.
+// from each digipeater that repeats it, all come out.
+type Links struct {
+	RetryInterval time.Duration
+	DupWindow     time.Duration
+	Debug         bool
+
+	links     []*Link
+	heard     chan Received
+	seen      map[string]heardOn
+	seenMutex sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// heardOn is where and when we last passed on a frame
+type heardOn struct {
+	link *Link
+	at   time.Time
+}
+
+// NewLinks gets a set of links.  Call Start to open them.
+func NewLinks(links ...*Link) *Links {
+	return &Links{
+		RetryInterval: DefaultRetryInterval,
+		DupWindow:     DefaultLinkDupWindow,
+		links:         links,
+		heard:         make(chan Received, 16),
+		seen:          make(map[string]heardOn),
+		done:          make(chan struct{}),
+	}
+}
+
+// Links returns the links
+func (l *Links) Links() []*Link {
+	return l.links
+}
+
+// Start opens every link and keeps them open, reopening any that fail, until Close
+func (l *Links) Start() {
+	for _, k := range l.links {
+		l.wg.Add(1)
+		go l.run(k)
+	}
+}
+
+// Up reports whether any link is open
+func (l *Links) Up() bool {
+	for _, k := range l.links {
+		if k.Transport.Health().Open {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the next frame heard on any link
+func (l *Links) Next() (Received, error) {
+	select {
+	case r := <-l.heard:
+		return r, nil
+	case <-l.done:
+		return Received{}, ErrLinksClosed
+	}
+}
+
+// Send transmits a frame on every primary link that's up.  If none are, it goes out on
+// the backup links instead.
+func (l *Links) Send(f ax25.Frame) error {
+	sent, errs := l.sendOn(TXPrimary, f)
+	if sent > 0 {
+		return nil
+	}
+
+	backups, berrs := l.sendOn(TXBackup, f)
+	errs = append(errs, berrs...)
+	if backups > 0 {
+		l.debugf("No primary link is up; sent on %v backup link(s)", backups)
+		return nil
+	}
+
+	if len(errs) == 0 {
+		return ErrNoTransmitter
+	}
+	return fmt.Errorf("Could not transmit on any TNC link: %v", strings.Join(errs, "; "))
+}
+
+// Close closes every link and stops reopening them
+func (l *Links) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		for _, k := range l.links {
+			k.Transport.Close()
+		}
+	})
+	l.wg.Wait()
+}
+
+// sendOn transmits a frame on each link with the given policy that's up, and returns how
+// many it went out on and what went wrong with the rest
+func (l *Links) sendOn(policy TXPolicy, f ax25.Frame) (sent int, errs []string) {
+	for _, k := range l.links {
+		if k.TX != policy {
+			continue
+		}
+		if !k.Transport.Health().Open {
+			errs = append(errs, fmt.Sprintf("%v is down", k.Transport))
+			continue
+		}
+		if err := k.Transport.WriteFrame(f); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", k.Transport, err))
+			continue
+		}
+		sent++
+	}
+	return
+}
+
+// run keeps a link open and passes on everything heard on it
+func (l *Links) run(k *Link) {
+	defer l.wg.Done()
+
+	log.Printf("tnc.Links.run(%v)", k.Transport)
+
+	for {
+		if l.closing() {
+			return
+		}
+
+		err := k.Transport.Open()
+		if err != nil {
+			log.Printf("Could not open TNC link %v: %v", k.Transport, err)
+			log.Printf("Sleeping %v and trying again", l.RetryInterval)
+			l.sleep()
+			continue
+		}
+		if l.closing() {
+			// Close got here first and won't see this
+			k.Transport.Close()
+			return
+		}
+		log.Printf("TNC link %v is up (TX %v)", k.Transport, k.TX)
+
+		for {
+			f, err := k.Transport.ReadFrame()
+			if ax25.IsFrameError(err) {
+				l.debugf("Skipping bad frame on %v: %v", k.Transport, err)
+				continue
+			}
+			if err != nil {
+				break
+			}
+
+			if l.duplicate(k, f) {
+				l.debugf("Ignoring %v from %v: already heard on another link", f.Kind(), f.Source)
+				continue
+			}
+
+			select {
+			case l.heard <- Received{Frame: f, Link: k}:
+			case <-l.done:
+				return
+			}
+		}
+
+		k.Transport.Close()
+		if l.closing() {
+			return
+		}
+
+		log.Printf("TNC link %v failed: %v", k.Transport, k.Transport.Health().LastError)
+		log.Printf("Sleeping %v and reconnecting", l.RetryInterval)
+		l.sleep()
+	}
+}
+
+// duplicate reports whether a frame heard on k was heard on another link within DupWindow,
+// and remembers where we heard it if it wasn't
+func (l *Links) duplicate(k *Link, f ax25.Frame) bool {
+	if l.DupWindow <= 0 || len(l.links) < 2 {
+		return false
+	}
+
+	// The path is left out, as each radio may have heard a different digipeater's copy
+	key := fmt.Sprintf("%v>%v %#02x %x", f.Source, f.Dest, f.Control, f.Info)
+	now := time.Now()
+
+	l.seenMutex.Lock()
+	defer l.seenMutex.Unlock()
+
+	for old, h := range l.seen {
+		if now.Sub(h.at) > l.DupWindow {
+			delete(l.seen, old)
+		}
+	}
+
+	if h, ok := l.seen[key]; ok && h.link != k {
+		return true
+	}
+
+	l.seen[key] = heardOn{link: k, at: now}
+	return false
+}
+
+func (l *Links) closing() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for RetryInterval, or until the links are closed
+func (l *Links) sleep() {
+	select {
+	case <-time.After(l.RetryInterval):
+	case <-l.done:
+	}
+}
+
+func (l *Links) debugf(format string, args ...interface{}) {
+	if l.Debug {
+		log.Printf("TNC links: "+format+"\n", args...)
+	}
+}
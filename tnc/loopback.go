@@ -0,0 +1,120 @@
+// GoBalloon
+// loopback.go - A link that hears its own transmissions, for testing without a radio
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"errors"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"sync"
+)
+
+// loopbackQueue is how many frames a Loopback holds before it starts dropping them
+const loopbackQueue = 64
+
+// ErrLoopbackBroken is returned when opening a Loopback that's been broken with SetBroken
+var ErrLoopbackBroken = errors.New("Loopback link is broken")
+
+// Loopback is a link whose reads return whatever was written to it
+type Loopback struct {
+	health
+
+	name   string
+	frames chan ax25.Frame
+	done   chan struct{}
+	broken bool
+	mutex  sync.Mutex // Guards done and broken
+}
+
+// NewLoopback gets a new loopback link
+func NewLoopback(name string) *Loopback {
+	return &Loopback{
+		name:   name,
+		frames: make(chan ax25.Frame, loopbackQueue),
+	}
+}
+
+func (l *Loopback) String() string {
+	return "loopback:" + l.name
+}
+
+// Open opens the link, unless it's broken
+func (l *Loopback) Open() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.broken {
+		l.failed(ErrLoopbackBroken)
+		return ErrLoopbackBroken
+	}
+
+	l.done = make(chan struct{})
+	l.opened()
+	return nil
+}
+
+// ReadFrame returns the next frame written to the link
+func (l *Loopback) ReadFrame() (ax25.Frame, error) {
+	l.mutex.Lock()
+	done := l.done
+	l.mutex.Unlock()
+
+	if done == nil {
+		return ax25.Frame{}, ErrNotOpen
+	}
+
+	select {
+	case f := <-l.frames:
+		l.read()
+		return f, nil
+	case <-done:
+		return ax25.Frame{}, ErrNotOpen
+	}
+}
+
+// WriteFrame queues a frame to be read back, or drops it if the queue is full, like a
+// TNC that can't keep up
+func (l *Loopback) WriteFrame(f ax25.Frame) error {
+	l.mutex.Lock()
+	done := l.done
+	l.mutex.Unlock()
+
+	if done == nil {
+		return ErrNotOpen
+	}
+
+	select {
+	case l.frames <- f:
+	default:
+	}
+
+	l.wrote()
+	return nil
+}
+
+// SetBroken breaks the link, as if the TNC had been unplugged, or repairs it.  A broken
+// link closes, and can't be opened again until it's repaired.
+func (l *Loopback) SetBroken(broken bool) {
+	l.mutex.Lock()
+	l.broken = broken
+	l.mutex.Unlock()
+
+	if broken {
+		l.Close()
+	}
+}
+
+// Close closes the link
+func (l *Loopback) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
+	l.closed()
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+// Runs simulated stations on a virtual RF channel: loss and delay, collisions with and
+// without carrier sense, digipeating to a station out of range of the sender, and a
+// cutdown command retried over a lossy channel until the balloon's message handling,
+// the aprs.Commands that APRSTNC uses, ACKs it
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"github.com/chrissnell/GoBalloon/tnc"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	balloon = ax25.APRSAddress{Callsign: "NW5W", SSID: 7}
+	chaser  = ax25.APRSAddress{Callsign: "N0CALL", SSID: 9}
+	far     = ax25.APRSAddress{Callsign: "N0CALL", SSID: 1}
+)
+
+// node is a simulated station: a KISS link to the channel and the packets it hears
+type node struct {
+	name  string
+	link  *tnc.KISS
+	heard chan ax25.APRSPacket
+}
+
+func attach(c *tnc.Channel, name string) *node {
+	n := &node{name: name, link: c.KISS(name), heard: make(chan ax25.APRSPacket, 256)}
+	if err := n.link.Open(); err != nil {
+		checks.Equal("open "+name, err, nil)
+	}
+	go func() {
+		for {
+			f, err := n.link.ReadFrame()
+			if ax25.IsFrameError(err) {
+				continue
+			}
+			if err != nil {
+				close(n.heard)
+				return
+			}
+			p, err := f.APRSPacket()
+			if err != nil {
+				continue
+			}
+			n.heard <- p
+		}
+	}()
+	return n
+}
+
+func (n *node) send(from ax25.APRSAddress, body string, path ...ax25.APRSAddress) {
+	f, err := ax25.CommandFrame(ax25.APRSPacket{Source: from, Path: path, Body: body})
+	if err == nil {
+		err = n.link.WriteFrame(f)
+	}
+	if err != nil {
+		checks.Equal(n.name+" send", err, nil)
+	}
+}
+
+// next returns the next packet the node hears, or false if it hears nothing for a while
+func (n *node) next(wait time.Duration) (ax25.APRSPacket, bool) {
+	select {
+	case p, ok := <-n.heard:
+		return p, ok
+	case <-time.After(wait):
+		return ax25.APRSPacket{}, false
+	}
+}
+
+// count counts what the node hears until it's been quiet for a while
+func (n *node) count(wait time.Duration) int {
+	i := 0
+	for {
+		if _, ok := n.next(wait); !ok {
+			return i
+		}
+		i++
+	}
+}
+
+func basics() {
+	c := tnc.NewChannel(1)
+	c.Baud = 0
+	c.Delay = 50 * time.Millisecond
+	a, b, d := attach(c, "a"), attach(c, "b"), attach(c, "d")
+
+	start := time.Now()
+	a.send(chaser, ">hello")
+	p, ok := b.next(time.Second)
+	checks.Equal("b heard a", ok && p.Body == ">hello", true)
+	checks.Equal("after the delay", time.Since(start) >= c.Delay, true)
+	p, ok = d.next(time.Second)
+	checks.Equal("d heard a", ok && p.Body == ">hello", true)
+	_, ok = a.next(100 * time.Millisecond)
+	checks.Equal("a didn't hear itself", ok, false)
+
+	// Configuring the TNC doesn't put anything on the air
+	a.link.Close()
+	txdelay, persist := 300*time.Millisecond, 63
+	a.link.Params = ax25.KISSParams{TXDelay: &txdelay, Persistence: &persist}
+	checks.Equal("reopen with parameters", a.link.Open(), nil)
+	checks.Equal("parameters not transmitted", b.count(100*time.Millisecond), 0)
+	checks.Equal("stations", fmt.Sprintf("%+v", c.Stats()), "{Sent:1 Heard:2 Lost:0 Collided:0}")
+	c.Close()
+}
+
+func loss() {
+	c := tnc.NewChannel(2)
+	c.Baud = 0
+	c.Loss = 0.5
+	a, b := attach(c, "a"), attach(c, "b")
+
+	for i := 0; i < 200; i++ {
+		a.send(chaser, fmt.Sprintf(">%v", i))
+	}
+	heard := b.count(200 * time.Millisecond)
+	checks.Equal("about half heard", heard > 60 && heard < 140, true)
+
+	s := c.Stats()
+	checks.Equal("all sent", s.Sent, 200)
+	checks.Equal("heard or lost", s.Heard+s.Lost, 200)
+	checks.Equal("heard counted", s.Heard, heard)
+	c.Close()
+}
+
+// collide has two stations transmit at once, and returns how many frames a third hears
+func collide(carrierSense bool) (int, tnc.ChannelStats) {
+	c := tnc.NewChannel(3)
+	c.CarrierSense = carrierSense
+	a, b, d := attach(c, "a"), attach(c, "b"), attach(c, "d")
+
+	var wg sync.WaitGroup
+	for _, n := range []*node{a, b} {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			n.send(chaser, ">"+strings.Repeat(n.name, 40))
+		}(n)
+	}
+	wg.Wait()
+
+	heard := d.count(time.Second)
+	s := c.Stats()
+	c.Close()
+	return heard, s
+}
+
+func collisions() {
+	heard, s := collide(false)
+	checks.Equal("collision heard", heard, 0)
+	checks.Equal("collision counted", s.Collided, 2)
+
+	heard, s = collide(true)
+	checks.Equal("carrier sense heard", heard, 2)
+	checks.Equal("carrier sense collisions", s.Collided, 0)
+}
+
+func digipeating() {
+	// The chase car and the far station are out of range of each other, but both can
+	// hear the balloon
+	c := tnc.NewChannel(4)
+	c.Hears = func(from, to string) bool {
+		return !(from == "chaser" && to == "far" || from == "far" && to == "chaser")
+	}
+	car, up, away := attach(c, "chaser"), attach(c, "balloon"), attach(c, "far")
+
+	digi := ax25.NewDigipeater(balloon, func(p ax25.APRSPacket) error {
+		f, err := ax25.CommandFrame(p)
+		if err != nil {
+			return err
+		}
+		return up.link.WriteFrame(f)
+	})
+	go func() {
+		for p := range up.heard {
+			digi.Handle(p)
+		}
+	}()
+
+	car.send(chaser, ">via the balloon", ax25.APRSAddress{Callsign: "WIDE2", SSID: 1})
+	p, ok := away.next(time.Second)
+	checks.Equal("far station heard", ok && p.Body == ">via the balloon", true)
+	checks.Equal("path", fmt.Sprint(p.Path), "[NW5W-7* WIDE2*]")
+	checks.Equal("heard once", away.count(300*time.Millisecond), 0)
+
+	p, ok = car.next(time.Second)
+	checks.Equal("chaser heard its packet repeated", ok && p.HeardFrom().Callsign == balloon.Callsign && p.HeardFrom().SSID == balloon.SSID, true)
+
+	// Nothing to repeat on a packet with no path
+	away.send(far, ">direct")
+	checks.Equal("no path not repeated", car.count(300*time.Millisecond), 0)
+	c.Close()
+}
+
+// balloon runs the balloon's side of the channel the way APRSTNC does: two radios on
+// tnc.Links, whose packets go to the same aprs.Commands that APRSTNC answers messages
+// with.  It returns how many times the cutdown has run so far.
+func flight(c *tnc.Channel) (*tnc.Links, func() int) {
+	links := tnc.NewLinks(&tnc.Link{Transport: c.KISS("balloon-2m")}, &tnc.Link{Transport: c.KISS("balloon-70cm")})
+	links.RetryInterval = 50 * time.Millisecond
+	links.Start()
+
+	commands := aprs.NewCommands(balloon, func(body string) error {
+		f, err := ax25.CommandFrame(ax25.APRSPacket{Source: balloon, Body: body})
+		if err != nil {
+			return err
+		}
+		return links.Send(f)
+	})
+
+	var cutdowns int
+	var mutex sync.Mutex
+	commands.Actions["CUTDOWN"] = func(aprs.Message) {
+		mutex.Lock()
+		cutdowns++
+		mutex.Unlock()
+	}
+
+	go func() {
+		for {
+			r, err := links.Next()
+			if err != nil {
+				return
+			}
+			p, err := r.Frame.APRSPacket()
+			if err != nil {
+				continue
+			}
+			commands.Handle(aprs.ParsePacket(&p).Message)
+		}
+	}()
+
+	return links, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return cutdowns
+	}
+}
+
+// acks sends a message until it hears it ACKed, as the chase team would, and returns how
+// many tries it took
+func acks(n *node, msg, id string) (int, bool) {
+	for tries := 1; tries <= 10; tries++ {
+		n.send(chaser, msg)
+
+		deadline := time.After(500 * time.Millisecond)
+		for {
+			select {
+			case p := <-n.heard:
+				ad := aprs.ParsePacket(&p)
+				if ad.Message.ACK && ad.Message.ID == id {
+					return tries, true
+				}
+				continue
+			case <-deadline:
+			}
+			break
+		}
+	}
+	return 10, false
+}
+
+func cutdown() {
+	c := tnc.NewChannel(5)
+	c.Loss = 0.4
+	c.CarrierSense = true
+
+	// However lucky the dice, the balloon misses the first try
+	var missed bool
+	c.Hears = func(from, to string) bool {
+		if from == "ground" && !missed {
+			missed = true
+			return false
+		}
+		return true
+	}
+	ground := attach(c, "ground")
+	links, cutdowns := flight(c)
+	waitUp(links)
+
+	msg, _ := aprs.CreateMessage(aprs.Message{Recipient: balloon, Text: "CUTDOWN", ID: "42"})
+	tries, acked := acks(ground, msg, "42")
+	checks.Equal("cutdown ACKed", acked, true)
+	checks.True(tries > 1, "retried until ACKed: %v tries", tries)
+
+	// A retry sent before the ACK got through is ACKed again, but doesn't cut down again
+	_, acked = acks(ground, msg, "42")
+	checks.Equal("late retry ACKed", acked, true)
+	time.Sleep(100 * time.Millisecond)
+	checks.Equal("cut down once", cutdowns(), 1)
+
+	// Other messages are ACKed without doing anything, and ACKs aren't answered
+	other, _ := aprs.CreateMessage(aprs.Message{Recipient: balloon, Text: "How high?", ID: "43"})
+	_, acked = acks(ground, other, "43")
+	checks.Equal("other message ACKed", acked, true)
+	ground.count(time.Second)
+	ack, _ := aprs.CreateMessageACK(aprs.Message{Sender: balloon, ID: "7"})
+	ground.send(chaser, ack)
+	checks.Equal("ACK not answered", ground.count(time.Second), 0)
+	checks.Equal("still cut down once", cutdowns(), 1)
+
+	s := c.Stats()
+	checks.Equal("no collisions with carrier sense", s.Collided, 0)
+	links.Close()
+	c.Close()
+}
+
+func waitUp(l *tnc.Links) {
+	for i := 0; i < 100 && !l.Up(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	checks.Equal("links up", l.Up(), true)
+}
+
+func main() {
+	basics()
+	loss()
+	collisions()
+	digipeating()
+	cutdown()
+
+	c := tnc.NewChannel(6)
+	c.Close()
+	_, err := c.Attach("late")
+	checks.Equal("attach after close", err, tnc.ErrChannelClosed)
+
+	checks.Done()
+}
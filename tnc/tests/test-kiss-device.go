@@ -0,0 +1,219 @@
+package main
+
+// Runs two links over one connection to a two-port KISS TNC, as -tnc does with
+// serial:/dev/ttyUSB0#0,serial:/dev/ttyUSB0#1
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"github.com/chrissnell/GoBalloon/tnc"
+	"io"
+	"sync"
+	"time"
+)
+
+func frame(body string) ax25.Frame {
+	f, err := ax25.CommandFrame(ax25.APRSPacket{Source: ax25.APRSAddress{Callsign: "N0CALL", SSID: 11}, Body: body})
+	if err != nil {
+		checks.Equal("frame", err, nil)
+	}
+	return f
+}
+
+// fakeTNC is a two-port KISS TNC at the far end of a pair of pipes, like a serial port.
+// It remembers each connection so that we can hear on it, see what the host sent and
+// hang it up.
+type fakeTNC struct {
+	conns []*tncConn
+	mutex sync.Mutex
+}
+
+type tncConn struct {
+	toHost *io.PipeWriter
+	sent   chan string // What the host wrote, one write at a time
+	closed bool
+	mutex  sync.Mutex
+}
+
+type pipeConn struct {
+	io.Reader
+	io.Writer
+	closer func()
+}
+
+func (p pipeConn) Close() error {
+	p.closer()
+	return nil
+}
+
+func (t *fakeTNC) dial() (io.ReadWriteCloser, error) {
+	fromTNC, toHost := io.Pipe()
+	fromHost, toTNC := io.Pipe()
+	c := &tncConn{toHost: toHost, sent: make(chan string, 10)}
+
+	go func() {
+		b := make([]byte, 100)
+		for {
+			n, err := fromHost.Read(b)
+			if err != nil {
+				return
+			}
+			c.sent <- fmt.Sprintf("% x", b[:n])
+		}
+	}()
+
+	t.mutex.Lock()
+	t.conns = append(t.conns, c)
+	t.mutex.Unlock()
+
+	return pipeConn{fromTNC, toTNC, func() {
+		c.mutex.Lock()
+		c.closed = true
+		c.mutex.Unlock()
+		fromTNC.Close()
+		toTNC.Close()
+	}}, nil
+}
+
+func (t *fakeTNC) dials() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.conns)
+}
+
+func (t *fakeTNC) conn() *tncConn {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conns[len(t.conns)-1]
+}
+
+// hear has the TNC hear a frame on one of its radios
+func (c *tncConn) hear(port int, body string) {
+	f := frame(body)
+	f.Port = port
+	b, _ := f.Encode()
+	go c.toHost.Write(b)
+}
+
+// next returns the next thing the host wrote, or "nothing"
+func (c *tncConn) next() string {
+	select {
+	case s := <-c.sent:
+		return s
+	case <-time.After(time.Second):
+		return "nothing"
+	}
+}
+
+func (c *tncConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// read returns the body of the next frame heard on a link, or its error
+func read(k *tnc.KISS) string {
+	got := make(chan string, 1)
+	go func() {
+		f, err := k.ReadFrame()
+		if err != nil {
+			got <- "error"
+			return
+		}
+		got <- string(f.Info)
+	}()
+
+	select {
+	case s := <-got:
+		return s
+	case <-time.After(time.Second):
+		return "nothing"
+	}
+}
+
+func waitFor(what string, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			checks.Equal(what, true, true)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	checks.Equal(what, false, true)
+}
+
+func main() {
+	t := &fakeTNC{}
+	d := tnc.NewKISSDevice("fake", t.dial)
+	a, b := d.Port(0), d.Port(1)
+	txdelay := 300 * time.Millisecond
+	b.Params = ax25.KISSParams{TXDelay: &txdelay}
+	checks.Equal("port 0 name", a.String(), "fake")
+	checks.Equal("port 1 name", b.String(), "fake#1")
+
+	checks.Equal("device down", d.ExitKISS(), tnc.ErrNotOpen)
+
+	// Both links share one connection, and only port 1 gets parameters
+	checks.Equal("open port 0", a.Open(), nil)
+	checks.Equal("open port 1", b.Open(), nil)
+	checks.Equal("dialled once", t.dials(), 1)
+	c := t.conn()
+	checks.Equal("TXDELAY sent to port 1", c.next(), "c0 11 1e c0")
+
+	// Each link transmits on its own radio
+	checks.Equal("send on port 0", a.WriteFrame(frame(">a")), nil)
+	checks.Equal("sent on port 0", c.next()[:5], "c0 00")
+	checks.Equal("send on port 1", b.WriteFrame(frame(">b")), nil)
+	checks.Equal("sent on port 1", c.next()[:5], "c0 10")
+
+	// ...and hears only its own radio
+	c.hear(1, ">heard on 1")
+	checks.Equal("port 1 hears port 1", read(b), ">heard on 1")
+	c.hear(0, ">heard on 0")
+	checks.Equal("port 0 hears port 0", read(a), ">heard on 0")
+	c.hear(2, ">heard on 2")
+	c.hear(1, ">heard on 1 again")
+	checks.Equal("nobody hears port 2", read(b), ">heard on 1 again")
+
+	// Closing one link leaves the other up on the same connection
+	checks.Equal("close port 0", a.Close(), nil)
+	checks.Equal("still connected", c.isClosed(), false)
+	c.hear(1, ">still here")
+	checks.Equal("port 1 still hears", read(b), ">still here")
+
+	// The port that's closed can be reopened on the same connection
+	checks.Equal("reopen port 0", a.Open(), nil)
+	checks.Equal("still dialled once", t.dials(), 1)
+
+	checks.Equal("exit KISS", d.ExitKISS(), nil)
+	checks.Equal("exit KISS sent once", c.next(), "c0 ff c0")
+	checks.Equal("nothing else sent", c.next(), "nothing")
+
+	// Closing the last link hangs up
+	a.Close()
+	b.Close()
+	waitFor("hung up after the last link", c.isClosed)
+
+	// The TNC hanging up fails every link, and the first to reopen dials it again
+	a.Open()
+	b.Open()
+	checks.Equal("dialled again", t.dials(), 2)
+	c = t.conn()
+	c.next() // TXDELAY
+	c.toHost.Close()
+	checks.Equal("port 0 fails", read(a), "error")
+	checks.Equal("port 1 fails", read(b), "error")
+	a.Close()
+	b.Close()
+
+	checks.Equal("open after hang-up", b.Open(), nil)
+	checks.Equal("redialled", t.dials(), 3)
+	c = t.conn()
+	checks.Equal("TXDELAY sent again", c.next(), "c0 11 1e c0")
+	c.hear(1, ">back")
+	checks.Equal("port 1 hears again", read(b), ">back")
+	b.Close()
+
+	checks.Done()
+}
@@ -0,0 +1,225 @@
+package main
+
+// Runs several links at once: a primary, a backup and a receive-only link, with the
+// primary failing and coming back, and a KISS link over a pipe like a serial port
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"github.com/chrissnell/GoBalloon/internal/checks"
+	"github.com/chrissnell/GoBalloon/tnc"
+	"io"
+	"sync"
+	"time"
+)
+
+func frame(body string) ax25.Frame {
+	f, err := ax25.CommandFrame(ax25.APRSPacket{Source: ax25.APRSAddress{Callsign: "N0CALL", SSID: 11}, Body: body})
+	if err != nil {
+		checks.Equal("frame", err, nil)
+	}
+	return f
+}
+
+// listen reports where each frame is heard until the links are closed
+func listen(l *tnc.Links) chan string {
+	got := make(chan string, 10)
+	go func() {
+		for {
+			r, err := l.Next()
+			if err != nil {
+				close(got)
+				return
+			}
+			got <- fmt.Sprintf("%v %v", r.Link.Transport, string(r.Frame.Info))
+		}
+	}()
+	return got
+}
+
+// heard returns where the next frame was heard, or "nothing"
+func heard(got chan string) string {
+	select {
+	case s := <-got:
+		return s
+	case <-time.After(time.Second):
+		return "nothing"
+	}
+}
+
+func waitFor(what string, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			checks.Equal(what, true, true)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	checks.Equal(what, false, true)
+}
+
+// pipeTNC is a KISS TNC at the far end of a pair of pipes, like a serial port.  Each
+// time it's dialled it hears on port 0 and port 1, then hangs up.
+type pipeTNC struct {
+	dials  int
+	params [][]byte
+	mutex  sync.Mutex
+}
+
+type pipeConn struct {
+	io.Reader
+	io.Writer
+	closer func()
+}
+
+func (p pipeConn) Close() error {
+	p.closer()
+	return nil
+}
+
+func (t *pipeTNC) dial() (io.ReadWriteCloser, error) {
+	t.mutex.Lock()
+	t.dials++
+	t.mutex.Unlock()
+
+	fromTNC, toHost := io.Pipe()
+	fromHost, toTNC := io.Pipe()
+
+	// What the host sends
+	go func() {
+		b := make([]byte, 100)
+		for {
+			n, err := fromHost.Read(b)
+			if err != nil {
+				return
+			}
+			t.mutex.Lock()
+			t.params = append(t.params, append([]byte{}, b[:n]...))
+			t.mutex.Unlock()
+		}
+	}()
+
+	// What the TNC hears
+	go func() {
+		for _, port := range []int{1, 0} {
+			f := frame(fmt.Sprintf(">port %v", port))
+			f.Port = port
+			b, _ := f.Encode()
+			toHost.Write(b)
+		}
+		toHost.Close()
+	}()
+
+	return pipeConn{fromTNC, toTNC, func() { fromTNC.Close(); toTNC.Close() }}, nil
+}
+
+func main() {
+	primary := tnc.NewLoopback("2m")
+	backup := tnc.NewLoopback("70cm")
+	rx := tnc.NewLoopback("scanner")
+
+	links := tnc.NewLinks(
+		&tnc.Link{Transport: primary, TX: tnc.TXPrimary},
+		&tnc.Link{Transport: backup, TX: tnc.TXBackup},
+		&tnc.Link{Transport: rx, TX: tnc.TXReceiveOnly},
+	)
+	links.RetryInterval = 50 * time.Millisecond
+	links.Start()
+	got := listen(links)
+	waitFor("all links up", func() bool {
+		return primary.Health().Open && backup.Health().Open && rx.Health().Open
+	})
+
+	checks.Equal("send on primary", links.Send(frame(">one")), nil)
+	checks.Equal("heard on primary", heard(got), "loopback:2m >one")
+	checks.Equal("nothing else", heard(got), "nothing")
+
+	// The primary radio dies
+	primary.SetBroken(true)
+	checks.Equal("primary down", primary.Health().Open, false)
+	checks.Equal("send on backup", links.Send(frame(">two")), nil)
+	checks.Equal("heard on backup", heard(got), "loopback:70cm >two")
+	checks.Equal("receive-only never transmits", rx.Health().Writes, 0)
+
+	// ...and comes back
+	primary.SetBroken(false)
+	waitFor("primary back up", func() bool { return primary.Health().Open })
+	checks.Equal("send on primary again", links.Send(frame(">three")), nil)
+	checks.Equal("heard on primary again", heard(got), "loopback:2m >three")
+	checks.Equal("backup sent once", backup.Health().Writes, 1)
+
+	// Nothing can transmit
+	backup.SetBroken(true)
+	primary.SetBroken(true)
+	checks.Equal("everything down", links.Send(frame(">four")) != nil, true)
+
+	links.Close()
+	_, err := links.Next()
+	checks.Equal("closed", err, tnc.ErrLinksClosed)
+	_, open := <-got
+	checks.Equal("listener done", open, false)
+
+	// Two radios that hear each other's traffic, e.g. through a cross-band digipeater
+	twoM, seventy := tnc.NewLoopback("2m"), tnc.NewLoopback("70cm")
+	both := tnc.NewLinks(&tnc.Link{Transport: twoM}, &tnc.Link{Transport: seventy})
+	both.RetryInterval = 50 * time.Millisecond
+	both.DupWindow = 200 * time.Millisecond
+	both.Start()
+	got = listen(both)
+	waitFor("both links up", func() bool { return twoM.Health().Open && seventy.Health().Open })
+
+	checks.Equal("send on both", both.Send(frame(">cutdown")), nil)
+	first := heard(got)
+	checks.True(first == "loopback:2m >cutdown" || first == "loopback:70cm >cutdown", "heard on one link: %v", first)
+	checks.Equal("not again on the other", heard(got), "nothing")
+
+	twoM.WriteFrame(frame(">digipeated"))
+	twoM.WriteFrame(frame(">digipeated"))
+	checks.Equal("first copy on one link", heard(got), "loopback:2m >digipeated")
+	checks.Equal("second copy on the same link", heard(got), "loopback:2m >digipeated")
+	seventy.WriteFrame(frame(">digipeated"))
+	checks.Equal("copy on the other link", heard(got), "nothing")
+
+	time.Sleep(both.DupWindow)
+	seventy.WriteFrame(frame(">digipeated"))
+	checks.Equal("copy on the other link after the window", heard(got), "loopback:70cm >digipeated")
+	both.Close()
+
+	onlyRX := tnc.NewLinks(&tnc.Link{Transport: tnc.NewLoopback("rx"), TX: tnc.TXReceiveOnly})
+	onlyRX.Start()
+	checks.Equal("receive-only links", onlyRX.Send(frame(">five")), tnc.ErrNoTransmitter)
+	onlyRX.Close()
+
+	for _, s := range []string{"primary", "backup", "rx", "Backup"} {
+		p, err := tnc.ParseTXPolicy(s)
+		checks.Equal("parse "+s, fmt.Sprint(p, err), fmt.Sprintf("%v <nil>", map[string]string{"primary": "primary", "backup": "backup", "rx": "rx", "Backup": "backup"}[s]))
+	}
+	_, err = tnc.ParseTXPolicy("sometimes")
+	checks.Equal("bad policy", err != nil, true)
+
+	// A KISS TNC on a pipe, which has no read deadlines, with a read timeout set anyway.
+	// We only hear our own port, the TNC gets our parameters each time we connect, and
+	// we reconnect when it hangs up.
+	pt := &pipeTNC{}
+	k := tnc.NewKISS("pipe", pt.dial)
+	k.Port = 1
+	k.ReadTimeout = time.Minute
+	txdelay := 300 * time.Millisecond
+	k.Params = ax25.KISSParams{TXDelay: &txdelay}
+
+	links = tnc.NewLinks(&tnc.Link{Transport: k})
+	links.RetryInterval = 50 * time.Millisecond
+	links.Start()
+	got = listen(links)
+	checks.Equal("KISS heard port 1", heard(got), "pipe >port 1")
+	checks.Equal("KISS heard port 1 after reconnecting", heard(got), "pipe >port 1")
+	links.Close()
+
+	pt.mutex.Lock()
+	checks.Equal("reconnected", pt.dials >= 2, true)
+	checks.Equal("TXDELAY sent", fmt.Sprintf("% x", pt.params[0]), "c0 11 1e c0")
+	pt.mutex.Unlock()
+	checks.Equal("KISS errors counted", k.Health().Errors >= 2, true)
+
+	checks.Done()
+}
@@ -0,0 +1,101 @@
+// GoBalloon
+// transport.go - Pluggable links to TNCs: KISS over serial or TCP, AGWPE, or a loopback
+//
+// (c) 2014, Christopher Snell
+
+package tnc
+
+import (
+	"errors"
+	"github.com/chrissnell/GoBalloon/ax25"
+	"sync"
+	"time"
+)
+
+// ErrNotOpen is returned for reads and writes on a link that isn't open
+var ErrNotOpen = errors.New("Link is not open")
+
+// Transport is a link to a TNC that carries AX.25 frames.  ReadFrame is called from one
+// goroutine at a time, but WriteFrame may be called from any goroutine, at any time.
+type Transport interface {
+	// Open connects to the TNC.  A Transport can be opened again after it's closed.
+	Open() error
+
+	// ReadFrame returns the next frame the TNC hears.  If ax25.IsFrameError is true of the
+	// error, only that frame was bad; any other error means the link is down and must be
+	// closed and reopened.
+	ReadFrame() (ax25.Frame, error)
+
+	// WriteFrame transmits a frame
+	WriteFrame(f ax25.Frame) error
+
+	// Close disconnects from the TNC
+	Close() error
+
+	// Health reports on how the link is doing
+	Health() Health
+
+	// String names the link in logs, e.g. tcp:10.50.0.25:6700
+	String() string
+}
+
+// Health describes the state of a link
+type Health struct {
+	Open      bool
+	Opened    time.Time // When the link was last opened
+	LastRead  time.Time
+	LastWrite time.Time
+	Reads     int
+	Writes    int
+	Errors    int
+	LastError error
+}
+
+// health keeps track of a Transport's Health.  Transports embed it.
+type health struct {
+	h     Health
+	mutex sync.Mutex
+}
+
+// Health returns a snapshot of the link's health
+func (h *health) Health() Health {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.h
+}
+
+func (h *health) opened() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.h.Open = true
+	h.h.Opened = time.Now()
+}
+
+func (h *health) closed() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.h.Open = false
+}
+
+func (h *health) read() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.h.LastRead = time.Now()
+	h.h.Reads++
+}
+
+func (h *health) wrote() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.h.LastWrite = time.Now()
+	h.h.Writes++
+}
+
+// failed records an error that brings the link down.  It stays down until it's reopened.
+func (h *health) failed(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.h.Open = false
+	h.h.Errors++
+	h.h.LastError = err
+}
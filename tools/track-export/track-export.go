@@ -0,0 +1,167 @@
+// GoBalloon
+// track-export.go - Converts a flight log or an APRS-IS raw log into KML, GPX or GeoJSON
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/chrissnell/GoBalloon/aprs"
+	"github.com/chrissnell/GoBalloon/aprsis"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"github.com/chrissnell/GoBalloon/gps"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var altitudeRE = regexp.MustCompile(`/A=(-?\d{5,6})`)
+
+func main() {
+	in := flag.String("in", "", "Flight log (gpsd JSON, NMEA, GPX or CSV) or APRS-IS raw log to read")
+	out := flag.String("out", "", "File to write.  The format comes from the extension (.kml, .gpx, .geojson) unless -format is given.  Defaults to stdout.")
+	format := flag.String("format", "", "Output format: kml, gpx or geojson")
+	aprslog := flag.Bool("aprs", false, "Read -in as an APRS-IS raw log instead of a GPS flight log")
+	call := flag.String("call", "", "With -aprs, only use position reports from this callsign (e.g. N0CALL-11)")
+	name := flag.String("name", "", "Name of the track (defaults to the callsign or the log file name)")
+
+	flag.Parse()
+
+	if len(*in) == 0 {
+		log.Fatalln("Must provide a log file with -in")
+	}
+
+	if len(*format) == 0 {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+	}
+	if *format == "json" {
+		*format = "geojson"
+	}
+
+	if len(*name) == 0 {
+		*name = *call
+		if len(*name) == 0 {
+			*name = strings.TrimSuffix(filepath.Base(*in), filepath.Ext(*in))
+		}
+	}
+
+	var track []geospatial.Point
+	var err error
+
+	if *aprslog {
+		track, err = loadAPRSLog(*in, *call)
+	} else {
+		track, err = gps.LoadTrack(*in)
+	}
+	if err != nil {
+		log.Fatalf("Could not load %v: %v\n", *in, err)
+	}
+	log.Printf("Loaded %v points from %v\n", len(track), *in)
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "kml":
+		err = geospatial.WriteKML(w, *name, track)
+	case "gpx":
+		err = geospatial.WriteGPX(w, *name, track)
+	case "geojson", "":
+		err = geospatial.WriteGeoJSON(w, *name, track)
+	default:
+		log.Fatalf("Unknown output format %q.  Use kml, gpx or geojson.\n", *format)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// loadAPRSLog reads position reports from an APRS-IS raw log, one TNC2-format packet per
+// line as written by the APRS-IS client's raw log.  A line may start with an RFC3339 or
+// Unix timestamp recording when the packet was heard; otherwise the time is taken from
+// the packet itself, if it has one.
+func loadAPRSLog(filename, call string) ([]geospatial.Point, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The APRS decoders are chatty about packets they can't decode.  We don't care.
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var track []geospatial.Point
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var heard time.Time
+		if fields := strings.SplitN(line, " ", 2); len(fields) == 2 && !strings.Contains(fields[0], ">") {
+			if t, err := time.Parse(time.RFC3339, fields[0]); err == nil {
+				heard = t
+			} else if secs, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				heard = time.Unix(secs, 0)
+			}
+			if !heard.IsZero() {
+				line = fields[1]
+			}
+		}
+
+		pkt := aprsis.ParseAPRSISPacket(line)
+		if len(pkt.Body) == 0 {
+			continue
+		}
+		if len(call) > 0 && !strings.EqualFold(pkt.Source.String(), call) {
+			continue
+		}
+
+		ad := aprs.ParsePacket(&pkt)
+		p := ad.Position
+		if p.Lat == 0 && p.Lon == 0 {
+			continue
+		}
+
+		if p.Altitude == 0 {
+			if m := altitudeRE.FindStringSubmatch(pkt.OriginalBody); m != nil {
+				p.Altitude, _ = strconv.ParseFloat(m[1], 64)
+			}
+		}
+
+		if !heard.IsZero() {
+			p.Time = heard
+		}
+
+		track = append(track, p)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(track) == 0 {
+		return nil, fmt.Errorf("No position reports found")
+	}
+
+	return track, nil
+}
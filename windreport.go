@@ -0,0 +1,105 @@
+// GoBalloon
+// windreport.go - Logs the observed wind profile and sends it down to the chase team
+//
+// (c) 2014, Christopher Snell
+
+package main
+
+import (
+	"fmt"
+	"github.com/chrissnell/GoBalloon/geospatial"
+	"log"
+	"math"
+	"os"
+)
+
+// windReporter watches the balloon climb through the wind profile.  Each time the balloon
+// leaves a summary layer behind, the profile is rewritten to disk and the finished layer
+// is messaged to the chase team.
+type windReporter struct {
+	wind     *geospatial.WindProfile
+	logfile  string
+	band     float64
+	reported int
+	climbed  bool
+}
+
+func newWindReporter(wind *geospatial.WindProfile, logfile string, band float64) *windReporter {
+	return &windReporter{
+		wind:     wind,
+		logfile:  logfile,
+		band:     band,
+		reported: math.MinInt32,
+	}
+}
+
+// Update looks for newly completed layers.  It should only be called while ascending.
+func (w *windReporter) Update(pos geospatial.Point) {
+	w.climbed = true
+
+	if w.band <= 0 {
+		return
+	}
+
+	current := int(math.Floor(pos.Altitude / w.band))
+
+	if w.reported == math.MinInt32 {
+		// The layer we launched in isn't complete until we've climbed out of it
+		w.reported = current - 1
+		return
+	}
+
+	if current <= w.reported+1 {
+		return
+	}
+
+	w.writeLog()
+
+	for _, l := range w.wind.Summary(w.band) {
+		c := int(math.Floor(l.Floor / w.band))
+		if c > w.reported && c < current {
+			w.send(l)
+		}
+	}
+
+	w.reported = current - 1
+}
+
+// Finish writes out the final profile, e.g. once we've burst or started to float.  If we
+// never saw the climb, e.g. because we restarted during the descent, the log from before
+// the restart is left alone.
+func (w *windReporter) Finish() {
+	if !w.climbed {
+		return
+	}
+	w.writeLog()
+}
+
+func (w *windReporter) send(l geospatial.WindLayer) {
+	log.Printf("Wind layer complete: %v\n", l)
+
+	select {
+	case aprsMessage <- fmt.Sprintf("WIND %v", l):
+	default:
+		log.Println("APRS transmitter busy.  Dropping wind report.")
+	}
+}
+
+func (w *windReporter) writeLog() {
+	if len(w.logfile) == 0 {
+		return
+	}
+
+	// We rewrite the whole file each time so that it's always a complete, valid CSV
+	f, err := os.Create(w.logfile)
+	if err != nil {
+		log.Printf("Could not create wind log %v: %v\n", w.logfile, err)
+		return
+	}
+	defer f.Close()
+
+	err = w.wind.WriteCSV(f)
+	if err != nil {
+		log.Printf("Could not write wind log %v: %v\n", w.logfile, err)
+	}
+}